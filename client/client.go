@@ -1,24 +1,39 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	ws "github.com/gorilla/websocket"
 )
 
-const serverAddr = "SERVER_URL"
+const (
+	serverAddr  = "SERVER_URL"
+	pingEnv     = "PING_INTERVAL" // интервал пингов, например "30s"
+	pongWaitEnv = "PONG_WAIT"     // сколько ждём ответа от сервера, например "60s"
+)
 
 const (
 	retries       = 1000
 	retryInterval = 5 * time.Second
 )
 
+// настройки пульса соединения по умолчанию
+var (
+	pingInterval = 30 * time.Second
+	pongWait     = 60 * time.Second
+)
+
 var ErrRetryExceeded = errors.New("connect: number of retries exceeded")
 
 func connect(url url.URL, retries int, interval time.Duration) (*ws.Conn, error) {
@@ -36,12 +51,159 @@ func connect(url url.URL, retries int, interval time.Duration) (*ws.Conn, error)
 		}
 
 		log.Printf("connection to %s established", url.String())
+		heartbeat(c, pingInterval, pongWait)
 		return c, nil
 	}
 
 	return nil, ErrRetryExceeded
 }
 
+// envelope - сообщение сервера.
+type envelope struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	Seq   uint64 `json:"seq"`
+	Error string `json:"error"`
+}
+
+// offsets - последние полученные номера сообщений по каналам.
+type offsets map[string]uint64
+
+// track запоминает номер сообщения сервера.
+func (o offsets) track(b []byte) {
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil || e.Label == "" {
+		return
+	}
+	if e.Type == "gap" {
+		log.Printf("channel %q: %s", e.Label, e.Error)
+	}
+	if e.Seq > o[e.Label] || e.Type == "gap" {
+		o[e.Label] = e.Seq
+	}
+}
+
+// query возвращает параметры подключения, по которым
+// сервер дошлет сообщения, пропущенные после номеров.
+func (o offsets) query() string {
+	labels := make([]string, 0, len(o))
+	for label := range o {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	q := url.Values{}
+	for _, label := range labels {
+		q.Add("offset", label+":"+strconv.FormatUint(o[label], 10))
+	}
+	return q.Encode()
+}
+
+// heartbeat настраивает пульс соединения: пингует сервер
+// с интервалом ping и продлевает дедлайн на чтение при каждом
+// понге или пинге от сервера. Если сервер молчит дольше
+// pong, то чтение завершается ошибкой таймаута.
+func heartbeat(c *ws.Conn, ping, pong time.Duration) {
+	extend := func() error {
+		return c.SetReadDeadline(time.Now().Add(pong))
+	}
+	_ = extend()
+	c.SetPongHandler(func(string) error {
+		return extend()
+	})
+	c.SetPingHandler(func(data string) error {
+		_ = extend()
+		err := c.WriteControl(ws.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == ws.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	go func() {
+		ticker := time.NewTicker(ping)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := c.WriteControl(ws.PingMessage, nil, time.Now().Add(time.Second))
+			if err != nil {
+				return // соединение закрыто
+			}
+		}
+	}()
+}
+
+// isTimeout - истек ли дедлайн на чтение?
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// session - соединение с сервером, которое listen
+// подменяет новым при переподключении.
+type session struct {
+	mu sync.Mutex
+	c  *ws.Conn
+}
+
+func (s *session) conn() *ws.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c
+}
+
+func (s *session) set(c *ws.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c = c
+}
+
+// listen читает сообщения сервера, пока соединение не будет
+// закрыто нормально с нашей или со стороны сервера. При любой
+// другой ошибке чтения (обрыв, 1013 от сервера, молчание на пинги)
+// listen переподключается и просит дослать пропущенное после offsets.
+func listen(s *session, u url.URL, offsets offsets) error {
+	for {
+		_, message, err := s.conn().ReadMessage()
+		if err != nil {
+			if ws.IsCloseError(err, ws.CloseNormalClosure) {
+				log.Println("read:", err)
+				return nil
+			}
+
+			log.Printf("error: %v", err)
+			log.Println("trying to reconnect")
+
+			_ = s.conn().Close()
+			// просим дослать то, что пропустили
+			u.RawQuery = offsets.query()
+			c, err := connect(u, retries, retryInterval)
+			if err != nil {
+				return err
+			}
+			s.set(c)
+			continue
+		}
+
+		if len(message) > 0 {
+			log.Printf("recv: %s", message)
+			offsets.track(message)
+		}
+	}
+}
+
+// durationEnv читает длительность из переменной окружения,
+// если она задана.
+func durationEnv(env string, d *time.Duration) {
+	v, ok := os.LookupEnv(env)
+	if !ok {
+		return
+	}
+	p, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("environment variable %q: %v", env, err)
+	}
+	*d = p
+}
+
 func main() {
 	addr := os.Getenv(serverAddr)
 	if addr == "" {
@@ -51,48 +213,28 @@ func main() {
 	log.SetFlags(log.Lmsgprefix | log.LstdFlags)
 	log.SetPrefix("[ws CLIENT]: ")
 
+	durationEnv(pingEnv, &pingInterval)
+	durationEnv(pongWaitEnv, &pongWait)
+	if pongWait <= pingInterval {
+		log.Fatalf("%s must be greater than %s", pongWaitEnv, pingEnv)
+	}
+
 	u := url.URL{Scheme: "ws", Host: addr, Path: "/"}
 
 	c, err := connect(u, retries, retryInterval)
 	if err != nil {
 		log.Fatal("dial:", err)
 	}
-	defer c.Close()
 
-	done := make(chan struct{})
+	sess := &session{c: c}
+	defer func() { _ = sess.conn().Close() }()
 
+	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		for {
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				// если соединение было закрыто нормально с нашей
-				// или со стороны сервера, то выходим
-				if ws.IsCloseError(err, ws.CloseNormalClosure) {
-					log.Println("read:", err)
-					return
-				}
-				// если случилась непредвиденная ошибка соединения,
-				// мы пытаемся переподключиться
-				if ws.IsUnexpectedCloseError(err, ws.CloseGoingAway,
-					ws.CloseAbnormalClosure, ws.CloseInternalServerErr,
-					ws.CloseServiceRestart, ws.CloseTryAgainLater) {
-
-					log.Printf("error: %v", err)
-					log.Println("trying to reconnect")
-
-					_ = c.Close()
-					c, err = connect(u, retries, retryInterval)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-			}
-
-			if len(message) > 0 {
-				log.Printf("recv: %s", message)
-			}
-
+		// последние полученные номера каналов
+		if err := listen(sess, u, make(offsets)); err != nil {
+			log.Fatal(err)
 		}
 	}()
 
@@ -104,7 +246,7 @@ func main() {
 		return
 	case s := <-stop:
 		log.Printf("got os signal %q", s)
-		err = c.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
+		err = sess.conn().WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
 		if err != nil {
 			log.Println("write close:", err)
 		}
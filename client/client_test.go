@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -48,3 +49,113 @@ func Test_connect(t *testing.T) {
 		t.Errorf("connect() = %v, want %v", err, ErrRetryExceeded)
 	}
 }
+
+func Test_heartbeat(t *testing.T) {
+	// сервер принимает соединение, но никогда не читает его,
+	// а значит не отвечает на пинги
+	hold := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		<-hold
+	}))
+	defer ts.Close()
+	defer close(hold)
+
+	u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/"}
+	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	heartbeat(c, 10*time.Millisecond, 50*time.Millisecond)
+
+	_, _, err = c.ReadMessage()
+	if !isTimeout(err) {
+		t.Fatalf("heartbeat() read err = %v, want timeout", err)
+	}
+}
+
+func Test_offsets_track(t *testing.T) {
+	o := make(offsets)
+	for _, m := range []string{
+		`{"type":"snapshot","label":"BTC/USDT","seq":4,"data":{}}`,
+		`{"type":"replay","label":"BTC/USDT","seq":3,"data":{}}`,
+		`{"type":"update","label":"BTC/*","seq":7,"data":{}}`,
+		`{"type":"gap","label":"BTC/*","seq":2,"error":"gap too large, resnapshot"}`,
+		`not json`,
+	} {
+		o.track([]byte(m))
+	}
+
+	want := offsets{"BTC/USDT": 4, "BTC/*": 2}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("track() = %v, want %v", o, want)
+	}
+}
+
+func Test_offsets_query(t *testing.T) {
+	o := offsets{"BTC/USDT": 4, "BTC/*": 2}
+	got, err := url.ParseQuery(o.query())
+	if err != nil {
+		t.Fatalf("query() = err: %v", err)
+	}
+	want := url.Values{"offset": {"BTC/*:2", "BTC/USDT:4"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("query() = %v, want %v", got, want)
+	}
+}
+
+func Test_listen(t *testing.T) {
+	// первое соединение сервер закрывает с кодом 1013,
+	// второе - нормально, запомнив параметры переподключения
+	var (
+		conns  int
+		resume = make(chan url.Values, 1)
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		conns++
+		code := websocket.CloseNormalClosure
+		if conns == 1 {
+			code = websocket.CloseTryAgainLater
+			_ = c.WriteMessage(websocket.TextMessage,
+				[]byte(`{"type":"update","label":"BTC/USDT","seq":5,"data":{}}`))
+		} else {
+			resume <- r.URL.Query()
+		}
+		_ = c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""))
+		_, _, _ = c.ReadMessage()
+	}))
+	defer ts.Close()
+
+	u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/"}
+	c, err := connect(u, 1, time.Microsecond)
+	if err != nil {
+		t.Fatalf("connect() = err: %v", err)
+	}
+	s := &session{c: c}
+	defer s.conn().Close()
+
+	if err := listen(s, u, make(offsets)); err != nil {
+		t.Fatalf("listen() = err: %v", err)
+	}
+
+	want := url.Values{"offset": {"BTC/USDT:5"}}
+	select {
+	case got := <-resume:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("listen() reconnected with %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("listen() did not reconnect")
+	}
+}
@@ -3,24 +3,48 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 	"xtestserver/domain"
+	healthapi "xtestserver/pkg/api/health"
 	api "xtestserver/pkg/api/rest"
+	sseapi "xtestserver/pkg/api/sse"
 	wsapi "xtestserver/pkg/api/websocket"
+	"xtestserver/pkg/config"
+	"xtestserver/pkg/feed"
+	"xtestserver/pkg/leader"
+	pglock "xtestserver/pkg/leader/postgres"
+	"xtestserver/pkg/logging"
+	"xtestserver/pkg/metrics"
+	"xtestserver/pkg/pipeline"
 	"xtestserver/pkg/poller"
+	"xtestserver/pkg/pubsub"
+	"xtestserver/pkg/pubsub/memory"
+	pgpubsub "xtestserver/pkg/pubsub/postgres"
+	"xtestserver/pkg/recovery"
+	"xtestserver/pkg/shutdown"
 	"xtestserver/pkg/storage"
+	"xtestserver/pkg/storage/cache"
+	"xtestserver/pkg/storage/instrument"
 	"xtestserver/pkg/storage/postgres"
+	"xtestserver/pkg/stream"
+	"xtestserver/pkg/supervisor"
 	"xtestserver/rates"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func init() {
@@ -30,34 +54,43 @@ func init() {
 	_ = godotenv.Load()
 }
 
-// переменная окружения
-const (
-	dbConnStrEnv = "DB_URL"
-	logfileEnv   = "LOG_FILE"
-)
+const usage = `usage:
+  server [flags]               запустить сервер
+  server config print [flags]  показать действующие настройки
 
-const (
-	btcURL          = "https://api.kucoin.com/api/v1/market/stats?symbol=BTC-USDT" // ссылка на курс биткоина
-	crbURL          = "http://www.cbr.ru/scripts/XML_daily.asp"                    // ссылка на курсы фиатных валют
-	btcPollInterval = 10 * time.Second                                             // интервал опроса курса биткоина
-	crbPollInterval = 24 * time.Hour                                               // интервал опроса курса фиатных валют
-)
+Настройки берутся из файла -config (CONFIG_FILE), переменных
+окружения и флагов, флаги приоритетнее. Все флаги: server -h`
 
 // имя подсистемы для логирования
-var (
-	restAPIName   = "[REST API]: "
-	wsAPIName     = "[WEBSOCKET API]: "
-	errLoggerName = "[ERROR]: "
+const (
+	mainName    = "main"
+	restAPIName = "rest"
+	sseAPIName  = "sse"
+	wsAPIName   = "websocket"
+	leaderName  = "leader"
+	storageName = "storage"
+	adminName   = "admin"
+	ingestName  = "ingest"
 )
 
 func main() {
 
-	em, err := envs(dbConnStrEnv, logfileEnv)
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(configCmd(args[1:]))
+	}
+
+	cfg, err := config.Load(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, usage)
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	logfile, err := os.OpenFile(em[logfileEnv], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	logCfg, rotation := logSettings(cfg.Log)
+	logfile, err := logging.OpenFile(cfg.Log.File, rotation)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -65,89 +98,210 @@ func main() {
 		_ = logfile.Close()
 	}()
 
-	db, err := connectDB(em[dbConnStrEnv], 60, time.Second)
+	// все подсистемы пишут в консоль и в файл
+	logs, err := logging.New(io.MultiWriter(os.Stdout, logfile), logCfg)
 	if err != nil {
 		_ = logfile.Close()
 		log.Fatal(err)
 	}
-	defer db.Close()
+	logger := logs.Logger(mainName)
+
+	db, err := connectDB(cfg.Storage.URL, cfg.Storage.Retries, time.Duration(cfg.Storage.RetryInterval))
+	if err != nil {
+		logger.Error("connect db", "err", err)
+		_ = logfile.Close()
+		os.Exit(1)
+	}
+	// экземпляры применяют миграции по очереди
+	migrated, err := db.Migrate(context.Background())
+	if err != nil {
+		logger.Error("migrate db", "err", err)
+		_ = db.Close()
+		_ = logfile.Close()
+		os.Exit(1)
+	}
+	if len(migrated) > 0 {
+		logger.Info("db migrated", "migrations", migrated)
+	}
+
+	// метрики отдает сервер администрирования
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	measured := instrument.New(db, reg, logs.Logger(storageName),
+		instrument.WithSlowQuery(time.Duration(cfg.Storage.SlowQuery)))
+
+	// текущие курсы кэшируются до записи новых, но не дольше интервала
+	// опроса биткоина: в БД может писать лидер на другом экземпляре
+	cached := cache.New(measured,
+		cache.WithCurrentTTL(time.Duration(cfg.Sources.BTC.Interval)),
+		cache.WithHistoryTTL(time.Duration(cfg.Storage.HistoryTTL)))
+	reg.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "xtest_storage_cache_hits_total",
+			Help: "Number of storage queries served from cache.",
+		}, func() float64 { return float64(cached.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "xtest_storage_cache_misses_total",
+			Help: "Number of storage queries passed to the database.",
+		}, func() float64 { return float64(cached.Stats().Misses) }),
+	)
+	pipelineMetrics := metrics.NewPipeline(reg)
+	httpm := metrics.NewHTTP(reg)
+
+	ps, err := newPubSub(cfg.Storage.PubSub, cfg.Storage.URL)
+	if err != nil {
+		logger.Error("connect pubsub", "err", err)
+		_ = db.Close()
+		_ = logfile.Close()
+		os.Exit(1)
+	}
 
 	// создаем контекст для регулирования закрытия всех подсистем
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// опрашиваем url ссылки
-	btc, btcPollErrs := poller.Poll(ctx, btcURL, btcPollInterval)
-	crb, crbPollErrs := poller.Poll(ctx, crbURL, crbPollInterval)
-	// десериализуем
-	btcRates, btcErrs := domain.DecodeStream(btc, domain.JsonDec)
-	crbRates, crbErrs := domain.DecodeStream(crb, domain.XmlDec)
-	// обрабатываем десериализованные данные
-	repls, procErrs := rates.ProcessStream(ctx, db, btcRates, rates.BtcProcessFunc)
-	_, procCrbErrs := rates.ProcessStream(ctx, db, crbRates, rates.FiatProcessFunc)
+	// обновления из шины получают все экземпляры сервера
+	sub, err := ps.Subscribe(ctx)
+	if err != nil {
+		logger.Error("subscribe pubsub", "err", err)
+		cancel()
+		_ = ps.Close()
+		_ = db.Close()
+		_ = logfile.Close()
+		os.Exit(1)
+	}
+	// лента обновлений для WEBSOKET и SSE API
+	updates := feed.New(sub, feed.DefaultReplaySize)
+
+	lock, err := pglock.New(cfg.Storage.URL, cfg.Leader.Lock)
+	if err != nil {
+		logger.Error("create leader lock", "err", err)
+		cancel()
+		_ = ps.Close()
+		_ = db.Close()
+		_ = logfile.Close()
+		os.Exit(1)
+	}
+
+	// проверки готовности отдает сервер администрирования
+	health := healthapi.New(logs.Logger(adminName))
+	health.Ready("db", cached.Ping)
+	var started healthapi.Flag // запущены ли конвейеры
+	// конвейеры лидера перезапускаются после сбоев
+	sup := supervisor.New(logs.Logger(ingestName))
+	health.Ready("pipelines", func(ctx context.Context) error {
+		if err := started.Check(ctx); err != nil {
+			return err
+		}
+		return sup.Check(ctx)
+	})
 
 	var wg sync.WaitGroup
 	wg.Add(3)
 
-	logFW := logWriter{w: logfile}              // потокобезопасный writer
-	logout := io.MultiWriter(os.Stdout, &logFW) // куда пишем логи
-
-	// читаем каналы с ошибками
-	go errsLogger(io.MultiWriter(os.Stderr, &logFW), &wg,
-		btcPollErrs, crbPollErrs, btcErrs, crbErrs, procErrs, procCrbErrs)
+	// опрашивает источники только лидер,
+	// остальные экземпляры обслуживают клиентов.
+	// Опрос останавливается раньше остальных подсистем
+	ingestCtx, stopIngest := context.WithCancel(ctx)
+	ingested := make(chan struct{})
+	go func() {
+		defer close(ingested)
+		leader.Run(ingestCtx, lock, time.Duration(cfg.Leader.Interval), logs.Logger(leaderName), func(ctx context.Context) {
+			// номера сообщений присваивает лидер, все экземпляры раздают их
+			// клиентам как есть; каждый срок лидерства продолжает номера ленты
+			ingest(ctx, cfg.Sources, cfg.Aggregate, time.Duration(cfg.Timeouts.Drain), cached, ps, updates.Sequencer(),
+				pipelineMetrics, sup, logs.Logger(ingestName))
+		})
+	}()
 
-	servers := []*http.Server{
-		startRestServer(db, logout, &wg),
-		startWebsoketServer(ctx, logout, repls, &wg),
+	rest := startRestServer(ctx, cfg, cached, updates, httpm, logs, &wg)
+	ws := startWebsoketServer(ctx, cfg, logs.Logger(wsAPIName), updates, reg, httpm, &wg)
+	admin := startAdminServer(cfg, reg, health, logs.Logger(adminName), &wg)
+	// лента читает шину, лидерство разыгрывается, серверы запущены
+	started.Set()
+
+	go reloader(ctx, args, logger, logs, logfile)
+
+	sig := waitSignal()
+	logger.Info("got signal, shutting down", "signal", sig.String())
+
+	// останавливаемся по ходу данных: сначала опрос источников
+	// и конвейер, который дописывает уже полученные курсы,
+	// затем клиенты и серверы, в последнюю очередь шина и БД
+	err = shutdown.Run(context.Background(), time.Duration(cfg.Timeouts.Shutdown), logger,
+		shutdown.Step{Name: "ingest", Func: func(ctx context.Context) error {
+			stopIngest()
+			return shutdown.Wait(ctx, ingested)
+		}},
+		shutdown.Step{Name: "clients", Func: func(ctx context.Context) error {
+			cancel() // закрывает WebSocket и SSE клиентов и подписку на шину
+			return errors.Join(rest.Shutdown(ctx), ws.Shutdown(ctx))
+		}},
+		shutdown.Step{Name: "admin", Func: admin.Shutdown},
+		shutdown.Step{Name: "pubsub", Func: func(context.Context) error { return ps.Close() }},
+		shutdown.Step{Name: "db", Func: func(context.Context) error { return db.Close() }},
+	)
+	wg.Wait() // ждём серверы
+	if err != nil {
+		logger.Warn("server stopped with errors")
+		return
 	}
-
-	cancelation(cancel, logout, servers) // логика закрытия сервера
-
-	wg.Wait() // ждём всех
+	logger.Info("server stopped")
 }
 
-// cancelation мониторит os-сингналы прерывания и в случае получения
-// отменяет контекст приложения и "мягко" останавливает серверы
-func cancelation(cancel context.CancelFunc, logout io.Writer, servers []*http.Server) {
-	// ловим сигналы прерывания типа CTRL-C
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		sig := <-stop // получили сигнал прерывания
-		t := time.Now().UTC()
-		yy, mm, dd := t.Date()
-		h, m, s := t.Clock()
-		fmt.Fprintf(logout, "%04d/%02d/%02d %02d:%02d:%02d [MAIN]: got signal %q\n",
-			yy, mm, dd, h, m, s, sig)
-
-		// закрываем серверы
-		for i := range servers {
-			if err := servers[i].Shutdown(context.Background()); err != nil {
-				fmt.Fprintf(logout, "%v", err)
-			}
-		}
-
-		cancel() // закрываем контекст приложения
-	}()
+// configCmd выполняет подкоманды config
+// и возвращает код завершения.
+func configCmd(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	cfg, err := config.Load(args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b, err := cfg.Print()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	_, _ = os.Stdout.Write(b)
+	return 0
 }
 
-// envs собирает ожидаемые переменные окружения,
-// возвращает ошибку, если какая-либо из переменных env не задана.
-func envs(envs ...string) (map[string]string, error) {
-	em := make(map[string]string, len(envs))
-	var ok bool
-	for _, env := range envs {
-		if em[env], ok = os.LookupEnv(env); !ok {
-			log.Println(em[env])
-			return nil, fmt.Errorf("environment variable %q must be set", env)
-		}
+// logSettings переводит настройки логирования
+// в настройки пакета logging. Уровни уже проверены
+// при загрузке настроек.
+func logSettings(cfg config.Log) (logging.Config, logging.Rotation) {
+	lc := logging.Config{Format: cfg.Format, Levels: make(map[string]slog.Level, len(cfg.Levels))}
+	lc.Level, _ = logging.ParseLevel(cfg.Level)
+	for name, lvl := range cfg.Levels {
+		lc.Levels[name], _ = logging.ParseLevel(lvl)
+	}
+	return lc, logging.Rotation{
+		MaxSize:    int64(cfg.MaxSizeMB) << 20,
+		MaxAge:     time.Duration(cfg.MaxAge),
+		MaxBackups: cfg.MaxBackups,
+		Retention:  time.Duration(cfg.Retention),
 	}
-	return em, nil
+}
+
+// waitSignal ждет сигнала прерывания (CTRL-C, SIGTERM, SIGQUIT).
+func waitSignal() os.Signal {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(stop)
+	return <-stop
 }
 
 var ErrRetryExceeded = errors.New("connect DB: number of retries exceeded")
 
-func connectDB(connstr string, retries int, interval time.Duration) (storage.Storage, error) {
+func connectDB(connstr string, retries int, interval time.Duration) (*postgres.Postgres, error) {
 
 	for i := 0; i < retries; i++ {
 		db, err := postgres.New(connstr)
@@ -161,85 +315,336 @@ func connectDB(connstr string, retries int, interval time.Duration) (storage.Sto
 	return nil, ErrRetryExceeded
 }
 
+// source - источник курсов и функция десериализации его ответов.
+type source struct {
+	config.Source
+	label  string // имя для метрик и логов
+	decode func([]byte) ([]domain.Rate, error)
+}
+
+// flow - конвейер: источники курсов и обработка их данных.
+type flow struct {
+	name    string      // имя для supervisor, метрик и логов
+	pair    domain.Pair // пара, курс которой сводит agg
+	sources []source
+	agg     *rates.Aggregator // nil - курсы источника обрабатываются как есть
+	process rates.ProcessFunc
+}
+
+// ingest запускает конвейеры пар (BTC/USDT и sources.pairs), каждый
+// из которых сводит котировки своих бирж в один курс, и конвейер
+// курсов фиатных валют (ЦБ и sources.references) под наблюдением
+// sup, который перезапускает упавшие конвейеры. Возвращает
+// управление после отмены контекста, когда все конвейеры опустеют.
+func ingest(ctx context.Context, src config.Sources, agg config.Aggregate, drain time.Duration, db storage.Storage,
+	ps pubsub.PubSub, seq *feed.Sequencer, m *metrics.Pipeline, sup *supervisor.Supervisor, logger *slog.Logger) {
+	newAggregator := func() *rates.Aggregator {
+		return rates.NewAggregator(
+			rates.WithMethod(rates.Method(agg.Method)),
+			rates.WithMaxAge(time.Duration(agg.MaxAge)),
+			rates.WithMaxDeviation(agg.MaxDeviation),
+		)
+	}
+	btc := flow{
+		name:    "btc",
+		pair:    domain.BTCUSDT,
+		agg:     newAggregator(),
+		process: rates.BtcProcessFunc,
+	}
+	for _, s := range src.BTCs() {
+		btc.sources = append(btc.sources, source{Source: s, label: s.Name, decode: tickerDecoder(s)})
+	}
+	flows := []flow{btc}
+	for _, p := range src.Pairs {
+		f := flow{
+			name:    strings.ToLower(p.Pair.Slug()),
+			pair:    p.Pair,
+			agg:     newAggregator(),
+			process: rates.PairProcessFunc(p.Pair),
+		}
+		for _, s := range p.Exchanges {
+			// одна биржа может быть источником нескольких пар
+			f.sources = append(f.sources, source{Source: s, label: s.Name + ":" + p.Pair.Slug(), decode: tickerDecoder(s)})
+		}
+		flows = append(flows, f)
+	}
+	fiat := flow{
+		name:    "fiat",
+		process: rates.FiatProcessFunc,
+	}
+	// ЦБ и другие источники со своей базовой валютой (ЕЦБ)
+	for _, s := range src.Fiats() {
+		fiat.sources = append(fiat.sources, source{Source: s, label: s.Name, decode: domain.FiatDecoders[s.Name]})
+	}
+	flows = append(flows, fiat)
+
+	var wg sync.WaitGroup
+	for _, f := range flows {
+		wg.Add(1)
+		go func(f flow) {
+			defer wg.Done()
+			sup.Run(ctx, f.name, func(ctx context.Context) error {
+				return runPipeline(ctx, f, drain, db, ps, seq, m, logger)
+			})
+		}(f)
+	}
+	wg.Wait()
+}
+
+// tickerDecoder возвращает декодер тикеров биржи: сообщения
+// потока устроены иначе, чем ответы на опрос.
+func tickerDecoder(s config.Source) func([]byte) ([]domain.Rate, error) {
+	if s.Stream {
+		return domain.StreamDecoders[s.Name]
+	}
+	return domain.TickerDecoders[s.Name]
+}
+
+// receive получает данные источника: подключается к потоку
+// биржи, если он включен, иначе опрашивает url ссылку.
+func receive(ctx context.Context, s source, errs pipeline.Sink) <-chan []byte {
+	if s.Stream {
+		return stream.Stream(ctx, stream.Exchanges[s.Name](s.URL, s.Symbol), errs,
+			stream.WithDialTimeout(time.Duration(s.Timeout)),
+			stream.WithPingInterval(time.Duration(s.Interval)))
+	}
+	return poller.Poll(ctx, s.URL, time.Duration(s.Interval), errs,
+		poller.WithTimeout(time.Duration(s.Timeout)))
+}
+
+// ErrPipelineStopped - стадия конвейера завершилась раньше времени.
+var ErrPipelineStopped = errors.New("pipeline stage stopped unexpectedly")
+
+// runPipeline получает курсы из источников, сохраняет их и публикует
+// обновления в шину, пронумеровав их seq. Источники опрашиваются
+// параллельно, их курсы сливаются в один поток, а если задан
+// агрегатор - сводятся в один курс. После отмены контекста опрос
+// прекращается, а уже полученные курсы в течение drain сохраняются
+// и публикуются. Возвращает управление, когда конвейер опустеет.
+// Если какая-то стадия завершилась раньше (например, из-за паники),
+// останавливает остальные и возвращает ErrPipelineStopped.
+func runPipeline(ctx context.Context, f flow, drain time.Duration, db storage.Storage, ps pubsub.PubSub,
+	seq *feed.Sequencer, m *metrics.Pipeline, logger *slog.Logger) error {
+	pctx, stop := context.WithCancel(ctx)
+	defer stop()
+	// обработка и публикация переживают опрос
+	wctx, cancel := shutdown.Grace(pctx, drain)
+	defer cancel()
+
+	// ветка любого источника закрывается только
+	// после остановки, иначе это сбой
+	stopped := make(chan struct{})
+	var once sync.Once
+	closed := func() { once.Do(func() { close(stopped) }) }
+
+	streams := make([]<-chan []domain.Rate, 0, len(f.sources))
+	for _, s := range f.sources {
+		// опрашиваем источник или слушаем его поток
+		raw := receive(pctx, s,
+			m.PollFailed(s.label, errsLogger(logger, m, metrics.StagePoll, s.label)))
+		// десериализуем
+		rs := pipeline.New(pipeline.Map(s.decode),
+			errsLogger(logger, m, metrics.StageDecode, s.label)).Run(wctx, m.Polled(s.label, raw))
+		streams = append(streams, pipeline.OnClose(wctx, m.Processed(s.label, rs), closed))
+	}
+	rs := pipeline.Merge(wctx, streams...)
+	if f.agg != nil {
+		// сводим котировки в один курс
+		rs = rates.AggregateStream(wctx, db, f.pair, rs, f.agg,
+			errsLogger(logger, m, metrics.StageAggregate, f.name))
+	}
+	// обрабатываем десериализованные данные
+	repls := rates.ProcessStream(wctx, db, rs, f.process,
+		errsLogger(logger, m, metrics.StageProcess, f.name))
+	// нумеруем обновления по каналам
+	stamped := pipeline.New(pipeline.Map(func(b []byte) ([]byte, error) {
+		return seq.Stamp(b), nil
+	}), pipeline.Discard).Run(wctx, repls)
+	// публикуем обновления в шину
+	done := pubsub.Forward(wctx, ps, stamped,
+		errsLogger(logger, m, metrics.StagePublish, f.name))
+
+	// последняя стадия завершается вслед за предыдущими
+	select {
+	case <-ctx.Done():
+	case <-stopped:
+	case <-done:
+	}
+	stop()
+	<-done
+
+	switch {
+	case ctx.Err() == nil:
+		return ErrPipelineStopped
+	case wctx.Err() != nil:
+		logger.Warn("pipeline drain timed out", "pipeline", f.name, "timeout", drain)
+	default:
+		logger.Info("pipeline drained", "pipeline", f.name)
+	}
+	return nil
+}
+
+// reloader по сигналу SIGHUP заново открывает файл лога (например,
+// после внешнего logrotate), перечитывает .env и файл настроек
+// и применяет новые уровни логирования и настройки ротации.
+// Остальные настройки применяются только после перезапуска.
+func reloader(ctx context.Context, args []string, logger *slog.Logger, logs *logging.Logging, logfile *logging.File) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		logger.Info("got signal, reloading", "signal", syscall.SIGHUP.String())
+
+		if err := logfile.Reopen(); err != nil {
+			logger.Error("reopen log file", "err", err)
+		}
+		_ = godotenv.Overload() // .env может и не быть
+		cfg, err := config.Load(args, os.Getenv)
+		if err != nil {
+			logger.Error("reload config", "err", err)
+			continue
+		}
+		logCfg, rotation := logSettings(cfg.Log)
+		logs.SetLevels(logCfg.Level, logCfg.Levels)
+		logfile.SetRotation(rotation)
+		logger.Info("config reloaded")
+	}
+}
+
+// newPubSub возвращает шину обновлений: Postgres LISTEN/NOTIFY,
+// если запущено несколько экземпляров сервера, иначе шину в памяти.
+func newPubSub(kind, connstr string) (pubsub.PubSub, error) {
+	switch kind {
+	case "", "memory":
+		return memory.New(), nil
+	case "postgres":
+		return pgpubsub.New(connstr)
+	}
+	return nil, fmt.Errorf("unknown pubsub %q", kind)
+}
+
 // startWebsoketServer запускает websoket сервер
-func startWebsoketServer(ctx context.Context, logout io.Writer, upd <-chan []byte, wg *sync.WaitGroup) *http.Server {
+func startWebsoketServer(ctx context.Context, cfg config.Config, logger *slog.Logger, upd *feed.Feed,
+	reg prometheus.Registerer, httpm *metrics.HTTP, wg *sync.WaitGroup) *http.Server {
 	// WEBSOKET API
-	logger := log.New(logout, wsAPIName, log.Lmsgprefix|log.LstdFlags)
-	api := wsapi.New(ctx, logger, upd)
+	policy, err := wsapi.ParsePolicy(cfg.WebSocket.Policy)
+	if err != nil {
+		policy = wsapi.DropOldest // настройки уже проверены
+	}
+	wsAPI := wsapi.New(ctx, logger, upd,
+		wsapi.WithQueueSize(cfg.WebSocket.QueueSize),
+		wsapi.WithWriteTimeout(time.Duration(cfg.WebSocket.WriteTimeout)),
+		wsapi.WithPolicy(policy),
+		wsapi.WithHeartbeat(time.Duration(cfg.WebSocket.PingInterval), time.Duration(cfg.WebSocket.PongWait)),
+	)
+	wsAPI.Router().Use(httpm.Middleware("websocket"))
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "xtest_websocket_clients",
+		Help: "Number of connected WebSocket clients.",
+	}, func() float64 { return float64(wsAPI.Clients()) }))
 
 	// конфигурируем сервер WEBSOKET API
 	srv := &http.Server{
-		Addr:              ":8090",
-		Handler:           api.Router(),
-		ReadHeaderTimeout: time.Minute,
+		Addr:              cfg.Listen.WebSocket,
+		Handler:           wsAPI.Router(),
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
 	// сервер WEBSOKET API
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Println(err)
+			logger.Error("listen", "addr", srv.Addr, "err", err)
 		}
-		logger.Println("server is shut down")
+		logger.Info("server is shut down")
 		wg.Done()
 	}()
 	return srv
 }
 
 // startRestServer запускает REST API сервер
-func startRestServer(db storage.Storage, logout io.Writer, wg *sync.WaitGroup) *http.Server {
+// вместе с потоком обновлений SSE
+func startRestServer(ctx context.Context, cfg config.Config, db storage.Storage, upd *feed.Feed,
+	httpm *metrics.HTTP, logs *logging.Logging, wg *sync.WaitGroup) *http.Server {
 	// REST API
-	logger := log.New(logout, restAPIName, log.Lmsgprefix|log.LstdFlags)
-	api := api.New(db, logger)
+	logger := logs.Logger(restAPIName)
+	restAPI := api.New(db, logger,
+		api.WithTimeout(time.Duration(cfg.Timeouts.Request)),
+		api.WithStaleness(time.Duration(cfg.Staleness.BTC), time.Duration(cfg.Staleness.Fiat)))
+	restAPI.Router().Use(httpm.Middleware("rest"))
+
+	// SSE API
+	sseAPI := sseapi.New(ctx, logs.Logger(sseAPIName), upd)
+	restAPI.Router().Handle(sseapi.Path, sseAPI.Router())
 
 	// конфигурируем сервер REST API
 	srv := &http.Server{
-		Addr:              ":8080",
-		Handler:           api.Router(),
-		IdleTimeout:       3 * time.Minute,
-		ReadHeaderTimeout: time.Minute,
+		Addr:              cfg.Listen.REST,
+		Handler:           restAPI.Router(),
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
+	// потоки SSE никогда не простаивают,
+	// поэтому закрываем их до остановки сервера
+	srv.RegisterOnShutdown(sseAPI.Close)
 
 	// сервер WEBSOKET API
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Println(err)
+			logger.Error("listen", "addr", srv.Addr, "err", err)
 		}
-		logger.Println("server is shut down")
+		logger.Info("server is shut down")
 		wg.Done()
 	}()
 	return srv
 }
 
-// errsLogger логирует ошибки из предоставленного списка каналов
-func errsLogger(logout io.Writer, wg *sync.WaitGroup, errs ...<-chan error) {
+// startAdminServer запускает сервер администрирования
+// с проверками живости и готовности и метриками в формате Prometheus
+func startAdminServer(cfg config.Config, reg *prometheus.Registry, health *healthapi.API, logger *slog.Logger, wg *sync.WaitGroup) *http.Server {
+	errLog := slog.NewLogLogger(logger.Handler(), slog.LevelError)
 
-	logger := log.New(logout, errLoggerName, log.Lmsgprefix|log.LstdFlags)
+	r := health.Router()
+	r.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: errLog}))
 
-	// читаем все каналы ошибок и логгируем
-	for _, ch := range errs {
-
-		go func(c <-chan error) {
-
-			for err := range c {
-				if !errors.Is(err, context.Canceled) || !errors.Is(err, context.DeadlineExceeded) {
-					logger.Println(err)
-				}
-			}
-
-		}(ch)
+	// конфигурируем сервер администрирования
+	srv := &http.Server{
+		Addr:              cfg.Listen.Admin,
+		Handler:           r,
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		ErrorLog:          errLog,
 	}
 
-	wg.Done()
-}
-
-type logWriter struct {
-	mu sync.Mutex
-	w  io.Writer
+	// сервер администрирования
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Error("listen", "addr", srv.Addr, "err", err)
+		}
+		logger.Info("server is shut down")
+		wg.Done()
+	}()
+	return srv
 }
 
-func (lw *logWriter) Write(b []byte) (n int, err error) {
-	lw.mu.Lock()
-	n, err = lw.w.Write(b)
-	lw.mu.Unlock()
-	return n, err
+// errsLogger возвращает сток ошибок стадии stage источника
+// source, который считает и логирует ошибки.
+func errsLogger(logger *slog.Logger, m *metrics.Pipeline, stage, source string) pipeline.Sink {
+	return m.Errors(stage, source, func(err error) {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return
+		}
+		attrs := []any{"stage", stage, "source", source, "err", err}
+		var perr *recovery.PanicError
+		if errors.As(err, &perr) {
+			attrs = append(attrs, "stack", string(perr.Stack))
+		}
+		logger.Error("pipeline error", attrs...)
+	})
 }
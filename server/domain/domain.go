@@ -14,13 +14,17 @@ import (
 )
 
 // Rate представляет собой обменный курс
-// одной конкретной валюты (по отношению к рублю).
+// одной конкретной валюты (по отношению к рублю,
+// а для курсов ЕЦБ - к евро, см. Base).
 type Rate struct {
 	Id       int64   `json:"id,omitempty"`
 	CharCode string  `json:"char_code,omitempty"`
 	Nominal  int     `json:"nominal,omitempty"`
 	Time     int64   `json:"time"`
 	Value    float64 `json:"value"`
+	Source   string  `json:"source,omitempty"` // откуда получен курс
+	Volume   float64 `json:"volume,omitempty"` // суточный объем торгов в базовой валюте, если биржа его сообщает
+	Base     string  `json:"base,omitempty"`   // валюта, в которой выражен курс фиатной валюты: RUB, EUR
 }
 
 // JsonRate - структура для парсинга курса BTC/USDT;
@@ -28,6 +32,7 @@ type Rate struct {
 type JsonRate struct {
 	Time int64   `json:"time"`
 	Buy  float64 `json:"buy,string"`
+	Vol  float64 `json:"vol,string"` // суточный объем в BTC
 }
 
 // XMLRate - структура для парсинга курса фиатных валют.
@@ -42,8 +47,9 @@ type XMLRate struct {
 // ToRate - приводит JsonRate к Rate.
 func (br *JsonRate) ToRate() Rate {
 	return Rate{
-		Time:  time.UnixMilli(br.Time).Unix(),
-		Value: br.Buy,
+		Time:   time.UnixMilli(br.Time).Unix(),
+		Value:  br.Buy,
+		Volume: br.Vol,
 	}
 }
 
@@ -73,6 +79,15 @@ func RateMap(rates []Rate) map[string]any {
 	return m
 }
 
+// RateSources возвращает источники курсов по коду валюты.
+func RateSources(rates []Rate) map[string]string {
+	m := make(map[string]string, len(rates))
+	for i := range rates {
+		m[rates[i].CharCode] = rates[i].Source
+	}
+	return m
+}
+
 // RateMap превращает слайс Rate в map с таймстампом.
 func RateMapTimestamp(rates []Rate) map[string]any {
 	m := make(map[string]any, len(rates))
@@ -119,41 +134,20 @@ func JsonDec(b []byte) ([]Rate, error) {
 		return nil, err
 	}
 
-	return []Rate{c.Item.ToRate()}, nil
+	r := c.Item.ToRate()
+	r.Source = SourceKucoin
+	return []Rate{r}, nil
 }
 
 // XmlDec - логика десериализации xml данных
 func XmlDec(b []byte) ([]Rate, error) {
 	var c xmlContainer
-	return c.Items, xmlDecoderWithSettings(bytes.NewReader(b)).Decode(&c)
-}
-
-// DecodeStream - читает из канала поток срезов байтов,
-// десериализует их с помощью предоставленной функции
-// (JsonDec/XmlDec) и отправляет дальше по каналу.
-func DecodeStream(in <-chan []byte, f func([]byte) ([]Rate, error)) (<-chan []Rate, <-chan error) {
-
-	out := make(chan []Rate)
-	errs := make(chan error, 2)
-
-	go func() {
-
-		defer func() {
-			close(errs)
-			close(out)
-		}()
-
-		for v := range in {
-			r, err := f(v)
-			if err == nil {
-				out <- r
-			} else {
-				errs <- err
-			}
-		}
-	}()
-
-	return out, errs
+	err := xmlDecoderWithSettings(bytes.NewReader(b)).Decode(&c)
+	for i := range c.Items {
+		c.Items[i].Source = SourceCBR
+		c.Items[i].Base = BaseRUB
+	}
+	return c.Items, err
 }
 
 // decoderWithSettings возвращает *xml.Decoder с настройками
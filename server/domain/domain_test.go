@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 )
@@ -34,7 +33,8 @@ const jblob = `
 			"data": {
 			"time": 1658237004004,
 			"symbol": "BTC-USDT",
-			"buy": "22278.80"
+			"buy": "22278.80",
+			"vol": "2415.31746587"
 			}
 		}`
 
@@ -84,6 +84,7 @@ func TestRate_UnmarshalJSON(t *testing.T) {
 		Nominal:  0,
 		Time:     1658237004,
 		Value:    22278.80,
+		Volume:   2415.31746587,
 	}
 
 	if got.Item.ToRate() != want {
@@ -92,7 +93,7 @@ func TestRate_UnmarshalJSON(t *testing.T) {
 
 }
 
-func TestDecodeStream(t *testing.T) {
+func TestDecoders(t *testing.T) {
 
 	t.Run("xmlDecode", func(t *testing.T) {
 		tn := time.Now()
@@ -102,37 +103,23 @@ func TestDecodeStream(t *testing.T) {
 			Nominal:  1,
 			Time:     time.Date(tn.Year(), tn.Month(), tn.Day(), 0, 0, 0, 0, time.UTC).Unix(),
 			Value:    37.9799,
+			Source:   SourceCBR,
+			Base:     BaseRUB,
 		}
 
-		ch := make(chan []byte)
-		vals, errs := DecodeStream(ch, XmlDec)
-
-		go func() {
-			for i := 0; i < 10; i++ {
-				ch <- []byte(blob)
-			}
-			close(ch)
-		}()
-
-		var wg sync.WaitGroup
-		wg.Add(1)
-
-		go func() {
-			for err := range errs {
-				t.Errorf("DecodeStream() = error %v", err)
-			}
-			wg.Done()
-		}()
-
-		for got := range vals {
-			for _, got := range got {
-				if got != want {
-					t.Errorf("DecodeStream() got = %#v, want %#v", got, want)
-				}
+		got, err := XmlDec([]byte(blob))
+		if err != nil {
+			t.Fatalf("XmlDec() = error %v", err)
+		}
+		if len(got) == 0 {
+			t.Fatal("XmlDec() = no rates")
+		}
+		for _, got := range got {
+			if got != want {
+				t.Errorf("XmlDec() got = %#v, want %#v", got, want)
 			}
 		}
 
-		wg.Wait()
 	})
 
 	t.Run("jsonDecode", func(t *testing.T) {
@@ -142,37 +129,18 @@ func TestDecodeStream(t *testing.T) {
 			Nominal:  0,
 			Time:     1658237004,
 			Value:    22278.80,
+			Source:   SourceKucoin,
+			Volume:   2415.31746587,
 		}
 
-		ch := make(chan []byte)
-		vals, errs := DecodeStream(ch, JsonDec)
-
-		go func() {
-			for i := 0; i < 10; i++ {
-				ch <- []byte(jblob)
-			}
-			close(ch)
-		}()
-
-		var wg sync.WaitGroup
-		wg.Add(1)
-
-		go func() {
-			for err := range errs {
-				t.Errorf("DecodeStream() = error %v", err)
-			}
-			wg.Done()
-		}()
-
-		for got := range vals {
-			for _, got := range got {
-				if got != want {
-					t.Errorf("DecodeStream() got = %#v, want %#v", got, want)
-				}
-			}
+		got, err := JsonDec([]byte(jblob))
+		if err != nil {
+			t.Fatalf("JsonDec() = error %v", err)
+		}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("JsonDec() got = %#v, want %#v", got, []Rate{want})
 		}
 
-		wg.Wait()
 	})
 
 }
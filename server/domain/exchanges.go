@@ -0,0 +1,234 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// источники курсов для Rate.Source
+const (
+	SourceKucoin   = "kucoin"
+	SourceBinance  = "binance"
+	SourceCoinbase = "coinbase"
+	SourceKraken   = "kraken"
+	SourceCBR      = "cbr"
+	SourceECB      = "ecb"
+	// курс, рассчитанный по котировкам нескольких бирж
+	SourceAggregate = "aggregate"
+)
+
+// ErrNoPrice - в ответе биржи нет цены,
+// обычно это ответ с ошибкой.
+var ErrNoPrice = errors.New("ticker has no price")
+
+// TickerDecoders - функции десериализации тикера по бирже.
+// Формат тикера не зависит от пары, она задается адресом запроса.
+var TickerDecoders = map[string]func([]byte) ([]Rate, error){
+	SourceKucoin:   JsonDec,
+	SourceBinance:  BinanceDec,
+	SourceCoinbase: CoinbaseDec,
+	SourceKraken:   KrakenDec,
+}
+
+// StreamDecoders - функции десериализации сообщений потока
+// тикеров биржи (WebSocket). Служебные сообщения (подтверждения
+// подписки, ответы на пинги) дают пустой срез.
+var StreamDecoders = map[string]func([]byte) ([]Rate, error){
+	SourceKucoin:  KucoinStreamDec,
+	SourceBinance: BinanceStreamDec,
+}
+
+// FiatDecoders - функции десериализации курсов
+// фиатных валют по источнику.
+var FiatDecoders = map[string]func([]byte) ([]Rate, error){
+	SourceCBR: XmlDec,
+	SourceECB: EcbDec,
+}
+
+// BinanceDec - десериализация 24-часового тикера Binance
+// (/api/v3/ticker/24hr?symbol=BTCUSDT).
+func BinanceDec(b []byte) ([]Rate, error) {
+	var t struct {
+		LastPrice string `json:"lastPrice"`
+		Volume    string `json:"volume"`
+		CloseTime int64  `json:"closeTime"` // мс
+		Code      int    `json:"code"`
+		Msg       string `json:"msg"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if t.Code != 0 {
+		return nil, fmt.Errorf("binance: %d %s", t.Code, t.Msg)
+	}
+	price, err := parsePrice(t.LastPrice)
+	if err != nil {
+		return nil, fmt.Errorf("binance: %w", err)
+	}
+	return []Rate{{
+		Time:   time.UnixMilli(t.CloseTime).Unix(),
+		Value:  price,
+		Source: SourceBinance,
+		Volume: parseVolume(t.Volume),
+	}}, nil
+}
+
+// CoinbaseDec - десериализация тикера Coinbase Exchange
+// (/products/BTC-USDT/ticker).
+func CoinbaseDec(b []byte) ([]Rate, error) {
+	var t struct {
+		Price   string    `json:"price"`
+		Volume  string    `json:"volume"`
+		Time    time.Time `json:"time"`
+		Message string    `json:"message"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if t.Message != "" {
+		return nil, fmt.Errorf("coinbase: %s", t.Message)
+	}
+	price, err := parsePrice(t.Price)
+	if err != nil {
+		return nil, fmt.Errorf("coinbase: %w", err)
+	}
+	return []Rate{{
+		Time:   t.Time.Unix(),
+		Value:  price,
+		Source: SourceCoinbase,
+		Volume: parseVolume(t.Volume),
+	}}, nil
+}
+
+// KrakenDec - десериализация тикера Kraken
+// (/0/public/Ticker?pair=XBTUSDT). Kraken не сообщает
+// время тикера, поэтому курс датируется временем ответа.
+func KrakenDec(b []byte) ([]Rate, error) {
+	var t struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			Close  []string `json:"c"` // [цена, объем] последней сделки
+			Volume []string `json:"v"` // [объем за сегодня, за 24 часа]
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if len(t.Error) > 0 {
+		return nil, fmt.Errorf("kraken: %s", strings.Join(t.Error, ", "))
+	}
+	// в ответе одна пара, ее имя Kraken может
+	// изменить (XBTUSDT), поэтому берем любую
+	for _, pair := range t.Result {
+		if len(pair.Close) == 0 {
+			break
+		}
+		price, err := parsePrice(pair.Close[0])
+		if err != nil {
+			return nil, fmt.Errorf("kraken: %w", err)
+		}
+		var volume float64
+		if len(pair.Volume) > 1 {
+			volume = parseVolume(pair.Volume[1])
+		}
+		return []Rate{{
+			Time:   time.Now().Unix(),
+			Value:  price,
+			Source: SourceKraken,
+			Volume: volume,
+		}}, nil
+	}
+	return nil, fmt.Errorf("kraken: %w", ErrNoPrice)
+}
+
+// BinanceStreamDec - десериализация сообщения потока
+// 24-часового тикера Binance (<symbol>@ticker).
+func BinanceStreamDec(b []byte) ([]Rate, error) {
+	var t struct {
+		Event     string `json:"e"`
+		EventTime int64  `json:"E"` // мс
+		LastPrice string `json:"c"`
+		CloseTime int64  `json:"C"` // мс; поле нужно, иначе "C" попадет в "c"
+		Volume    string `json:"v"`
+		Error     *struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if t.Error != nil {
+		return nil, fmt.Errorf("binance: %d %s", t.Error.Code, t.Error.Msg)
+	}
+	if t.Event != "24hrTicker" {
+		return nil, nil // ответ на подписку
+	}
+	price, err := parsePrice(t.LastPrice)
+	if err != nil {
+		return nil, fmt.Errorf("binance: %w", err)
+	}
+	return []Rate{{
+		Time:   time.UnixMilli(t.EventTime).Unix(),
+		Value:  price,
+		Source: SourceBinance,
+		Volume: parseVolume(t.Volume),
+	}}, nil
+}
+
+// KucoinStreamDec - десериализация сообщения потока тикера
+// KuCoin (/market/ticker:BTC-USDT). Объема торгов в нем нет.
+func KucoinStreamDec(b []byte) ([]Rate, error) {
+	var m struct {
+		Type    string          `json:"type"`
+		Subject string          `json:"subject"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	switch {
+	case m.Type == "error":
+		return nil, fmt.Errorf("kucoin: %s", m.Data)
+	case m.Type != "message" || m.Subject != "trade.ticker":
+		return nil, nil // welcome, ack, pong
+	}
+	var t struct {
+		Price string `json:"price"`
+		Time  int64  `json:"time"` // мс
+	}
+	if err := json.Unmarshal(m.Data, &t); err != nil {
+		return nil, fmt.Errorf("kucoin: %w", err)
+	}
+	price, err := parsePrice(t.Price)
+	if err != nil {
+		return nil, fmt.Errorf("kucoin: %w", err)
+	}
+	return []Rate{{
+		Time:   time.UnixMilli(t.Time).Unix(),
+		Value:  price,
+		Source: SourceKucoin,
+	}}, nil
+}
+
+// parsePrice разбирает цену, которую биржи передают строкой.
+func parsePrice(s string) (float64, error) {
+	if s == "" {
+		return 0, ErrNoPrice
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseVolume разбирает объем торгов. Объем нужен только
+// для взвешивания курса, поэтому вместо ошибки - ноль.
+func parseVolume(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
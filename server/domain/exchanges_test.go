@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangeDecoders(t *testing.T) {
+	tests := []struct {
+		source  string
+		payload string
+		want    Rate
+		now     bool // время курса - время ответа
+	}{
+		{source: SourceBinance, payload: "binance_ticker.json",
+			want: Rate{Time: 1658237004, Value: 22278.80, Source: SourceBinance, Volume: 181503.25836}},
+		{source: SourceCoinbase, payload: "coinbase_ticker.json",
+			want: Rate{Time: 1658237004, Value: 22279.35, Source: SourceCoinbase, Volume: 1853.79143852}},
+		{source: SourceKraken, payload: "kraken_ticker.json",
+			want: Rate{Value: 22280.90, Source: SourceKraken, Volume: 1843.65286203}, now: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now().Unix()
+			got, err := TickerDecoders[tt.source](b)
+			if err != nil {
+				t.Fatalf("%s decoder = error %v", tt.source, err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("%s decoder = %d rates, want 1", tt.source, len(got))
+			}
+			if tt.now {
+				if got[0].Time < start || got[0].Time > time.Now().Unix() {
+					t.Errorf("%s decoder time = %d, want now", tt.source, got[0].Time)
+				}
+				got[0].Time = 0
+			}
+			if got[0] != tt.want {
+				t.Errorf("%s decoder = %#v, want %#v", tt.source, got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeDecoders_errors(t *testing.T) {
+	tests := []struct {
+		source  string
+		payload string
+		want    string
+	}{
+		{source: SourceBinance, payload: "binance_error.json", want: "Invalid symbol"},
+		{source: SourceCoinbase, payload: "coinbase_error.json", want: "NotFound"},
+		{source: SourceKraken, payload: "kraken_error.json", want: "Unknown asset pair"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("testdata", tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := TickerDecoders[tt.source](b); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s decoder = error %v, want %q", tt.source, err, tt.want)
+			}
+		})
+	}
+
+	// пустой ответ без явной ошибки
+	for _, source := range []string{SourceBinance, SourceCoinbase, SourceKraken} {
+		if _, err := TickerDecoders[source]([]byte(`{}`)); !errors.Is(err, ErrNoPrice) {
+			t.Errorf("%s decoder = error %v, want %v", source, err, ErrNoPrice)
+		}
+	}
+}
+
+func TestStreamDecoders(t *testing.T) {
+	tests := []struct {
+		source  string
+		payload string
+		want    []Rate
+		err     string
+	}{
+		{source: SourceBinance, payload: "testdata/binance_stream_ticker.json",
+			want: []Rate{{Time: 1658237004, Value: 22278.80, Source: SourceBinance, Volume: 181503.25836}}},
+		{source: SourceBinance, payload: `{"result":null,"id":1}`},
+		{source: SourceBinance, payload: `{"error":{"code":2,"msg":"Invalid request"},"id":1}`, err: "Invalid request"},
+		{source: SourceKucoin, payload: "testdata/kucoin_stream_ticker.json",
+			want: []Rate{{Time: 1658237004, Value: 22278.90, Source: SourceKucoin}}},
+		{source: SourceKucoin, payload: `{"id":"hQvf8jkno","type":"welcome"}`},
+		{source: SourceKucoin, payload: `{"id":"2","type":"pong"}`},
+		{source: SourceKucoin, payload: `{"id":"3","type":"error","code":404,"data":"topic does not exist"}`,
+			err: "topic does not exist"},
+		{source: SourceKucoin, payload: `{"type":"message","subject":"trade.ticker","data":{"time":1658237004004}}`,
+			err: ErrNoPrice.Error()},
+	}
+	for _, tt := range tests {
+		b := []byte(tt.payload)
+		if strings.HasPrefix(tt.payload, "testdata/") {
+			var err error
+			if b, err = os.ReadFile(tt.payload); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := StreamDecoders[tt.source](b)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s stream decoder(%s) = error %v, want %q", tt.source, tt.payload, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s stream decoder(%s) = error %v", tt.source, tt.payload, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s stream decoder(%s) = %#v, want %#v", tt.source, tt.payload, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// базовые валюты курсов фиатных валют для Rate.Base
+const (
+	BaseRUB = "RUB" // курсы ЦБ РФ
+	BaseEUR = "EUR" // курсы ЕЦБ
+)
+
+// References - базовая валюта курсов по источнику курсов
+// фиатных валют. Кросс-курсы рассчитываются по курсам
+// одного источника, в REST API его выбирает ?reference=.
+var References = map[string]string{
+	SourceCBR: BaseRUB,
+	SourceECB: BaseEUR,
+}
+
+// ErrNoRates - в ответе нет ни одного курса.
+var ErrNoRates = errors.New("reference rates not found")
+
+// EcbDec - десериализация справочных курсов ЕЦБ (eurofxref-daily.xml,
+// подходит и eurofxref-hist.xml): Cube/Cube[@time]/Cube[@currency @rate],
+// где rate - сколько единиц валюты стоит один евро.
+// Как и у ЦБ, Value - стоимость Nominal единиц валюты в евро;
+// Nominal - наименьшая степень десяти не меньше курса ЕЦБ,
+// иначе у слабых валют (JPY, HUF) теряется точность.
+func EcbDec(b []byte) ([]Rate, error) {
+	var env struct {
+		Cube struct {
+			Days []struct {
+				Time  string `xml:"time,attr"` // YYYY-MM-DD
+				Rates []struct {
+					Currency string  `xml:"currency,attr"`
+					Rate     float64 `xml:"rate,attr"`
+				} `xml:"Cube"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	}
+	if err := xmlDecoderWithSettings(bytes.NewReader(b)).Decode(&env); err != nil {
+		return nil, err
+	}
+
+	var rates []Rate
+	for _, d := range env.Cube.Days {
+		t, err := time.Parse("2006-01-02", d.Time)
+		if err != nil {
+			return nil, fmt.Errorf("ecb: %w", err)
+		}
+		for _, r := range d.Rates {
+			if r.Rate <= 0 {
+				return nil, fmt.Errorf("ecb: bad %s rate %v", r.Currency, r.Rate)
+			}
+			nominal := 1
+			for float64(nominal) < r.Rate {
+				nominal *= 10
+			}
+			rates = append(rates, Rate{
+				CharCode: r.Currency,
+				Nominal:  nominal,
+				Time:     t.Unix(),
+				Value:    math.Round(float64(nominal)/r.Rate*1e4) / 1e4,
+				Source:   SourceECB,
+				Base:     BaseEUR,
+			})
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("ecb: %w", ErrNoRates)
+	}
+	return rates, nil
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEcbDec(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "ecb_eurofxref.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FiatDecoders[SourceECB](b)
+	if err != nil {
+		t.Fatalf("EcbDec() = error %v", err)
+	}
+	const day = 1658448000 // 2022-07-22
+	want := []Rate{
+		{CharCode: "USD", Nominal: 10, Time: day, Value: 9.8058, Source: SourceECB, Base: BaseEUR},
+		{CharCode: "JPY", Nominal: 1000, Time: day, Value: 7.1721, Source: SourceECB, Base: BaseEUR},
+		{CharCode: "GBP", Nominal: 1, Time: day, Value: 1.1751, Source: SourceECB, Base: BaseEUR},
+		{CharCode: "HUF", Nominal: 1000, Time: day, Value: 2.4803, Source: SourceECB, Base: BaseEUR},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EcbDec() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEcbDec_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{name: "no cube", payload: `<Envelope><Cube></Cube></Envelope>`},
+		{name: "no rates", payload: `<Envelope><Cube><Cube time="2022-07-22"></Cube></Cube></Envelope>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EcbDec([]byte(tt.payload)); !errors.Is(err, ErrNoRates) {
+				t.Errorf("EcbDec() = error %v, want %v", err, ErrNoRates)
+			}
+		})
+	}
+
+	bad := `<Envelope><Cube><Cube time="2022-07-22"><Cube currency="USD" rate="0"/></Cube></Cube></Envelope>`
+	if _, err := EcbDec([]byte(bad)); err == nil {
+		t.Error("EcbDec() zero rate = nil error")
+	}
+	if _, err := EcbDec([]byte(`<html>`)); err == nil {
+		t.Error("EcbDec() html = nil error")
+	}
+}
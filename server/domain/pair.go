@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pair - валютная пара криптовалюты: курс Base в единицах Quote.
+type Pair struct {
+	Base  string // BTC, ETH, SOL...
+	Quote string // USDT
+}
+
+// BTCUSDT - основная пара, с которой начинался сервер.
+var BTCUSDT = Pair{Base: "BTC", Quote: "USDT"}
+
+// ErrBadPair - строка не похожа на пару.
+var ErrBadPair = errors.New("bad pair, want BASE/QUOTE or BASE-QUOTE")
+
+// String возвращает пару в виде BTC/USDT, он же канал WebSocket.
+func (p Pair) String() string {
+	return p.Base + "/" + p.Quote
+}
+
+// Slug возвращает пару в виде BTC-USDT для адресов REST API.
+func (p Pair) Slug() string {
+	return p.Base + "-" + p.Quote
+}
+
+// Crosses возвращает канал курсов фиатных валют к базовой
+// валюте пары (BTC/*). Они рассчитываются через доллар, поэтому
+// есть только у пар к USDT; для остальных - "".
+func (p Pair) Crosses() string {
+	if p.Quote != "USDT" {
+		return ""
+	}
+	return p.Base + "/*"
+}
+
+// MarshalText возвращает пару в виде BTC/USDT.
+func (p Pair) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText разбирает пару, см. ParsePair.
+func (p *Pair) UnmarshalText(b []byte) error {
+	v, err := ParsePair(string(b))
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+// ParsePair разбирает пару вида BTC/USDT или btc-usdt.
+func ParsePair(s string) (Pair, error) {
+	base, quote, ok := strings.Cut(s, "/")
+	if !ok {
+		base, quote, ok = strings.Cut(s, "-")
+	}
+	p := Pair{Base: strings.ToUpper(strings.TrimSpace(base)), Quote: strings.ToUpper(strings.TrimSpace(quote))}
+	if !ok || !validCode(p.Base) || !validCode(p.Quote) {
+		return Pair{}, fmt.Errorf("%w: %q", ErrBadPair, s)
+	}
+	return p, nil
+}
+
+// validCode - похожа ли строка на код валюты (2-10 букв и цифр)?
+func validCode(s string) bool {
+	if len(s) < 2 || len(s) > 10 {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePair(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Pair
+		err  bool
+	}{
+		{s: "BTC/USDT", want: BTCUSDT},
+		{s: "eth-usdt", want: Pair{Base: "ETH", Quote: "USDT"}},
+		{s: " SOL / USDT ", want: Pair{Base: "SOL", Quote: "USDT"}},
+		{s: "BTCUSDT", err: true},
+		{s: "BTC/", err: true},
+		{s: "BTC/US DT", err: true},
+		{s: "BTC/USDT/EUR", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePair(tt.s)
+		if tt.err {
+			if !errors.Is(err, ErrBadPair) {
+				t.Errorf("ParsePair(%q) = %v, %v, want %v", tt.s, got, err, ErrBadPair)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePair(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestPair_text(t *testing.T) {
+	var p Pair
+	if err := p.UnmarshalText([]byte("sol-usdt")); err != nil {
+		t.Fatalf("UnmarshalText() = err: %v", err)
+	}
+	b, err := p.MarshalText()
+	if err != nil || string(b) != "SOL/USDT" {
+		t.Errorf("MarshalText() = %s, %v, want SOL/USDT", b, err)
+	}
+	if err := p.UnmarshalText([]byte("SOLUSDT")); !errors.Is(err, ErrBadPair) {
+		t.Errorf("UnmarshalText() = err %v, want %v", err, ErrBadPair)
+	}
+}
+
+func TestPair_names(t *testing.T) {
+	eth := Pair{Base: "ETH", Quote: "USDT"}
+	if got := eth.String(); got != "ETH/USDT" {
+		t.Errorf("String() = %q, want %q", got, "ETH/USDT")
+	}
+	if got := eth.Slug(); got != "ETH-USDT" {
+		t.Errorf("Slug() = %q, want %q", got, "ETH-USDT")
+	}
+	if got := eth.Crosses(); got != "ETH/*" {
+		t.Errorf("Crosses() = %q, want %q", got, "ETH/*")
+	}
+	if got := (Pair{Base: "ETH", Quote: "BTC"}).Crosses(); got != "" {
+		t.Errorf("Crosses() = %q, want none", got)
+	}
+}
@@ -0,0 +1,136 @@
+// Пакет health предоставляет проверки живости
+// и готовности сервера для оркестратора.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// пути проверок
+const (
+	LivePath  = "/healthz"
+	ReadyPath = "/readyz"
+)
+
+// Check - проверка готовности, nil - готов.
+type Check func(context.Context) error
+
+// ErrNotStarted - подсистема еще не запущена.
+var ErrNotStarted = errors.New("not started")
+
+// Flag - проверка, которая проходит после вызова Set,
+// например, когда запущены конвейеры.
+type Flag struct {
+	set int32
+}
+
+// Set отмечает подсистему запущенной.
+func (f *Flag) Set() {
+	atomic.StoreInt32(&f.set, 1)
+}
+
+// Check возвращает ErrNotStarted, пока не вызван Set.
+func (f *Flag) Check(context.Context) error {
+	if atomic.LoadInt32(&f.set) == 0 {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+// API - обработчики проверок.
+type API struct {
+	r       *mux.Router
+	logger  *slog.Logger
+	timeout time.Duration // время на все проверки готовности
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// Возвращает новый объект *API без проверок готовности.
+func New(logger *slog.Logger) *API {
+	api := API{
+		r:       mux.NewRouter(),
+		logger:  logger,
+		timeout: 2 * time.Second,
+		checks:  make(map[string]Check),
+	}
+	api.endpoints()
+	return &api
+}
+
+// endpoints - регистрирует обработчики запросов.
+func (api *API) endpoints() {
+	api.r.HandleFunc(LivePath, api.liveHandler).Methods(http.MethodGet)
+	api.r.HandleFunc(ReadyPath, api.readyHandler).Methods(http.MethodGet)
+}
+
+// Router возвращает маршрутизатор.
+func (api *API) Router() *mux.Router {
+	return api.r
+}
+
+// Ready добавляет проверку готовности name.
+func (api *API) Ready(name string, check Check) {
+	api.mu.Lock()
+	api.checks[name] = check
+	api.mu.Unlock()
+}
+
+// report - ответ проверки.
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// liveHandler отвечает, пока процесс жив.
+func (api *API) liveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report{Status: "ok"})
+}
+
+// readyHandler выполняет все проверки готовности
+// и отвечает 503, если хотя бы одна не прошла.
+func (api *API) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), api.timeout)
+	defer cancel()
+
+	api.mu.Lock()
+	names := make([]string, 0, len(api.checks))
+	for name := range api.checks {
+		names = append(names, name)
+	}
+	checks := make([]Check, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = api.checks[name]
+	}
+	api.mu.Unlock()
+
+	rep := report{Status: "ok", Checks: make(map[string]string, len(names))}
+	code := http.StatusOK
+	for i, name := range names {
+		if err := checks[i](ctx); err != nil {
+			api.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "err", err)
+			rep.Checks[name] = err.Error()
+			rep.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		rep.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(rep)
+}
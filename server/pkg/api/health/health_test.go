@@ -0,0 +1,54 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func get(t *testing.T, h http.Handler, path string) (int, report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var rep report
+	if err := json.NewDecoder(rec.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return rec.Code, rep
+}
+
+func TestAPI(t *testing.T) {
+	api := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var started Flag
+	dbErr := errors.New("connection refused")
+	db := func(context.Context) error { return dbErr }
+	api.Ready("db", func(ctx context.Context) error { return db(ctx) })
+	api.Ready("pipelines", started.Check)
+
+	if code, _ := get(t, api.Router(), LivePath); code != http.StatusOK {
+		t.Errorf("%s status code = %d, want %d", LivePath, code, http.StatusOK)
+	}
+
+	code, rep := get(t, api.Router(), ReadyPath)
+	want := report{Status: "unavailable", Checks: map[string]string{
+		"db":        dbErr.Error(),
+		"pipelines": ErrNotStarted.Error(),
+	}}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(rep, want) {
+		t.Errorf("%s = %d %+v, want %d %+v", ReadyPath, code, rep, http.StatusServiceUnavailable, want)
+	}
+
+	db = func(context.Context) error { return nil }
+	started.Set()
+	code, rep = get(t, api.Router(), ReadyPath)
+	want = report{Status: "ok", Checks: map[string]string{"db": "ok", "pipelines": "ok"}}
+	if code != http.StatusOK || !reflect.DeepEqual(rep, want) {
+		t.Errorf("%s = %d %+v, want %d %+v", ReadyPath, code, rep, http.StatusOK, want)
+	}
+}
@@ -6,13 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 	"xtestserver/domain"
+	"xtestserver/pkg/logging"
 	"xtestserver/pkg/storage"
 	"xtestserver/rates"
 
@@ -25,8 +26,11 @@ type filter = storage.Filter
 const (
 	dateTimeFilter = "date"
 	currencyFilter = "currency"
+	sourceFilter   = "source"
+	reference      = "reference" // ?reference=cbr|ecb - источник курсов фиатных валют
 	limit          = "limit"
 	offset         = "offset"
+	meta           = "meta" // ?meta=source - добавить в ответ источники курсов
 )
 
 const (
@@ -38,15 +42,59 @@ const (
 type API struct {
 	r      *mux.Router
 	db     stor
-	logger *log.Logger
+	logger *slog.Logger
+	opts   options
+	now    func() time.Time
+}
+
+// options - настройки REST API.
+type options struct {
+	btcStale  time.Duration // возраст курса BTC/USDT, после которого он устарел
+	fiatStale time.Duration // возраст курсов фиатных валют, после которого они устарели
+	timeout   time.Duration // таймаут запроса к БД
+}
+
+// Option - функция, изменяющая настройки REST API.
+type Option func(*options)
+
+// WithStaleness устанавливает возраст курсов BTC/USDT
+// и фиатных валют, после которого /api/status
+// сообщает, что данные устарели.
+func WithStaleness(btc, fiats time.Duration) Option {
+	return func(o *options) {
+		if btc > 0 {
+			o.btcStale = btc
+		}
+		if fiats > 0 {
+			o.fiatStale = fiats
+		}
+	}
+}
+
+// WithTimeout устанавливает таймаут запросов к БД.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.timeout = d
+		}
+	}
 }
 
 // Возвращает новый объект *API.
-func New(storage stor, logger *log.Logger) *API {
+func New(storage stor, logger *slog.Logger, opts ...Option) *API {
 	api := API{
 		r:      mux.NewRouter(),
 		db:     storage,
 		logger: logger,
+		opts: options{
+			btcStale:  time.Minute,
+			fiatStale: 96 * time.Hour, // ЦБ не публикует курсы в выходные и праздники
+			timeout:   5 * time.Second,
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(&api.opts)
 	}
 	api.endpoints()
 	return &api
@@ -58,12 +106,18 @@ func (api *API) Router() *mux.Router {
 }
 
 func (api *API) endpoints() {
-	api.r.Use(api.logRequestMiddleware, api.closerMiddleware, api.headersMiddleware)
-	api.r.HandleFunc("/api/btcusdt", api.btcusdtLatestHandler).Methods(http.MethodGet, http.MethodOptions)
-	api.r.HandleFunc("/api/btcusdt", api.btcusdtHistoryHandler).Methods(http.MethodPost, http.MethodOptions) // почему POST???
-	api.r.HandleFunc("/api/latest", api.fiatsBTCLatestHandler).Methods(http.MethodGet, http.MethodOptions)
+	api.r.Use(logging.Middleware(api.logger), api.closerMiddleware, api.headersMiddleware)
+	api.r.HandleFunc("/api/pairs", api.pairsHandler).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc(pairPath, api.pairLatestHandler).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc(pairPath, api.pairHistoryHandler).Methods(http.MethodPost, http.MethodOptions) // почему POST???
+	api.r.HandleFunc(pairPath+"/currencies", api.fiatsPairLatestHandler).Methods(http.MethodGet, http.MethodOptions)
+	// адреса BTC/USDT до появления пар
+	api.r.HandleFunc("/api/btcusdt", api.pairLatestHandler).Methods(http.MethodGet, http.MethodOptions)
+	api.r.HandleFunc("/api/btcusdt", api.pairHistoryHandler).Methods(http.MethodPost, http.MethodOptions)
+	api.r.HandleFunc("/api/latest", api.fiatsPairLatestHandler).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/api/currencies", api.fiatsRubLatestHandler).Methods(http.MethodGet, http.MethodOptions)
 	api.r.HandleFunc("/api/currencies", api.fiatsRubHistoryHandler).Methods(http.MethodPost, http.MethodOptions) // почему POST???
+	api.r.HandleFunc("/api/status", api.statusHandler).Methods(http.MethodGet, http.MethodOptions)
 }
 
 // closerMiddleware читает и закрывает тело запроса
@@ -85,20 +139,49 @@ func (api *API) headersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// logRequestMiddleware логирует request
-func (api *API) logRequestMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		api.logger.Printf("method=%s, path=%s, query=%s host=%s", r.Method, r.URL.Path, r.URL.Query(), r.Host)
-		next.ServeHTTP(w, r)
-	})
+// pairPath - адрес пары: /api/pairs/ETH-USDT.
+const pairPath = "/api/pairs/{base:[0-9A-Za-z]+}-{quote:[0-9A-Za-z]+}"
+
+// pair возвращает пару из адреса запроса,
+// для адресов без пары - BTC/USDT.
+func pair(r *http.Request) (domain.Pair, error) {
+	vars := mux.Vars(r)
+	if vars["base"] == "" {
+		return domain.BTCUSDT, nil
+	}
+	return domain.ParsePair(vars["base"] + "-" + vars["quote"])
+}
+
+// pairsHandler возвращает пары, курсы которых есть в БД.
+func (api *API) pairsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
+	defer cancel()
+	pairs, err := api.db.Pairs(ctx)
+	if err != nil {
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	box := struct {
+		Pairs []domain.Pair `json:"pairs"`
+	}{
+		Pairs: pairs,
+	}
+	// Отправка данных клиенту в формате JSON.
+	_ = json.NewEncoder(w).Encode(&box)
 }
 
-// btcusdtLatestHandler возвращает последнее
-// (текущее) значение пары BTC/USDT.
-func (api *API) btcusdtLatestHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// pairLatestHandler возвращает последнее
+// (текущее) значение пары.
+func (api *API) pairLatestHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := pair(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
 	defer cancel()
-	latest, err := api.db.BtcRate(ctx, filter{Limit: 1})
+	latest, err := api.db.PairRate(ctx, p, filter{Limit: 1, Source: r.URL.Query().Get(sourceFilter)})
 	if err != nil {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -106,27 +189,35 @@ func (api *API) btcusdtLatestHandler(w http.ResponseWriter, r *http.Request) {
 	// Не нашли в БД ничего
 	if len(latest) == 0 {
 		http.Error(w, "latest rate is not found", http.StatusNotFound)
+		return
 	}
 	m := domain.RateMapTimestamp(latest)
+	if withSource(r.URL) {
+		m["source"] = latest[0].Source
+	}
 	// Отправка данных клиенту в формате JSON.
 	_ = json.NewEncoder(w).Encode(&m)
 }
 
-// btcusdtHistoryHandler возвращает
-// историю BTC/USDT с фильтрами по дате и времени и пагинацией.
-func (api *API) btcusdtHistoryHandler(w http.ResponseWriter, r *http.Request) {
-
-	f, err := api.parseQP(r.URL, layoutDateTime)
+// pairHistoryHandler возвращает историю пары
+// с фильтрами по дате и времени и пагинацией.
+func (api *API) pairHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := pair(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := api.parseQP(r.Context(), r.URL, layoutDateTime)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
 	defer cancel()
-	items, err := api.db.BtcRate(ctx, f)
+	items, err := api.db.PairRate(ctx, p, f)
 	if err != nil {
-		api.logger.Printf("db err: %v", err)
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -134,12 +225,17 @@ func (api *API) btcusdtHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	type h struct {
 		Timestamp int64   `json:"timestamp"`
 		Value     float64 `json:"value"`
+		Source    *string `json:"source,omitempty"` // только с ?meta=source
 	}
 	history := make([]h, len(items))
 
+	sources := withSource(r.URL)
 	for i := range items {
 		history[i].Timestamp = items[i].Time
 		history[i].Value = items[i].Value
+		if sources {
+			history[i].Source = &items[i].Source
+		}
 	}
 
 	box := struct {
@@ -153,63 +249,85 @@ func (api *API) btcusdtHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(&box)
 }
 
-// fiatsRubLatestHandler возвращает последние
-// (текущие) значения фиатных валют к RUB.
+// fiatsRubLatestHandler возвращает последние (текущие) значения
+// фиатных валют к RUB или, с ?reference=ecb, к EUR.
 func (api *API) fiatsRubLatestHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	b, err := base(r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
 	defer cancel()
-	latest, err := api.db.FiatsCurrent(ctx)
+	latest, err := api.db.FiatsCurrent(ctx, b)
 	if err != nil {
-		api.logger.Printf("db err: %v", err)
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 	// Не нашли в БД ничего
 	if len(latest) == 0 {
-		http.Error(w, "latest RUB rates not found", http.StatusNotFound)
+		http.Error(w, "latest "+b+" rates not found", http.StatusNotFound)
 		return
 	}
 	m := domain.RateMap(latest)
+	if withSource(r.URL) {
+		m["sources"] = domain.RateSources(latest)
+	}
 	// Отправка данных клиенту в формате JSON.
 	_ = json.NewEncoder(w).Encode(&m)
 }
 
 // fiatsRubLatestHandler возвращает историю изменения фиатных валют
-// к RUB с фильтрами по дате и валюте и пагинацией.
+// к RUB (с ?reference=ecb - к EUR) с фильтрами по дате и валюте
+// и пагинацией.
 func (api *API) fiatsRubHistoryHandler(w http.ResponseWriter, r *http.Request) {
 
-	f, err := api.parseQP(r.URL, layoutDate)
+	f, err := api.parseQP(r.Context(), r.URL, layoutDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.Base, err = base(r.URL)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
 	defer cancel()
 	items, err := api.db.Fiats(ctx, f)
 	if err != nil {
-		api.logger.Printf("db err: %v", err)
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Не нашли в БД ничего
 	if len(items) == 0 {
-		http.Error(w, "history RUB rates not found", http.StatusNotFound)
+		http.Error(w, "history "+f.Base+" rates not found", http.StatusNotFound)
 		return
 	}
 
-	var m = map[string]any{"date": time.Unix(items[0].Time, 0).Format("2006-01-02")}
-	var history = []map[string]any{m}
-
-	for i, j, t := 0, 0, items[0].Time; i < len(items); i, t = i+1, items[i].Time {
-		if t != items[i].Time {
+	// курсы одной даты собираются в одну запись истории;
+	// источники курсов - только с ?meta=source
+	sources := withSource(r.URL)
+	var history []map[string]any
+	var m map[string]any
+	var ms map[string]string
+	for i := range items {
+		if i == 0 || items[i].Time != items[i-1].Time {
+			m = map[string]any{"date": time.Unix(items[i].Time, 0).Format("2006-01-02")}
 			history = append(history, m)
-			j++
-			m = make(map[string]any)
-			history[j]["date"] = time.Unix(items[i].Time, 0).Format("2006-01-02")
+			if sources {
+				ms = make(map[string]string)
+				m["sources"] = ms
+			}
+		}
+		m[items[i].CharCode] = items[i].Value
+		if sources {
+			ms[items[i].CharCode] = items[i].Source
 		}
-		history[j][items[i].CharCode] = items[i].Value
 	}
 
 	box := struct {
@@ -224,30 +342,126 @@ func (api *API) fiatsRubHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(&box)
 }
 
-// fiatsBTCLatestHandler возвращает последние
-// (текущие) значения фиатных валют к BTC.
-func (api *API) fiatsBTCLatestHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// fiatsPairLatestHandler возвращает последние (текущие) значения
+// фиатных валют к базовой валюте пары. Считаются только для пар к USDT
+// через курсы ЦБ или, с ?reference=ecb, ЕЦБ.
+func (api *API) fiatsPairLatestHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := pair(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := base(r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.Crosses() == "" {
+		http.Error(w, "fiat rates are calculated for USDT pairs only", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
 	defer cancel()
-	// последний курс BTC/USDT
-	latest, err := api.db.BtcRate(ctx, filter{Limit: 1})
-	if err != nil || len(latest) == 0 {
-		api.logger.Printf("db err: %v", err)
+	// последний курс пары
+	latest, err := api.db.PairRate(ctx, p, filter{Limit: 1})
+	if err != nil {
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	// считаем курсы фиатных валют к BTC
-	rates, err := rates.CalcRates(ctx, api.db, latest[0].Value)
-	if err != nil || len(rates) == 0 {
-		api.logger.Printf("db err: %v", err)
+	// Не нашли в БД ничего
+	if len(latest) == 0 {
+		http.Error(w, "latest rate is not found", http.StatusNotFound)
+		return
+	}
+	// считаем курсы фиатных валют к базовой валюте
+	rates, err := rates.CalcRates(ctx, api.db, b, latest[0].Value)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "latest "+b+" rates not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	// Не нашли в БД ничего
+	if len(rates) == 0 {
+		http.Error(w, "latest "+b+" rates not found", http.StatusNotFound)
+		return
+	}
 
 	// Отправка данных клиенту в формате JSON.
 	_ = json.NewEncoder(w).Encode(&rates)
 }
 
+// freshness - возраст последних данных.
+type freshness struct {
+	Timestamp int64   `json:"timestamp"`
+	Age       float64 `json:"age_seconds"`
+	Stale     bool    `json:"stale"`
+}
+
+// newFreshness возвращает возраст данных на момент now.
+// ts == 0 - данных нет, они считаются устаревшими.
+func newFreshness(ts int64, now time.Time, stale time.Duration) freshness {
+	if ts == 0 {
+		return freshness{Stale: true}
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age < 0 {
+		age = 0 // ЦБ публикует курсы на следующий день заранее
+	}
+	return freshness{Timestamp: ts, Age: age.Seconds(), Stale: age > stale}
+}
+
+// statusHandler возвращает возраст последнего курса BTC/USDT
+// и курсов фиатных валют. Если данные устарели - 503.
+func (api *API) statusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.opts.timeout)
+	defer cancel()
+
+	btc, err := api.db.PairRate(ctx, domain.BTCUSDT, filter{Limit: 1})
+	if err != nil {
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	fiats, err := api.db.FiatsCurrent(ctx, domain.BaseRUB)
+	if err != nil {
+		api.logger.ErrorContext(r.Context(), "db query", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var btcTime, fiatTime int64
+	if len(btc) > 0 {
+		btcTime = btc[0].Time
+	}
+	for i := range fiats {
+		if fiats[i].Time > fiatTime {
+			fiatTime = fiats[i].Time
+		}
+	}
+
+	now := api.now()
+	box := struct {
+		Status string    `json:"status"`
+		BTC    freshness `json:"btc"`
+		Fiats  freshness `json:"fiats"`
+	}{
+		Status: "ok",
+		BTC:    newFreshness(btcTime, now, api.opts.btcStale),
+		Fiats:  newFreshness(fiatTime, now, api.opts.fiatStale),
+	}
+	if box.BTC.Stale || box.Fiats.Stale {
+		box.Status = "stale"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// Отправка данных клиенту в формате JSON.
+	_ = json.NewEncoder(w).Encode(&box)
+}
+
 func op(o string) string {
 	if o == "gte" {
 		return ">="
@@ -257,12 +471,12 @@ func op(o string) string {
 	return "="
 }
 
-// parseQP - парсит параметеры запроса ?limit=NUM, ?offset=NUM
-// и ?date=[gte:lte:]YYYY-MM-DDTHH:MM:SS
-func (api *API) parseQP(url *url.URL, dateTimeLayout string) (filter, error) {
+// parseQP - парсит параметеры запроса ?limit=NUM, ?offset=NUM,
+// ?currency=CODE, ?source=NAME и ?date=[gte:lte:]YYYY-MM-DDTHH:MM:SS
+func (api *API) parseQP(ctx context.Context, url *url.URL, dateTimeLayout string) (filter, error) {
 	f, err := timeQParser(url, dateTimeFilter, dateTimeLayout)
 	if err != nil {
-		api.logger.Printf("parse query param %q: %v", url.Query().Get(dateTimeFilter), err)
+		api.logger.WarnContext(ctx, "parse query param", "value", url.Query().Get(dateTimeFilter), "err", err)
 		return filter{}, errors.New("bad datetime parameter")
 	}
 
@@ -270,7 +484,7 @@ func (api *API) parseQP(url *url.URL, dateTimeLayout string) (filter, error) {
 	if qp != "" {
 		f.Limit, err = strconv.Atoi(qp)
 		if err != nil {
-			api.logger.Printf("parse query param %q: %v", qp, err)
+			api.logger.WarnContext(ctx, "parse query param", "value", qp, "err", err)
 			return filter{}, errors.New("bad limit parameter")
 		}
 	}
@@ -278,15 +492,35 @@ func (api *API) parseQP(url *url.URL, dateTimeLayout string) (filter, error) {
 	if qp != "" {
 		f.Offset, err = strconv.Atoi(qp)
 		if err != nil {
-			api.logger.Printf("parse query param %q: %v", qp, err)
+			api.logger.WarnContext(ctx, "parse query param", "value", qp, "err", err)
 			return filter{}, errors.New("bad offset parameter")
 		}
 	}
 	f.Currency = url.Query().Get(currencyFilter)
+	f.Source = url.Query().Get(sourceFilter)
 
 	return f, nil
 }
 
+// base возвращает базовую валюту курсов фиатных валют
+// источника из ?reference=NAME, по умолчанию - ЦБ (RUB).
+func base(url *url.URL) (string, error) {
+	ref := url.Query().Get(reference)
+	if ref == "" {
+		return domain.BaseRUB, nil
+	}
+	b, ok := domain.References[ref]
+	if !ok {
+		return "", errors.New("bad reference parameter")
+	}
+	return b, nil
+}
+
+// withSource - запрошены ли источники курсов (?meta=source)?
+func withSource(url *url.URL) bool {
+	return url.Query().Get(meta) == "source"
+}
+
 // timeQParser - парсит параметер запроса ?date=[gte:lte:]YYYY-MM-DDTHH:MM:SS
 func timeQParser(url *url.URL, qpname, layout string) (filter, error) {
 	qp := url.Query().Get(qpname)
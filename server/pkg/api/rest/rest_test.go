@@ -3,15 +3,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
 	"xtestserver/pkg/storage/memdb"
 
 	"github.com/gorilla/mux"
@@ -31,7 +36,9 @@ type hist struct {
 }
 
 func TestMain(m *testing.M) {
-	api = New(memdb.New(), log.New(io.Discard, "", 0))
+	api = New(memdb.New(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	// данные memdb свежие
+	api.now = func() time.Time { return time.Unix(memdb.SampleItem.Time, 0).Add(30 * time.Second) }
 	ts := httptest.NewServer(api.Router())
 	defer ts.Close()
 
@@ -42,7 +49,11 @@ func TestMain(m *testing.M) {
 
 func Test_endpoints(t *testing.T) {
 	err := api.r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-		path, _ := route.URLPath()
+		// переменные маршрутов пар, остальным не мешают
+		path, err := route.URLPath("base", "BTC", "quote", "USDT")
+		if err != nil {
+			return err
+		}
 		methods, _ := route.GetMethods()
 		url := fmt.Sprintf("%s%s", testServerUrl, path)
 
@@ -106,6 +117,49 @@ func TestAPI_btcusdtLatestHandler(t *testing.T) {
 	}
 }
 
+// emptyDB - хранилище без курсов BTC/USDT.
+type emptyDB struct{ *memdb.MemDB }
+
+func (emptyDB) PairRate(context.Context, domain.Pair, storage.Filter) ([]domain.Rate, error) {
+	return nil, nil
+}
+
+func TestAPI_pairLatestHandler_notFound(t *testing.T) {
+	api := New(emptyDB{memdb.New()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	w := httptest.NewRecorder()
+	api.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/btcusdt", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("pairLatestHandler() status code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// noFiatsDB - хранилище без курсов фиатных валют.
+type noFiatsDB struct{ *memdb.MemDB }
+
+func (noFiatsDB) USDRate(_ context.Context, base string) (domain.Rate, error) {
+	return domain.Rate{}, fmt.Errorf("USD/%s: %w", base, storage.ErrNotFound)
+}
+
+func TestAPI_fiatsPairLatestHandler_notFound(t *testing.T) {
+	tests := []struct {
+		db  stor
+		url string
+	}{
+		{db: emptyDB{memdb.New()}, url: "/api/pairs/ETH-USDT/currencies"},
+		{db: noFiatsDB{memdb.New()}, url: "/api/pairs/ETH-USDT/currencies?reference=ecb"},
+	}
+	for _, tt := range tests {
+		api := New(tt.db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		w := httptest.NewRecorder()
+		api.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("fiatsPairLatestHandler() %s status code = %d, want %d", tt.url, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
 func TestAPI_btcusdtHistoryHandler(t *testing.T) {
 
 	limit, offset := 10, 5
@@ -179,3 +233,137 @@ func TestAPI_fiatsRubHistoryHandler(t *testing.T) {
 		t.Errorf("fiatsRubHistoryHandler() = %v, want %v", got, want)
 	}
 }
+
+func TestAPI_statusHandler(t *testing.T) {
+	sample := time.Unix(memdb.SampleItem.Time, 0)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		code   int
+		status string
+		btc    bool // устарел ли BTC/USDT
+		fiats  bool // устарели ли фиатные валюты
+	}{
+		{name: "fresh", now: sample.Add(30 * time.Second), code: http.StatusOK, status: "ok"},
+		{name: "btc_stale", now: sample.Add(2 * time.Minute), code: http.StatusServiceUnavailable, status: "stale", btc: true},
+		{name: "all_stale", now: sample.Add(100 * time.Hour), code: http.StatusServiceUnavailable, status: "stale", btc: true, fiats: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := New(memdb.New(), slog.New(slog.NewTextHandler(io.Discard, nil)), WithStaleness(time.Minute, 96*time.Hour))
+			api.now = func() time.Time { return tt.now }
+
+			rec := httptest.NewRecorder()
+			api.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+
+			var got struct {
+				Status string    `json:"status"`
+				BTC    freshness `json:"btc"`
+				Fiats  freshness `json:"fiats"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("statusHandler() = err: %v", err)
+			}
+			if rec.Code != tt.code || got.Status != tt.status {
+				t.Errorf("statusHandler() = %d %q, want %d %q", rec.Code, got.Status, tt.code, tt.status)
+			}
+			if got.BTC.Stale != tt.btc || got.Fiats.Stale != tt.fiats {
+				t.Errorf("statusHandler() stale btc=%v fiats=%v, want btc=%v fiats=%v",
+					got.BTC.Stale, got.Fiats.Stale, tt.btc, tt.fiats)
+			}
+			if got.BTC.Timestamp != memdb.SampleItem.Time {
+				t.Errorf("statusHandler() btc timestamp = %d, want %d", got.BTC.Timestamp, memdb.SampleItem.Time)
+			}
+		})
+	}
+}
+
+func TestAPI_metaSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   string // источник в ответе, "" - источника нет
+		get    func(m map[string]any) any
+	}{
+		{name: "btc latest", method: http.MethodGet, url: "/api/btcusdt?meta=source", want: domain.SourceAggregate,
+			get: func(m map[string]any) any { return m["source"] }},
+		{name: "btc latest without meta", method: http.MethodGet, url: "/api/btcusdt",
+			get: func(m map[string]any) any { return m["source"] }},
+		{name: "btc history", method: http.MethodPost, url: "/api/btcusdt?limit=1&meta=source", want: domain.SourceAggregate,
+			get: func(m map[string]any) any { return m["history"].([]any)[0].(map[string]any)["source"] }},
+		{name: "btc history without meta", method: http.MethodPost, url: "/api/btcusdt?limit=1",
+			get: func(m map[string]any) any { return m["history"].([]any)[0].(map[string]any)["source"] }},
+		{name: "fiats latest", method: http.MethodGet, url: "/api/currencies?meta=source", want: domain.SourceCBR,
+			get: func(m map[string]any) any { return m["sources"].(map[string]any)["USD"] }},
+		{name: "fiats history", method: http.MethodPost, url: "/api/currencies?limit=1&meta=source", want: domain.SourceCBR,
+			get: func(m map[string]any) any {
+				return m["history"].([]any)[0].(map[string]any)["sources"].(map[string]any)["USD"]
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			api.Router().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+
+			var got map[string]any
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("%s %s = err: %v", tt.method, tt.url, err)
+			}
+			source := tt.get(got)
+			if tt.want == "" && source != nil || tt.want != "" && source != tt.want {
+				t.Errorf("%s %s source = %v, want %q", tt.method, tt.url, source, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_pairs(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		status int
+		want   string // подстрока ответа
+	}{
+		{method: http.MethodGet, url: "/api/pairs", status: http.StatusOK, want: `{"pairs":["BTC/USDT"]}`},
+		{method: http.MethodGet, url: "/api/pairs/eth-usdt", status: http.StatusOK, want: `"timestamp":1658252361`},
+		{method: http.MethodPost, url: "/api/pairs/ETH-USDT?limit=2", status: http.StatusOK, want: `"total":2`},
+		{method: http.MethodGet, url: "/api/pairs/ETH-USDT/currencies", status: http.StatusOK, want: `"RUB":`},
+		{method: http.MethodGet, url: "/api/pairs/ETH-BTC/currencies", status: http.StatusNotFound, want: "USDT pairs only"},
+		{method: http.MethodGet, url: "/api/pairs/ETH-USDT/currencies?reference=ecb", status: http.StatusOK, want: `"EUR":`},
+		{method: http.MethodGet, url: "/api/pairs/E-USDT", status: http.StatusBadRequest, want: "bad pair"},
+		{method: http.MethodGet, url: "/api/pairs/ETHUSDT", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.Router().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.url, rec.Code, rec.Body.String(), tt.status, tt.want)
+		}
+	}
+}
+
+func TestAPI_reference(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		status int
+		want   string // подстрока ответа
+	}{
+		{method: http.MethodGet, url: "/api/latest?reference=ecb", status: http.StatusOK, want: `"EUR":`},
+		{method: http.MethodGet, url: "/api/latest?reference=cbr", status: http.StatusOK, want: `"RUB":`},
+		{method: http.MethodGet, url: "/api/currencies?reference=ecb", status: http.StatusOK, want: `"USD":`},
+		{method: http.MethodPost, url: "/api/currencies?reference=ecb&limit=1", status: http.StatusOK, want: `"total":1`},
+		{method: http.MethodGet, url: "/api/latest?reference=fed", status: http.StatusBadRequest, want: "bad reference"},
+		{method: http.MethodGet, url: "/api/currencies?reference=fed", status: http.StatusBadRequest, want: "bad reference"},
+		{method: http.MethodPost, url: "/api/currencies?reference=fed", status: http.StatusBadRequest, want: "bad reference"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.Router().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.url, rec.Code, rec.Body.String(), tt.status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Пакет sse предоставляет поток обновлений курсов
+// в формате Server-Sent Events, для клиентов,
+// которым не доступен WebSocket.
+package sse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xtestserver/pkg/feed"
+
+	"github.com/gorilla/mux"
+)
+
+// Путь, по которому доступен поток.
+const Path = "/api/stream"
+
+const (
+	channelsParam   = "channels"      // ?channels=BTC/USDT,BTC/*
+	lastEventHeader = "Last-Event-ID" // последний полученный клиентом id
+)
+
+// API - SSE сервер.
+type API struct {
+	r      *mux.Router
+	logger *slog.Logger
+	feed   *feed.Feed
+	opts   options
+	done   chan struct{}
+	once   sync.Once
+}
+
+// options - настройки SSE сервера.
+type options struct {
+	queueSize int           // размер очереди отправки каждого клиента
+	keepAlive time.Duration // интервал комментариев keep-alive
+}
+
+// Option - функция, изменяющая настройки сервера.
+type Option func(*options)
+
+// WithQueueSize устанавливает размер очереди отправки
+// каждого клиента. Клиент, переполнивший очередь,
+// отключается и может переподключиться с Last-Event-ID.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.queueSize = n
+		}
+	}
+}
+
+// WithKeepAlive устанавливает интервал, с которым
+// клиентам отправляются комментарии keep-alive,
+// чтобы прокси не закрывали простаивающие соединения.
+func WithKeepAlive(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.keepAlive = d
+		}
+	}
+}
+
+// Возвращает новый объект *API, раздающий клиентам обновления ленты.
+// Потоки закрываются при отмене контекста или вызове Close.
+func New(ctx context.Context, logger *slog.Logger, f *feed.Feed, opts ...Option) *API {
+	api := API{
+		r:      mux.NewRouter(),
+		logger: logger,
+		feed:   f,
+		opts: options{
+			queueSize: 256,
+			keepAlive: 15 * time.Second,
+		},
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&api.opts)
+	}
+	api.endpoints()
+	go func() {
+		<-ctx.Done()
+		api.Close()
+	}()
+	return &api
+}
+
+// endpoints - регистрирует обработчики запросов.
+func (api *API) endpoints() {
+	api.r.HandleFunc(Path, api.streamHandler).Methods(http.MethodGet)
+}
+
+// Router возвращает маршрутизатор.
+func (api *API) Router() *mux.Router {
+	return api.r
+}
+
+// Close завершает все открытые потоки. Потоки не становятся
+// простаивающими сами по себе, поэтому Close нужно вызвать
+// до (*http.Server).Shutdown, например через RegisterOnShutdown.
+func (api *API) Close() {
+	api.once.Do(func() {
+		close(api.done)
+	})
+}
+
+// streamHandler отправляет клиенту снимок каналов, на которые
+// он подписан (или пропущенные сообщения, если передан
+// Last-Event-ID), а затем обновления по мере их поступления.
+func (api *API) streamHandler(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	offsets, err := parseOffsets(r.Header.Get(lastEventHeader))
+	if err != nil {
+		api.logger.WarnContext(r.Context(), "parse "+lastEventHeader, "value", r.Header.Get(lastEventHeader), "err", err)
+		http.Error(w, "bad Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	s := newStream(parseChannels(r.URL.Query().Get(channelsParam)), offsets, api.opts.queueSize)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // не буферизовать в nginx
+	w.WriteHeader(http.StatusOK)
+	fl.Flush()
+
+	unsubscribe := api.feed.Subscribe(s.init, s.push)
+	defer unsubscribe()
+
+	api.logger.InfoContext(r.Context(), "stream opened", "remote", r.RemoteAddr)
+	defer api.logger.InfoContext(r.Context(), "stream closed", "remote", r.RemoteAddr)
+
+	ticker := time.NewTicker(api.opts.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-api.done:
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-s.notify:
+			evs, overflow := s.pop()
+			for i := range evs {
+				if err := s.write(w, evs[i]); err != nil {
+					return
+				}
+			}
+			if overflow {
+				api.logger.WarnContext(r.Context(), "slow consumer, closing stream", "remote", r.RemoteAddr)
+				fl.Flush()
+				return
+			}
+		}
+		fl.Flush()
+	}
+}
+
+// event - событие потока.
+type event struct {
+	typ  string
+	msg  feed.Message
+	data []byte // готовые данные, если это не сообщение ленты
+}
+
+// stream - поток одного клиента.
+type stream struct {
+	subs    map[string]struct{} // каналы клиента, nil - все каналы
+	offsets map[string]uint64   // последние отправленные номера каналов
+	size    int
+
+	mu       sync.Mutex
+	queue    []event
+	overflow bool
+	notify   chan struct{}
+}
+
+// newStream возвращает новый *stream.
+func newStream(channels []string, offsets map[string]uint64, size int) *stream {
+	s := stream{
+		offsets: offsets,
+		size:    size,
+		notify:  make(chan struct{}, 1),
+	}
+	if len(channels) > 0 {
+		s.subs = make(map[string]struct{}, len(channels))
+		for _, ch := range channels {
+			s.subs[ch] = struct{}{}
+		}
+	}
+	if s.offsets == nil {
+		s.offsets = make(map[string]uint64)
+	}
+	return &s
+}
+
+// subscribed - подписан ли клиент на канал?
+func (s *stream) subscribed(label string) bool {
+	if s.subs == nil || label == "" {
+		return true
+	}
+	_, ok := s.subs[label]
+	return ok
+}
+
+// init ставит в очередь снимок каналов клиента или пропущенные
+// им сообщения, если клиент передал номера последних сообщений.
+// Вызывается лентой под ее семафором.
+func (s *stream) init(st *feed.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, last := range st.Last() {
+		if !s.subscribed(last.Label) {
+			continue
+		}
+		seq, ok := s.offsets[last.Label]
+		if !ok {
+			s.enqueue(event{typ: feed.TypeSnapshot, msg: last})
+			continue
+		}
+		msgs, ok := st.Since(last.Label, seq)
+		if !ok {
+			s.enqueue(event{typ: feed.TypeGap, msg: last, data: feed.Gap(last.Label, last.Seq)})
+			s.enqueue(event{typ: feed.TypeSnapshot, msg: last})
+			continue
+		}
+		for i := range msgs {
+			s.enqueue(event{typ: feed.TypeReplay, msg: msgs[i]})
+		}
+	}
+	s.signal()
+}
+
+// push ставит сообщение ленты в очередь.
+// Вызывается лентой под ее семафором.
+func (s *stream) push(m feed.Message) {
+	if !s.subscribed(m.Label) {
+		return
+	}
+	s.mu.Lock()
+	s.enqueue(event{typ: feed.TypeUpdate, msg: m})
+	s.mu.Unlock()
+	s.signal()
+}
+
+// enqueue добавляет событие в очередь или отмечает
+// переполнение. Вызывается под семафором потока.
+func (s *stream) enqueue(ev event) {
+	if len(s.queue) >= s.size {
+		s.overflow = true
+		return
+	}
+	s.queue = append(s.queue, ev)
+}
+
+// signal сообщает о новых событиях в очереди.
+func (s *stream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default: // сигнал уже отправлен
+	}
+}
+
+// pop забирает все события из очереди.
+func (s *stream) pop() ([]event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	evs := s.queue
+	s.queue = nil
+	return evs, s.overflow
+}
+
+// write отправляет событие клиенту. id события - номера последних
+// сообщений всех каналов клиента, чтобы при переподключении
+// с Last-Event-ID можно было дослать пропущенное по каждому каналу.
+func (s *stream) write(w io.Writer, ev event) error {
+	data := ev.data
+	if data == nil {
+		data = ev.msg.Encode(ev.typ)
+	}
+	var b strings.Builder
+	if ev.msg.Label != "" {
+		s.offsets[ev.msg.Label] = ev.msg.Seq
+		fmt.Fprintf(&b, "id: %s\n", formatOffsets(s.offsets))
+	}
+	fmt.Fprintf(&b, "event: %s\n", ev.typ)
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// parseChannels разбирает список каналов через запятую.
+func parseChannels(qp string) []string {
+	var channels []string
+	for _, ch := range strings.Split(qp, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+// formatOffsets кодирует номера каналов в id события.
+func formatOffsets(offsets map[string]uint64) string {
+	v := make(url.Values, len(offsets))
+	for label, seq := range offsets {
+		v.Set(label, strconv.FormatUint(seq, 10))
+	}
+	return v.Encode()
+}
+
+// parseOffsets разбирает id события в номера каналов.
+func parseOffsets(id string) (map[string]uint64, error) {
+	if id == "" {
+		return nil, nil
+	}
+	v, err := url.ParseQuery(id)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]uint64, len(v))
+	for label := range v {
+		offsets[label], err = strconv.ParseUint(v.Get(label), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,165 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"xtestserver/pkg/feed"
+)
+
+// sseEvent - разобранное событие потока.
+type sseEvent struct {
+	id  string
+	typ string
+	env feed.Envelope
+}
+
+// readEvent читает из потока следующее событие,
+// пропуская комментарии.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	var data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.typ != "":
+			if err := json.Unmarshal([]byte(data), &ev.env); err != nil {
+				t.Fatalf("unmarshal %q: %v", data, err)
+			}
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openStream(t *testing.T, url, lastEventID string) (*bufio.Reader, func()) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set(lastEventHeader, lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("streamHandler() = err: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("streamHandler() status code = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("streamHandler() Content-Type = %q, want %q", ct, "text/event-stream")
+	}
+	return bufio.NewReader(res.Body), func() { _ = res.Body.Close() }
+}
+
+func TestAPI_streamHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := feed.New(make(chan []byte), 2)
+	for _, m := range []string{
+		`{"label":"BTC/USDT","data":{"value":1}}`,
+		`{"label":"BTC/USDT","data":{"value":2}}`,
+		`{"label":"BTC/*","data":{"RUB":1}}`,
+	} {
+		f.Publish([]byte(m))
+	}
+
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), f, WithKeepAlive(10*time.Millisecond))
+	ts := httptest.NewServer(api.Router())
+	defer ts.Close()
+
+	t.Run("snapshot_and_filter", func(t *testing.T) {
+		r, closeStream := openStream(t, ts.URL+Path+"?channels=BTC/USDT", "")
+		defer closeStream()
+
+		ev := readEvent(t, r)
+		if ev.typ != feed.TypeSnapshot || ev.env.Label != "BTC/USDT" || ev.env.Seq != 2 {
+			t.Errorf("streamHandler() = %s %q seq %d, want %s %q seq %d",
+				ev.typ, ev.env.Label, ev.env.Seq, feed.TypeSnapshot, "BTC/USDT", 2)
+		}
+
+		f.Publish([]byte(`{"label":"BTC/*","data":{"RUB":2}}`))
+		f.Publish([]byte(`{"label":"BTC/USDT","data":{"value":3}}`))
+
+		ev = readEvent(t, r)
+		if ev.typ != feed.TypeUpdate || ev.env.Label != "BTC/USDT" || ev.env.Seq != 3 {
+			t.Errorf("streamHandler() = %s %q seq %d, want %s %q seq %d",
+				ev.typ, ev.env.Label, ev.env.Seq, feed.TypeUpdate, "BTC/USDT", 3)
+		}
+		offsets, err := parseOffsets(ev.id)
+		if err != nil {
+			t.Fatalf("parseOffsets() = err: %v", err)
+		}
+		if want := map[string]uint64{"BTC/USDT": 3}; !reflect.DeepEqual(offsets, want) {
+			t.Errorf("streamHandler() id = %v, want %v", offsets, want)
+		}
+	})
+
+	t.Run("last_event_id", func(t *testing.T) {
+		// BTC/USDT: в буфере 2 и 3, первое уже вытеснено
+		id := formatOffsets(map[string]uint64{"BTC/USDT": 0, "BTC/*": 1})
+		r, closeStream := openStream(t, ts.URL+Path, id)
+		defer closeStream()
+
+		got := map[string][]string{}
+		for i := 0; i < 3; i++ {
+			ev := readEvent(t, r)
+			got[ev.env.Label] = append(got[ev.env.Label], ev.typ)
+		}
+		want := map[string][]string{
+			"BTC/USDT": {feed.TypeGap, feed.TypeSnapshot},
+			"BTC/*":    {feed.TypeReplay},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("streamHandler() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("keep_alive", func(t *testing.T) {
+		r, closeStream := openStream(t, ts.URL+Path+"?channels=ETH/USDT", "")
+		defer closeStream()
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if line != ": keep-alive\n" {
+			t.Errorf("streamHandler() = %q, want keep-alive comment", line)
+		}
+	})
+}
+
+func Test_parseOffsets(t *testing.T) {
+	want := map[string]uint64{"BTC/USDT": 41, "BTC/*": 40}
+	got, err := parseOffsets(formatOffsets(want))
+	if err != nil {
+		t.Fatalf("parseOffsets() = err: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOffsets() = %v, want %v", got, want)
+	}
+	if _, err := parseOffsets("BTC%2FUSDT=abc"); err == nil {
+		t.Error("parseOffsets() = nil, want error")
+	}
+}
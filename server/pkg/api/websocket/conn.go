@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Policy - политика обработки медленных клиентов,
+// у которых переполнилась очередь отправки.
+type Policy int
+
+const (
+	DropOldest Policy = iota // выбрасываем самое старое сообщение из очереди
+	Conflate                 // оставляем только последнее сообщение по каждому каналу, кроме досылки
+	Disconnect               // отключаем клиента с кодом закрытия
+)
+
+// String возвращает название политики.
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case Conflate:
+		return "conflate"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// ParsePolicy возвращает политику по ее названию.
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{DropOldest, Conflate, Disconnect} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy %q", s)
+}
+
+// queue - ограниченная очередь отправки.
+// Не потокобезопасна, защищается семафором клиента.
+type queue struct {
+	size  int
+	items []message
+}
+
+// push добавляет сообщение в очередь согласно политике.
+// Возвращает false, если очередь переполнена
+// и политика требует отключить клиента.
+func (q *queue) push(m message, p Policy) bool {
+	// досланные сообщения и разрывы не объединяются,
+	// иначе клиент потеряет пропущенное
+	if p == Conflate && !m.keep {
+		for i := range q.items {
+			if q.items[i].label == m.label && !q.items[i].keep {
+				q.items[i] = m // заменяем устаревшее значение
+				return true
+			}
+		}
+	}
+	if len(q.items) >= q.size {
+		if p == Disconnect {
+			return false
+		}
+		q.items = q.items[1:] // выбрасываем самое старое
+	}
+	q.items = append(q.items, m)
+	return true
+}
+
+// pop забирает все сообщения из очереди.
+func (q *queue) pop() []message {
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// client - соединение с собственной очередью
+// отправки и пишущей горутиной.
+type client struct {
+	conn   *websocket.Conn
+	policy Policy
+	wtime  time.Duration // дедлайн на запись
+	ping   time.Duration // интервал пингов
+	pong   time.Duration // время ожидания понга
+
+	mu     sync.Mutex
+	queue  queue
+	subs   map[string]struct{} // подписки на каналы, nil - все каналы
+	notify chan struct{}       // сигнал о новых сообщениях в очереди
+	done   chan struct{}       // сигнал о закрытии соединения
+	once   sync.Once
+}
+
+// newClient возвращает новый *client.
+func newClient(c *websocket.Conn, o options) *client {
+	return &client{
+		conn:   c,
+		policy: o.policy,
+		wtime:  o.writeTimeout,
+		ping:   o.pingInterval,
+		pong:   o.pongWait,
+		queue:  queue{size: o.queueSize},
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// send ставит сообщение в очередь отправки.
+// Возвращает false, если клиент не успевает
+// читать сообщения и должен быть отключен.
+// Сообщения каналов, на которые клиент не подписан, пропускаются.
+func (c *client) send(m message) bool {
+	c.mu.Lock()
+	if !c.subscribed(m.label) {
+		c.mu.Unlock()
+		return true
+	}
+	ok := c.queue.push(m, c.policy)
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case c.notify <- struct{}{}:
+	default: // сигнал уже отправлен
+	}
+	return true
+}
+
+// subscribed - подписан ли клиент на канал?
+// Вызывается под семафором клиента.
+func (c *client) subscribed(label string) bool {
+	if c.subs == nil || label == "" {
+		return true
+	}
+	_, ok := c.subs[label]
+	return ok
+}
+
+// subscribe заменяет подписки клиента
+// переданным списком каналов.
+func (c *client) subscribe(channels []string) {
+	c.mu.Lock()
+	c.subs = make(map[string]struct{}, len(channels))
+	for _, ch := range channels {
+		c.subs[ch] = struct{}{}
+	}
+	c.mu.Unlock()
+}
+
+// writer отправляет сообщения из очереди клиенту
+// и периодически пингует его, пока соединение не закрыто.
+func (c *client) writer() {
+	ticker := time.NewTicker(c.ping)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.wtime))
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+			continue
+		case <-c.notify:
+		}
+
+		c.mu.Lock()
+		items := c.queue.pop()
+		c.mu.Unlock()
+
+		for i := range items {
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.wtime))
+			if err := c.conn.WriteMessage(websocket.TextMessage, items[i].data); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// reader читает входящие сообщения и передает их
+// обработчику, пока не случится ошибка. Каждый понг
+// продлевает дедлайн на чтение, поэтому клиент,
+// пропустивший пинги, отваливается по таймауту.
+// Возвращает ошибку, которой завершилось чтение.
+func (c *client) reader(handle func(*client, []byte)) error {
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.pong))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.pong))
+	})
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		handle(c, b)
+	}
+}
+
+// close отправляет клиенту сообщение о закрытии
+// с переданным кодом и закрывает соединение.
+// Повторные вызовы ничего не делают.
+func (c *client) close(code int, text string) {
+	c.once.Do(func() {
+		close(c.done)
+		if code != websocket.CloseAbnormalClosure {
+			_ = c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, text), time.Now().Add(c.wtime))
+		}
+		_ = c.conn.Close()
+	})
+}
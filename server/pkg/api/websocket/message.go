@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"xtestserver/pkg/feed"
+)
+
+// типы входящих запросов
+const (
+	reqSubscribe = "subscribe" // подписка на каналы
+	reqResume    = "resume"    // досылка пропущенных сообщений
+)
+
+// offsetParam - параметр адреса подключения
+// ?offset=КАНАЛ:SEQ (можно несколько): последний
+// полученный номер канала для досылки пропущенного,
+// то же, что запрос resume сразу после подключения.
+const offsetParam = "offset"
+
+// request - запрос клиента.
+type request struct {
+	Type     string            `json:"type"`
+	Channels []string          `json:"channels,omitempty"` // для subscribe
+	Offsets  map[string]uint64 `json:"offsets,omitempty"`  // для resume: последний полученный seq канала
+}
+
+// message - сообщение в очереди отправки клиента.
+type message struct {
+	label string // канал сообщения
+	data  []byte // сообщение, готовое к отправке
+	keep  bool   // не объединяется политикой Conflate: досылка и разрыв
+}
+
+// newMessage упаковывает сообщение ленты
+// в конверт с переданным типом.
+func newMessage(m feed.Message, typ string) message {
+	return message{label: m.Label, data: m.Encode(typ), keep: typ == feed.TypeReplay}
+}
+
+// parseOffsets разбирает номера каналов из ?offset=КАНАЛ:SEQ.
+func parseOffsets(q url.Values) (map[string]uint64, error) {
+	vs := q[offsetParam]
+	if len(vs) == 0 {
+		return nil, nil
+	}
+	offsets := make(map[string]uint64, len(vs))
+	for _, v := range vs {
+		i := strings.LastIndexByte(v, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("bad offset %q", v)
+		}
+		seq, err := strconv.ParseUint(v[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad offset %q", v)
+		}
+		offsets[v[:i]] = seq
+	}
+	return offsets, nil
+}
@@ -2,9 +2,14 @@ package ws
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"log/slog"
+	"net"
 	"net/http"
 	"sync"
+	"time"
+	"xtestserver/pkg/feed"
+	"xtestserver/pkg/logging"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -13,31 +18,53 @@ import (
 // API - websocket сервер
 type API struct {
 	r       *mux.Router
-	logger  *log.Logger
+	logger  *slog.Logger
+	opts    options
 	clients clients
+	feed    *feed.Feed
 	done    chan struct{}
 }
 
 // clients - потокобезопасное отображение.
 type clients struct {
 	mu    sync.Mutex
-	conns map[*websocket.Conn]struct{}
+	conns map[*client]struct{}
 }
 
 // put блокирует семафор и добавляет в отображение.
-func (cm *clients) put(c *websocket.Conn) {
+func (cm *clients) put(c *client) {
 	cm.mu.Lock()
 	cm.conns[c] = struct{}{}
 	cm.mu.Unlock()
 }
 
 // delete блокирует семафор и удаляет из отображения.
-func (cm *clients) delete(c *websocket.Conn) {
+func (cm *clients) delete(c *client) {
 	cm.mu.Lock()
 	delete(cm.conns, c)
 	cm.mu.Unlock()
 }
 
+// list блокирует семафор и возвращает
+// срез всех текущих соединений.
+func (cm *clients) list() []*client {
+	cm.mu.Lock()
+	list := make([]*client, 0, len(cm.conns))
+	for c := range cm.conns {
+		list = append(list, c)
+	}
+	cm.mu.Unlock()
+	return list
+}
+
+// len блокирует семафор и возвращает
+// количество текущих соединений.
+func (cm *clients) len() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return len(cm.conns)
+}
+
 // clean блокирует семафор и удаляет
 // из отображения все соединения.
 func (cm *clients) clean() {
@@ -48,24 +75,89 @@ func (cm *clients) clean() {
 	cm.mu.Unlock()
 }
 
-// Возвращает новый объект *API
-func New(ctx context.Context, logger *log.Logger, upd <-chan []byte) *API {
+// options - настройки websocket сервера.
+type options struct {
+	queueSize    int           // размер очереди отправки каждого соединения
+	writeTimeout time.Duration // дедлайн на запись одного сообщения
+	policy       Policy        // политика для медленных клиентов
+	pingInterval time.Duration // интервал пингов клиентам
+	pongWait     time.Duration // сколько ждём понга, прежде чем закрыть соединение
+}
+
+// Option - функция, изменяющая настройки сервера.
+type Option func(*options)
+
+// WithQueueSize устанавливает размер
+// очереди отправки каждого соединения.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.queueSize = n
+		}
+	}
+}
+
+// WithWriteTimeout устанавливает дедлайн
+// на запись одного сообщения клиенту.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.writeTimeout = d
+		}
+	}
+}
+
+// WithPolicy устанавливает политику
+// обработки медленных клиентов.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithHeartbeat устанавливает интервал пингов и время
+// ожидания ответа от клиента. Клиенты, не ответившие
+// за pongWait, отключаются. pongWait должен быть
+// больше, чем pingInterval.
+func WithHeartbeat(pingInterval, pongWait time.Duration) Option {
+	return func(o *options) {
+		if pingInterval > 0 && pongWait > pingInterval {
+			o.pingInterval = pingInterval
+			o.pongWait = pongWait
+		}
+	}
+}
+
+// Возвращает новый объект *API, раздающий клиентам обновления ленты.
+func New(ctx context.Context, logger *slog.Logger, f *feed.Feed, opts ...Option) *API {
 	api := API{
 		r:      mux.NewRouter(),
 		logger: logger,
+		opts: options{
+			queueSize:    256,
+			writeTimeout: 10 * time.Second,
+			policy:       DropOldest,
+			pingInterval: 30 * time.Second,
+			pongWait:     60 * time.Second,
+		},
 		clients: clients{
-			conns: make(map[*websocket.Conn]struct{}),
+			conns: make(map[*client]struct{}),
 		},
+		feed: f,
 		done: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(&api.opts)
+	}
 	api.endpoints()
-	go api.update(upd)
-	go api.closer(ctx)
+	unsubscribe := f.Subscribe(nil, api.broadcast)
+	go api.closer(ctx, unsubscribe)
 	return &api
 }
 
 // endpoints - регистрирует обработчики запросов.
 func (api *API) endpoints() {
+	api.r.Use(logging.Middleware(api.logger))
 	api.r.HandleFunc("/", api.clientHandler)
 }
 
@@ -74,70 +166,140 @@ func (api *API) Router() *mux.Router {
 	return api.r
 }
 
+// Clients возвращает количество подключенных клиентов.
+func (api *API) Clients() int {
+	return api.clients.len()
+}
+
 // closer слушает контектс и закрывает сервер
 // в случае отмены контекста.
-func (api *API) closer(ctx context.Context) {
+func (api *API) closer(ctx context.Context, unsubscribe func()) {
 	<-ctx.Done()
+	unsubscribe()
 	close(api.done)
-	api.broadcast(websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "server closed"))
-	api.clients.clean()
-}
-
-// updater следит за каналом обновлений и
-// вызывает рассылающую функцию в случае нового обновления.
-// В случае закрытия контекста передает сигнал обработчикам
-// о том что, пора тушить соединения.
-func (api *API) update(upd <-chan []byte) {
-	for u := range upd {
-		api.broadcast(websocket.TextMessage, u)
+	for _, c := range api.clients.list() {
+		c.close(websocket.CloseNormalClosure, "server closed")
 	}
+	api.clients.clean()
 }
 
 var upgrader = websocket.Upgrader{} // дефолтные опции.
 
 // clientHandler апгрейдит нового клиента до WebSocket,
-// и отправляет его в пул соединений.
+// и отправляет его в пул соединений. Клиенту, передавшему
+// номера каналов в ?offset=, досылается пропущенное.
 // Если сервер закрыт к этому моменту, то сразу закрывает соединение.
 func (api *API) clientHandler(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
+	offsets, err := parseOffsets(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		api.logger.Printf("upgrade: %v", err)
+		api.logger.WarnContext(r.Context(), "upgrade", "err", err)
 		return
 	}
+	c := newClient(conn, api.opts)
 	select {
 	case <-api.done:
-		err := c.WriteMessage(websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "server closed"))
-		if err != nil {
-			api.logger.Printf("close: %v", err)
-		}
-		_ = c.Close()
+		c.close(websocket.CloseNormalClosure, "server closed")
 	default:
-		api.logger.Printf("client connected: %s", c.RemoteAddr())
-		api.clients.put(c) // сохраняем соединение
+		api.logger.InfoContext(r.Context(), "client connected", "remote", conn.RemoteAddr())
+		api.register(c, offsets) // сохраняем соединение и отправляем снимок
+		go c.writer()
 		go func() {
-			for {
-				_, _, err := c.ReadMessage()
-				if err != nil {
-					break
-				}
+			err := c.reader(api.handle)
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				api.logger.Warn("client missed heartbeat", "remote", conn.RemoteAddr())
 			}
-			api.logger.Printf("client disconnected: %s", c.RemoteAddr())
+			api.logger.Info("client disconnected", "remote", conn.RemoteAddr())
 			api.clients.delete(c) // удаляем соединение
+			c.close(websocket.CloseNormalClosure, "")
 		}()
 	}
 }
 
-// broadcast рассылает сообщение всем
-// подключенным клиентам.
-func (api *API) broadcast(messageType int, message []byte) {
-	api.clients.mu.Lock()
-	for c := range api.clients.conns {
-		err := c.WriteMessage(messageType, message)
-		if err != nil {
-			api.logger.Printf("conn %q: write: %v", c.RemoteAddr(), err)
+// register сохраняет соединение и ставит в его очередь
+// снимок последних сообщений всех каналов, а по каналам
+// из offsets - пропущенные после них сообщения: до запросов
+// клиента, чтобы досылка не пришла после более нового снимка.
+func (api *API) register(c *client, offsets map[string]uint64) {
+	api.feed.Do(func(s *feed.State) {
+		api.clients.put(c)
+		api.snapshot(c, s, offsets)
+		api.resume(c, s, offsets)
+	})
+}
+
+// snapshot ставит в очередь клиента последние сообщения
+// каналов, на которые он подписан, кроме каналов из skip.
+func (api *API) snapshot(c *client, s *feed.State, skip map[string]uint64) {
+	for _, m := range s.Last() {
+		if _, ok := skip[m.Label]; !ok {
+			c.send(newMessage(m, feed.TypeSnapshot))
+		}
+	}
+}
+
+// resume досылает клиенту сообщения, пропущенные после
+// переданных номеров. Если пропущенных сообщений канала
+// уже нет в буфере, то отправляет ошибку и снимок канала.
+func (api *API) resume(c *client, s *feed.State, offsets map[string]uint64) {
+	for label, seq := range offsets {
+		last, ok := s.LastOf(label)
+		if !ok {
+			continue // о канале еще ничего не известно
+		}
+		msgs, ok := s.Since(label, seq)
+		if !ok {
+			c.send(message{label: label, data: feed.Gap(label, last.Seq), keep: true})
+			c.send(newMessage(last, feed.TypeSnapshot))
+			continue
+		}
+		for i := range msgs {
+			c.send(newMessage(msgs[i], feed.TypeReplay))
+		}
+	}
+}
+
+// handle обрабатывает запрос клиента.
+func (api *API) handle(c *client, b []byte) {
+	var req request
+	if err := json.Unmarshal(b, &req); err != nil {
+		api.logger.Warn("bad request", "remote", c.conn.RemoteAddr(), "err", err)
+		return
+	}
+	switch req.Type {
+	case reqSubscribe:
+		api.feed.Do(func(s *feed.State) {
+			c.subscribe(req.Channels)
+			api.snapshot(c, s, nil)
+		})
+	case reqResume:
+		// досылка под лентой: новые сообщения канала
+		// придут клиенту только после пропущенных
+		api.feed.Do(func(s *feed.State) {
+			api.resume(c, s, req.Offsets)
+		})
+	default:
+		api.logger.Warn("unknown request type", "remote", c.conn.RemoteAddr(), "type", req.Type)
+	}
+}
+
+// broadcast ставит сообщение ленты в очереди отправки всех
+// подключенных клиентов. Вызывается лентой и не блокируется:
+// клиенты, которые не успевают читать сообщения,
+// отключаются в отдельной горутине согласно политике сервера.
+func (api *API) broadcast(fm feed.Message) {
+	m := newMessage(fm, feed.TypeUpdate)
+	for _, c := range api.clients.list() {
+		if !c.send(m) {
+			api.clients.delete(c)
+			go func(c *client) {
+				api.logger.Warn("slow consumer, disconnecting", "remote", c.conn.RemoteAddr())
+				c.close(websocket.CloseTryAgainLater, "slow consumer")
+			}(c)
 		}
 	}
-	api.clients.mu.Unlock()
 }
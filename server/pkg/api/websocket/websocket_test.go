@@ -2,13 +2,18 @@ package ws
 
 import (
 	"context"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+	"xtestserver/pkg/feed"
 
 	"github.com/gorilla/websocket"
 )
@@ -21,7 +26,7 @@ func TestAPI_clientHandler(t *testing.T) {
 	ch := make(chan []byte)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	api := New(ctx, log.New(io.Discard, "", 0), ch)
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), feed.New(ch, 0))
 
 	ts := httptest.NewServer(api.Router())
 	defer ts.Close()
@@ -85,3 +90,353 @@ func TestAPI_clientHandler(t *testing.T) {
 		t.Fatalf("clientHandler() total messages = %d, want %d", count, howManyClients*howManyMessages)
 	}
 }
+
+func Test_queue_push(t *testing.T) {
+	m1 := newMessage(feed.Parse([]byte(`{"label":"BTC/USDT","data":{"value":1}}`)), feed.TypeUpdate)
+	m2 := newMessage(feed.Parse([]byte(`{"label":"BTC/*","data":{"RUB":1}}`)), feed.TypeUpdate)
+	m3 := newMessage(feed.Parse([]byte(`{"label":"BTC/USDT","data":{"value":2}}`)), feed.TypeUpdate)
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []message
+		wantOk bool
+	}{
+		{name: "drop_oldest", policy: DropOldest, want: []message{m2, m3}, wantOk: true},
+		{name: "conflate", policy: Conflate, want: []message{m3, m2}, wantOk: true},
+		{name: "disconnect", policy: Disconnect, want: []message{m1, m2}, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := queue{size: 2}
+			ok := true
+			for _, m := range []message{m1, m2, m3} {
+				ok = q.push(m, tt.policy)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("push() = %t, want %t", ok, tt.wantOk)
+			}
+			got := q.pop()
+			if len(got) != len(tt.want) {
+				t.Fatalf("pop() len = %d, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if string(got[i].data) != string(tt.want[i].data) {
+					t.Errorf("pop()[%d] = %s, want %s", i, got[i].data, tt.want[i].data)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{DropOldest, Conflate, Disconnect} {
+		got, err := ParsePolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParsePolicy(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParsePolicy("drop-newest"); err == nil {
+		t.Errorf("ParsePolicy(%q) = nil error, want error", "drop-newest")
+	}
+}
+
+func Test_queue_pushReplay(t *testing.T) {
+	msg := func(value int, typ string) message {
+		return newMessage(feed.Parse([]byte(fmt.Sprintf(`{"label":"BTC/USDT","data":{"value":%d}}`, value))), typ)
+	}
+	r1, r2 := msg(1, feed.TypeReplay), msg(2, feed.TypeReplay)
+	u3, u4 := msg(3, feed.TypeUpdate), msg(4, feed.TypeUpdate)
+
+	// досылка не объединяется ни с обновлениями, ни между собой
+	q := queue{size: 4}
+	for _, m := range []message{r1, r2, u3, u4} {
+		q.push(m, Conflate)
+	}
+	got := q.pop()
+	want := []message{r1, r2, u4}
+	if len(got) != len(want) {
+		t.Fatalf("pop() len = %d, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if string(got[i].data) != string(want[i].data) {
+			t.Errorf("pop()[%d] = %s, want %s", i, got[i].data, want[i].data)
+		}
+	}
+}
+
+func Test_parseOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    map[string]uint64
+		wantErr bool
+	}{
+		{name: "none", query: "", want: nil},
+		{name: "many", query: "offset=BTC/USDT:2&offset=BTC/*:7", want: map[string]uint64{"BTC/USDT": 2, "BTC/*": 7}},
+		{name: "no_seq", query: "offset=BTC/USDT", wantErr: true},
+		{name: "bad_seq", query: "offset=BTC/USDT:x", wantErr: true},
+		{name: "no_label", query: "offset=:2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			got, err := parseOffsets(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOffsets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOffsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_slowConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), feed.New(make(chan []byte), 0), WithQueueSize(1), WithPolicy(Disconnect))
+
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- c
+	}))
+	defer ts.Close()
+
+	u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/"}
+	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	// пишущая горутина не запущена - клиент "завис"
+	sc := newClient(<-conns, api.opts)
+	api.clients.put(sc)
+
+	api.broadcast(feed.Parse([]byte("update")))
+	api.broadcast(feed.Parse([]byte("update")))
+
+	if len(api.clients.list()) != 0 {
+		t.Errorf("broadcast() clients = %d, want %d", len(api.clients.list()), 0)
+	}
+
+	_, _, err = c.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Fatalf("slow consumer close = %v, want code %d", err, websocket.CloseTryAgainLater)
+	}
+}
+
+func TestAPI_heartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), feed.New(make(chan []byte), 0),
+		WithHeartbeat(10*time.Millisecond, 50*time.Millisecond))
+
+	ts := httptest.NewServer(api.Router())
+	defer ts.Close()
+
+	u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/"}
+
+	// живой клиент читает соединение и отвечает на пинги
+	alive, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer alive.Close()
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// мёртвый клиент ничего не читает и не отвечает на пинги
+	dead, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer dead.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for len(api.clients.list()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("heartbeat clients = %d, want %d", len(api.clients.list()), 1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond) // живой клиент переживает несколько таймаутов
+	if n := len(api.clients.list()); n != 1 {
+		t.Fatalf("heartbeat clients = %d, want %d", n, 1)
+	}
+}
+
+func TestAPI_snapshot(t *testing.T) {
+	ch := make(chan []byte)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), feed.New(ch, 0))
+
+	ts := httptest.NewServer(api.Router())
+	defer ts.Close()
+
+	ch <- []byte(`{"label":"BTC/USDT","data":{"value":1}}`)
+	ch <- []byte(`{"label":"BTC/*","data":{"RUB":1}}`)
+	ch <- []byte(`{"label":"BTC/USDT","data":{"value":2}}`)
+
+	// ждём, пока сервер запомнит последние сообщения
+	deadline := time.Now().Add(time.Second)
+	for {
+		var n string
+		api.feed.Do(func(s *feed.State) {
+			m, _ := s.LastOf("BTC/USDT")
+			n = string(m.Data)
+		})
+		if n == `{"value":2}` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("broadcast() = last message wasn't stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/"}
+	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	read := func() feed.Envelope {
+		var e feed.Envelope
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		if err := c.ReadJSON(&e); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return e
+	}
+
+	// снимок всех каналов сразу после подключения
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		e := read()
+		if e.Type != feed.TypeSnapshot {
+			t.Errorf("snapshot type = %q, want %q", e.Type, feed.TypeSnapshot)
+		}
+		got[e.Label] = string(e.Data)
+	}
+	want := map[string]string{"BTC/USDT": `{"value":2}`, "BTC/*": `{"RUB":1}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot = %v, want %v", got, want)
+	}
+
+	// после подписки - снимок только выбранного канала
+	err = c.WriteJSON(request{Type: reqSubscribe, Channels: []string{"BTC/*"}})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if e := read(); e.Type != feed.TypeSnapshot || e.Label != "BTC/*" {
+		t.Errorf("subscribe snapshot = %q %q, want %q %q", e.Type, e.Label, feed.TypeSnapshot, "BTC/*")
+	}
+
+	// обновления других каналов не приходят
+	ch <- []byte(`{"label":"BTC/USDT","data":{"value":3}}`)
+	ch <- []byte(`{"label":"BTC/*","data":{"RUB":2}}`)
+	if e := read(); e.Type != feed.TypeUpdate || e.Label != "BTC/*" || string(e.Data) != `{"RUB":2}` {
+		t.Errorf("update = %q %q %s, want %q %q %s", e.Type, e.Label, e.Data, feed.TypeUpdate, "BTC/*", `{"RUB":2}`)
+	}
+}
+
+func TestAPI_resume(t *testing.T) {
+	ch := make(chan []byte)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), feed.New(ch, 2))
+
+	ts := httptest.NewServer(api.Router())
+	defer ts.Close()
+
+	for i := 1; i <= 4; i++ {
+		ch <- []byte(fmt.Sprintf(`{"label":"BTC/USDT","data":{"value":%d}}`, i))
+	}
+	ch <- []byte(`{"label":"BTC/*","data":{"RUB":1}}`)
+
+	// ждём, пока сервер запомнит последнее сообщение
+	for {
+		var ok bool
+		api.feed.Do(func(s *feed.State) {
+			_, ok = s.LastOf("BTC/*")
+		})
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	dial := func(offset string) (*websocket.Conn, func() feed.Envelope) {
+		u := url.URL{Scheme: "ws", Host: strings.TrimPrefix(ts.URL, "http://"), Path: "/",
+			RawQuery: url.Values{offsetParam: {offset}}.Encode()}
+		c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		return c, func() feed.Envelope {
+			var e feed.Envelope
+			_ = c.SetReadDeadline(time.Now().Add(time.Second))
+			if err := c.ReadJSON(&e); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			return e
+		}
+	}
+
+	// снимок приходит только по каналам без номера,
+	// пропущенное досылается до любых обновлений
+	c, read := dial("BTC/USDT:2")
+	if e := read(); e.Type != feed.TypeSnapshot || e.Label != "BTC/*" || e.Seq != 1 {
+		t.Errorf("snapshot = %q %q seq %d, want %q %q seq %d", e.Type, e.Label, e.Seq, feed.TypeSnapshot, "BTC/*", 1)
+	}
+	for _, want := range []uint64{3, 4} {
+		if e := read(); e.Type != feed.TypeReplay || e.Seq != want {
+			t.Errorf("resume = %q seq %d, want %q seq %d", e.Type, e.Seq, feed.TypeReplay, want)
+		}
+	}
+	c.Close()
+
+	c, read = dial("BTC/USDT:1")
+	defer c.Close()
+	if e := read(); e.Type != feed.TypeSnapshot || e.Label != "BTC/*" {
+		t.Errorf("snapshot = %q %q, want %q %q", e.Type, e.Label, feed.TypeSnapshot, "BTC/*")
+	}
+	if e := read(); e.Type != feed.TypeGap || e.Error != feed.GapTooLarge || e.Seq != 4 {
+		t.Errorf("resume = %q %q seq %d, want %q %q seq %d", e.Type, e.Error, e.Seq, feed.TypeGap, feed.GapTooLarge, 4)
+	}
+	if e := read(); e.Type != feed.TypeSnapshot || e.Seq != 4 {
+		t.Errorf("resume = %q seq %d, want %q seq %d", e.Type, e.Seq, feed.TypeSnapshot, 4)
+	}
+
+	ch <- []byte(`{"label":"BTC/USDT","data":{"value":5}}`)
+	if e := read(); e.Type != feed.TypeUpdate || e.Seq != 5 {
+		t.Errorf("update = %q seq %d, want %q seq %d", e.Type, e.Seq, feed.TypeUpdate, 5)
+	}
+
+	// уже подключенный клиент просит дослать пропущенное запросом
+	err := c.WriteJSON(request{Type: reqResume, Offsets: map[string]uint64{"BTC/USDT": 3}})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []uint64{4, 5} {
+		if e := read(); e.Type != feed.TypeReplay || e.Seq != want {
+			t.Errorf("resume = %q seq %d, want %q seq %d", e.Type, e.Seq, feed.TypeReplay, want)
+		}
+	}
+}
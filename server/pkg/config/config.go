@@ -0,0 +1,547 @@
+// Пакет config описывает настройки сервера. Настройки собираются
+// из значений по умолчанию, YAML файла, переменных окружения
+// и флагов командной строки, именно в таком порядке приоритета.
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xtestserver/domain"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration - длительность, которая в файле, окружении
+// и флагах записывается строкой: "10s", "24h".
+type Duration time.Duration
+
+// MarshalText кодирует длительность строкой.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText разбирает длительность из строки.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Listen - адреса серверов.
+type Listen struct {
+	REST      string `yaml:"rest" env:"REST_ADDR"`
+	WebSocket string `yaml:"websocket" env:"WEBSOCKET_ADDR"`
+	Admin     string `yaml:"admin" env:"ADMIN_ADDR"`
+}
+
+// Source - источник курсов.
+type Source struct {
+	Name     string   `yaml:"name"` // источник: определяет формат ответа, имя для метрик и логов
+	URL      string   `yaml:"url"`
+	Interval Duration `yaml:"interval"` // интервал опроса, для потока - интервал пингов
+	Timeout  Duration `yaml:"timeout"`  // таймаут одного запроса, для потока - подключения
+	// Stream - получать тикеры из потока биржи по WebSocket
+	// вместо опроса; URL - адрес потока (Binance) или
+	// получения токена (KuCoin).
+	Stream bool   `yaml:"stream"`
+	Symbol string `yaml:"symbol"` // инструмент потока: BTCUSDT (Binance), BTC-USDT (KuCoin)
+}
+
+// Sources - источники курсов.
+type Sources struct {
+	BTC       Source        `yaml:"btc"`
+	Exchanges []Source      `yaml:"exchanges"` // другие биржи BTC/USDT, задаются только в файле
+	Pairs     []PairSources `yaml:"pairs"`     // другие пары, задаются только в файле
+	Fiat      Source        `yaml:"fiat"`
+	// другие источники курсов фиатных валют (ecb) со своей
+	// базовой валютой, задаются только в файле
+	References []Source `yaml:"references"`
+}
+
+// PairSources - биржи, по котировкам которых
+// рассчитывается курс пары кроме BTC/USDT.
+type PairSources struct {
+	Pair      domain.Pair `yaml:"pair"` // ETH/USDT
+	Exchanges []Source    `yaml:"exchanges"`
+}
+
+// BTCs возвращает все биржи, котировки которых
+// участвуют в расчете курса BTC/USDT.
+func (s Sources) BTCs() []Source {
+	return append([]Source{s.BTC}, s.Exchanges...)
+}
+
+// Fiats возвращает все источники курсов фиатных валют.
+func (s Sources) Fiats() []Source {
+	return append([]Source{s.Fiat}, s.References...)
+}
+
+// Aggregate - расчет курса пары по котировкам бирж.
+type Aggregate struct {
+	Method       string   `yaml:"method" env:"AGGREGATE_METHOD"` // median или vwap
+	MaxAge       Duration `yaml:"max_age"`                       // более старые котировки не учитываются
+	MaxDeviation float64  `yaml:"max_deviation"`                 // допустимое отклонение от медианы в долях, 0 - любое
+}
+
+// Storage - хранилище и шина обновлений.
+type Storage struct {
+	URL           string   `yaml:"url" env:"DB_URL"`
+	Retries       int      `yaml:"retries"`        // попыток подключения при старте
+	RetryInterval Duration `yaml:"retry_interval"` // пауза между попытками
+	SlowQuery     Duration `yaml:"slow_query" env:"SLOW_QUERY"`
+	HistoryTTL    Duration `yaml:"history_ttl"` // время жизни кэша запросов истории
+	PubSub        string   `yaml:"pubsub" env:"PUBSUB"`
+}
+
+// WebSocket - отправка обновлений клиентам WebSocket API.
+type WebSocket struct {
+	QueueSize    int      `yaml:"queue_size"`                        // размер очереди отправки каждого клиента
+	WriteTimeout Duration `yaml:"write_timeout"`                     // дедлайн на запись одного сообщения
+	Policy       string   `yaml:"policy" env:"WS_POLICY"`            // drop-oldest, conflate или disconnect
+	PingInterval Duration `yaml:"ping_interval" env:"PING_INTERVAL"` // интервал пингов клиентам
+	PongWait     Duration `yaml:"pong_wait" env:"PONG_WAIT"`         // сколько ждём понга, прежде чем отключить клиента
+}
+
+// Leader - выбор лидера среди экземпляров.
+type Leader struct {
+	Lock     string   `yaml:"lock"`     // имя блокировки
+	Interval Duration `yaml:"interval"` // интервал попыток и продления
+}
+
+// Timeouts - таймауты HTTP серверов и остановки.
+type Timeouts struct {
+	Request    Duration `yaml:"request"`     // на запрос к БД из обработчика REST API
+	ReadHeader Duration `yaml:"read_header"` // на чтение заголовков запроса
+	Idle       Duration `yaml:"idle"`        // простоя keep-alive соединения REST API
+	Drain      Duration `yaml:"drain"`       // на запись уже полученных курсов после остановки опроса
+	Shutdown   Duration `yaml:"shutdown"`    // на всю остановку сервера
+}
+
+// Staleness - возраст курсов, после
+// которого /api/status сообщает об устаревании.
+type Staleness struct {
+	BTC  Duration `yaml:"btc" env:"BTC_STALE"`
+	Fiat Duration `yaml:"fiat" env:"FIAT_STALE"`
+}
+
+// Log - логирование.
+type Log struct {
+	File       string            `yaml:"file" env:"LOG_FILE"`
+	Format     string            `yaml:"format" env:"LOG_FORMAT"`
+	Level      string            `yaml:"level" env:"LOG_LEVEL"`
+	Levels     map[string]string `yaml:"levels" env:"LOG_LEVELS"` // уровни подсистем
+	MaxSizeMB  int               `yaml:"max_size_mb" env:"LOG_MAX_SIZE_MB"`
+	MaxAge     Duration          `yaml:"max_age" env:"LOG_MAX_AGE"`
+	MaxBackups int               `yaml:"max_backups" env:"LOG_MAX_BACKUPS"`
+	Retention  Duration          `yaml:"retention" env:"LOG_RETENTION"`
+}
+
+// Config - настройки сервера.
+type Config struct {
+	Listen    Listen    `yaml:"listen"`
+	Sources   Sources   `yaml:"sources"`
+	Aggregate Aggregate `yaml:"aggregate"`
+	Storage   Storage   `yaml:"storage"`
+	WebSocket WebSocket `yaml:"websocket"`
+	Leader    Leader    `yaml:"leader"`
+	Timeouts  Timeouts  `yaml:"timeouts"`
+	Staleness Staleness `yaml:"staleness"`
+	Log       Log       `yaml:"log"`
+}
+
+// Default возвращает настройки по умолчанию.
+func Default() Config {
+	return Config{
+		Listen: Listen{REST: ":8080", WebSocket: ":8090", Admin: ":9090"},
+		Sources: Sources{
+			BTC: Source{
+				Name:     "kucoin",
+				URL:      "https://api.kucoin.com/api/v1/market/stats?symbol=BTC-USDT",
+				Interval: Duration(10 * time.Second),
+				Timeout:  Duration(5 * time.Second),
+			},
+			Fiat: Source{
+				Name:     "cbr",
+				URL:      "http://www.cbr.ru/scripts/XML_daily.asp",
+				Interval: Duration(24 * time.Hour),
+				Timeout:  Duration(5 * time.Second),
+			},
+		},
+		Aggregate: Aggregate{Method: "median", MaxAge: Duration(time.Minute), MaxDeviation: 0.02},
+		Storage: Storage{
+			Retries:       60,
+			RetryInterval: Duration(time.Second),
+			SlowQuery:     Duration(500 * time.Millisecond),
+			HistoryTTL:    Duration(time.Minute),
+			PubSub:        "memory",
+		},
+		WebSocket: WebSocket{
+			QueueSize:    256,
+			WriteTimeout: Duration(10 * time.Second),
+			Policy:       "drop-oldest",
+			PingInterval: Duration(30 * time.Second),
+			PongWait:     Duration(60 * time.Second),
+		},
+		Leader: Leader{Lock: "xtest_ingest", Interval: Duration(5 * time.Second)},
+		Timeouts: Timeouts{
+			Request:    Duration(5 * time.Second),
+			ReadHeader: Duration(time.Minute),
+			Idle:       Duration(3 * time.Minute),
+			Drain:      Duration(10 * time.Second),
+			Shutdown:   Duration(30 * time.Second),
+		},
+		Staleness: Staleness{BTC: Duration(time.Minute), Fiat: Duration(96 * time.Hour)},
+		Log: Log{
+			Format:     "text",
+			Level:      "info",
+			MaxSizeMB:  100,
+			MaxAge:     Duration(24 * time.Hour),
+			MaxBackups: 7,
+		},
+	}
+}
+
+// Load собирает настройки: значения по умолчанию, затем файл
+// из флага -config (или переменной CONFIG_FILE), затем переменные
+// окружения, затем флаги из args. Возвращает ошибку,
+// если настройки не прошли проверку.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	file := fs.String("config", getenv("CONFIG_FILE"), "path to YAML config file")
+	var flags []func() error
+	walk(&cfg, func(path string, _ string, v reflect.Value) {
+		var raw *string
+		fs.Func(path, "override "+path, func(s string) error {
+			raw = &s
+			return nil
+		})
+		// флаги применяются после файла и окружения
+		flags = append(flags, func() error {
+			if raw == nil {
+				return nil
+			}
+			if err := set(v, *raw); err != nil {
+				return fmt.Errorf("flag -%s: %w", path, err)
+			}
+			return nil
+		})
+	})
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return cfg, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", *file, err)
+		}
+	}
+
+	var err error
+	walk(&cfg, func(path string, env string, v reflect.Value) {
+		if err != nil || env == "" {
+			return
+		}
+		if s := getenv(env); s != "" {
+			if e := set(v, s); e != nil {
+				err = fmt.Errorf("environment variable %s: %w", env, e)
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, apply := range flags {
+		if err := apply(); err != nil {
+			return cfg, err
+		}
+	}
+
+	// биржи без своего интервала и таймаута
+	// опрашиваются так же, как sources.btc
+	inherit := func(exchanges []Source, from Source) {
+		for i := range exchanges {
+			e := &exchanges[i]
+			if e.Interval == 0 {
+				e.Interval = from.Interval
+			}
+			if e.Timeout == 0 {
+				e.Timeout = from.Timeout
+			}
+		}
+	}
+	inherit(cfg.Sources.Exchanges, cfg.Sources.BTC)
+	for i := range cfg.Sources.Pairs {
+		inherit(cfg.Sources.Pairs[i].Exchanges, cfg.Sources.BTC)
+	}
+	// а источники курсов фиатных валют - как sources.fiat
+	inherit(cfg.Sources.References, cfg.Sources.Fiat)
+
+	return cfg, cfg.Validate()
+}
+
+// Validate проверяет настройки.
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Listen.REST != "", "listen.rest must be set")
+	check(c.Listen.WebSocket != "", "listen.websocket must be set")
+	check(c.Listen.Admin != "", "listen.admin must be set")
+
+	sources := []struct {
+		path string
+		Source
+	}{{"sources.btc", c.Sources.BTC}, {"sources.fiat", c.Sources.Fiat}}
+	for i, s := range c.Sources.Exchanges {
+		sources = append(sources, struct {
+			path string
+			Source
+		}{fmt.Sprintf("sources.exchanges[%d]", i), s})
+	}
+	for i, p := range c.Sources.Pairs {
+		for j, s := range p.Exchanges {
+			sources = append(sources, struct {
+				path string
+				Source
+			}{fmt.Sprintf("sources.pairs[%d].exchanges[%d]", i, j), s})
+		}
+	}
+	for i, s := range c.Sources.References {
+		sources = append(sources, struct {
+			path string
+			Source
+		}{fmt.Sprintf("sources.references[%d]", i), s})
+	}
+	for _, s := range sources {
+		u, err := url.Parse(s.URL)
+		if s.Stream {
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "ws" || u.Scheme == "wss") && u.Host != "",
+				"%s.url must be http(s) or ws(s) URL, got %q", s.path, s.URL)
+			_, ok := domain.StreamDecoders[s.Name]
+			check(ok, "%s.stream is supported only by %s, got %q", s.path, known(domain.StreamDecoders), s.Name)
+			check(s.Symbol != "", "%s.symbol must be set for stream", s.path)
+		} else {
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"%s.url must be http(s) URL, got %q", s.path, s.URL)
+		}
+		check(s.Name != "", "%s.name must be set", s.path)
+		check(s.Interval > 0, "%s.interval must be positive", s.path)
+		check(s.Timeout > 0, "%s.timeout must be positive", s.path)
+	}
+
+	_, ok := domain.TickerDecoders[c.Sources.BTC.Name]
+	check(ok, "sources.btc.name must be one of %s, got %q", known(domain.TickerDecoders), c.Sources.BTC.Name)
+	seen := map[string]bool{c.Sources.BTC.Name: true}
+	for i, s := range c.Sources.Exchanges {
+		_, ok := domain.TickerDecoders[s.Name]
+		check(ok, "sources.exchanges[%d].name must be one of %s, got %q", i, known(domain.TickerDecoders), s.Name)
+		check(!seen[s.Name], "sources.exchanges[%d].name %q is already used", i, s.Name)
+		seen[s.Name] = true
+	}
+	pairs := map[domain.Pair]bool{domain.BTCUSDT: true}
+	for i, p := range c.Sources.Pairs {
+		check(p.Pair != domain.Pair{}, "sources.pairs[%d].pair must be set", i)
+		check(!pairs[p.Pair], "sources.pairs[%d].pair %s is already used", i, p.Pair)
+		pairs[p.Pair] = true
+		check(len(p.Exchanges) > 0, "sources.pairs[%d].exchanges must be set", i)
+		seen := make(map[string]bool)
+		for j, s := range p.Exchanges {
+			_, ok := domain.TickerDecoders[s.Name]
+			check(ok, "sources.pairs[%d].exchanges[%d].name must be one of %s, got %q",
+				i, j, known(domain.TickerDecoders), s.Name)
+			check(!seen[s.Name], "sources.pairs[%d].exchanges[%d].name %q is already used", i, j, s.Name)
+			seen[s.Name] = true
+		}
+	}
+	_, ok = domain.FiatDecoders[c.Sources.Fiat.Name]
+	check(ok, "sources.fiat.name must be one of %s, got %q", known(domain.FiatDecoders), c.Sources.Fiat.Name)
+	seen = map[string]bool{c.Sources.Fiat.Name: true}
+	for i, s := range c.Sources.References {
+		_, ok := domain.FiatDecoders[s.Name]
+		check(ok, "sources.references[%d].name must be one of %s, got %q", i, known(domain.FiatDecoders), s.Name)
+		check(!seen[s.Name], "sources.references[%d].name %q is already used", i, s.Name)
+		seen[s.Name] = true
+	}
+
+	check(c.Aggregate.Method == "median" || c.Aggregate.Method == "vwap",
+		"aggregate.method must be median or vwap, got %q", c.Aggregate.Method)
+	check(c.Aggregate.MaxAge > 0, "aggregate.max_age must be positive")
+	check(c.Aggregate.MaxDeviation >= 0, "aggregate.max_deviation must not be negative")
+
+	check(c.Storage.URL != "", "storage.url (DB_URL) must be set")
+	check(c.Storage.Retries > 0, "storage.retries must be positive")
+	check(c.Storage.RetryInterval > 0, "storage.retry_interval must be positive")
+	check(c.Storage.SlowQuery >= 0, "storage.slow_query must not be negative")
+	check(c.Storage.HistoryTTL >= 0, "storage.history_ttl must not be negative")
+	check(c.Storage.PubSub == "memory" || c.Storage.PubSub == "postgres",
+		"storage.pubsub must be memory or postgres, got %q", c.Storage.PubSub)
+
+	check(c.WebSocket.QueueSize > 0, "websocket.queue_size must be positive")
+	check(c.WebSocket.WriteTimeout > 0, "websocket.write_timeout must be positive")
+	check(c.WebSocket.Policy == "drop-oldest" || c.WebSocket.Policy == "conflate" || c.WebSocket.Policy == "disconnect",
+		"websocket.policy must be drop-oldest, conflate or disconnect, got %q", c.WebSocket.Policy)
+	check(c.WebSocket.PingInterval > 0, "websocket.ping_interval must be positive")
+	check(c.WebSocket.PongWait > c.WebSocket.PingInterval, "websocket.pong_wait must be greater than websocket.ping_interval")
+
+	check(c.Leader.Lock != "", "leader.lock must be set")
+	check(c.Leader.Interval > 0, "leader.interval must be positive")
+
+	check(c.Timeouts.Request > 0, "timeouts.request must be positive")
+	check(c.Timeouts.ReadHeader > 0, "timeouts.read_header must be positive")
+	check(c.Timeouts.Idle > 0, "timeouts.idle must be positive")
+	check(c.Timeouts.Drain > 0, "timeouts.drain must be positive")
+	check(c.Timeouts.Shutdown > c.Timeouts.Drain, "timeouts.shutdown must be greater than timeouts.drain")
+
+	check(c.Staleness.BTC > 0, "staleness.btc must be positive")
+	check(c.Staleness.Fiat > 0, "staleness.fiat must be positive")
+
+	check(c.Log.File != "", "log.file (LOG_FILE) must be set")
+	check(c.Log.Format == "text" || c.Log.Format == "json",
+		"log.format must be text or json, got %q", c.Log.Format)
+	check(validLevel(c.Log.Level), "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	names := make([]string, 0, len(c.Log.Levels))
+	for name := range c.Log.Levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check(validLevel(c.Log.Levels[name]), "log.levels.%s must be debug, info, warn or error, got %q",
+			name, c.Log.Levels[name])
+	}
+	check(c.Log.MaxSizeMB >= 0, "log.max_size_mb must not be negative")
+	check(c.Log.MaxAge >= 0, "log.max_age must not be negative")
+	check(c.Log.MaxBackups >= 0, "log.max_backups must not be negative")
+	check(c.Log.Retention >= 0, "log.retention must not be negative")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// known перечисляет через запятую известные источники.
+func known[T any](m map[string]T) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validLevel - известен ли уровень логирования?
+func validLevel(s string) bool {
+	switch strings.ToLower(s) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// Print возвращает действующие настройки в формате YAML.
+// Пароль в адресе БД скрыт.
+func (c Config) Print() ([]byte, error) {
+	if u, err := url.Parse(c.Storage.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			c.Storage.URL = u.String()
+		}
+	}
+	return yaml.Marshal(c)
+}
+
+// walk вызывает fn для каждого конечного поля настроек
+// с путем из yaml тегов (log.max_age) и переменной окружения.
+// Списки пропускаются: их задают только в файле.
+func walk(cfg *Config, fn func(path, env string, v reflect.Value)) {
+	var rec func(prefix string, v reflect.Value)
+	rec = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			fv := v.Field(i)
+			switch f.Type.Kind() {
+			case reflect.Struct:
+				rec(path, fv)
+				continue
+			case reflect.Slice:
+				continue
+			}
+			fn(path, f.Tag.Get("env"), fv)
+		}
+	}
+	rec("", reflect.ValueOf(cfg).Elem())
+}
+
+// set устанавливает значение поля из строки.
+func set(v reflect.Value, s string) error {
+	switch p := v.Addr().Interface().(type) {
+	case *Duration:
+		return p.UnmarshalText([]byte(s))
+	case *string:
+		*p = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+	case *map[string]string:
+		// name=value,name=value
+		m := make(map[string]string)
+		for _, kv := range strings.Split(s, ",") {
+			if kv = strings.TrimSpace(kv); kv == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("bad pair %q, want name=value", kv)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+		*p = m
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+// Пакет feed предоставляет ленту обновлений курсов: нумерует
+// сообщения по каналам, хранит последние значения и буфер
+// недавних сообщений для досылки, раздает обновления подписчикам
+// (WebSocket и SSE API).
+package feed
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// типы сообщений для клиентов
+const (
+	TypeSnapshot = "snapshot" // последнее известное значение канала
+	TypeUpdate   = "update"   // новое значение канала
+	TypeReplay   = "replay"   // пропущенное клиентом значение канала
+	TypeGap      = "gap"      // пропущенные значения недоступны, следом идет снимок
+)
+
+// GapTooLarge - текст ошибки, если пропущенных
+// клиентом сообщений уже нет в буфере.
+const GapTooLarge = "gap too large, resnapshot"
+
+// DefaultReplaySize - сколько последних сообщений
+// канала хранится для досылки по умолчанию.
+const DefaultReplaySize = 1024
+
+// Envelope - формат сообщений для клиентов.
+type Envelope struct {
+	Type  string          `json:"type"`
+	Label string          `json:"label"`
+	Seq   uint64          `json:"seq"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Message - сообщение канала.
+type Message struct {
+	Label string          // канал сообщения
+	Seq   uint64          // порядковый номер в канале
+	Data  json.RawMessage // данные сообщения
+	raw   []byte          // сообщение не в формате {"label": ..., "data": ...}
+}
+
+// Parse разбирает обновление в формате {"label": ..., "data": ...},
+// номер seq, присвоенный публикующим экземпляром, сохраняется.
+// Остальные сообщения не принадлежат ни одному каналу
+// и отправляются клиентам как есть.
+func Parse(b []byte) Message {
+	var e Envelope
+	if err := json.Unmarshal(b, &e); err != nil || e.Label == "" {
+		return Message{raw: b}
+	}
+	return Message{Label: e.Label, Seq: e.Seq, Data: e.Data}
+}
+
+// Encode упаковывает сообщение в конверт с переданным типом.
+func (m Message) Encode(typ string) []byte {
+	if m.Label == "" {
+		return m.raw
+	}
+	b, err := json.Marshal(Envelope{Type: typ, Label: m.Label, Seq: m.Seq, Data: m.Data})
+	if err != nil {
+		// сюда попадаем только если Data невалидный JSON,
+		// что невозможно после json.Unmarshal
+		return m.Data
+	}
+	return b
+}
+
+// Gap возвращает сообщение о том, что пропущенные
+// сообщения канала недоступны. seq - текущий номер канала.
+func Gap(label string, seq uint64) []byte {
+	b, _ := json.Marshal(Envelope{Type: TypeGap, Label: label, Seq: seq, Error: GapTooLarge})
+	return b
+}
+
+// Sequencer нумерует сообщения каналов на публикующем экземпляре
+// (лидере), чтобы все экземпляры раздавали клиентам одинаковые
+// номера. Номера продолжают последние известные ленте, поэтому
+// новый лидер не начинает каналы заново.
+type Sequencer struct {
+	mu   sync.Mutex
+	feed *Feed
+	seq  map[string]uint64
+}
+
+// Sequencer возвращает нумератор, продолжающий номера ленты.
+func (f *Feed) Sequencer() *Sequencer {
+	return &Sequencer{feed: f, seq: make(map[string]uint64)}
+}
+
+// Stamp присваивает сообщению следующий номер в канале.
+// Сообщения не в формате {"label": ..., "data": ...}
+// возвращаются как есть.
+func (s *Sequencer) Stamp(b []byte) []byte {
+	m := Parse(b)
+	if m.Label == "" {
+		return b
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.seq[m.Label]
+	if !ok {
+		s.feed.Do(func(st *State) {
+			last, _ := st.LastOf(m.Label)
+			seq = last.Seq
+		})
+	}
+	seq++
+	s.seq[m.Label] = seq
+	m.Seq = seq
+	return m.Encode(TypeUpdate)
+}
+
+// history - ограниченный буфер последних
+// сообщений канала для досылки.
+type history struct {
+	size int
+	msgs []Message
+}
+
+// add добавляет сообщение, вытесняя самое старое.
+func (h *history) add(m Message) {
+	if len(h.msgs) >= h.size {
+		h.msgs = h.msgs[1:]
+	}
+	h.msgs = append(h.msgs, m)
+}
+
+// since возвращает сообщения с номером больше seq.
+// Возвращает false, если часть из них уже вытеснена
+// из буфера или seq из будущего (например, сервер перезапущен).
+func (h *history) since(seq uint64) ([]Message, bool) {
+	if len(h.msgs) == 0 {
+		return nil, seq == 0
+	}
+	last := h.msgs[len(h.msgs)-1].Seq
+	if seq > last || seq+1 < h.msgs[0].Seq {
+		return nil, false
+	}
+	return h.msgs[len(h.msgs)-int(last-seq):], true
+}
+
+// State - состояние каналов ленты. Доступно только
+// внутри Feed.Do и Feed.Subscribe, под семафором ленты.
+type State struct {
+	last map[string]Message
+	hist map[string]*history
+}
+
+// Last возвращает последние сообщения всех каналов.
+func (s *State) Last() []Message {
+	msgs := make([]Message, 0, len(s.last))
+	for _, m := range s.last {
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// LastOf возвращает последнее сообщение канала.
+func (s *State) LastOf(label string) (Message, bool) {
+	m, ok := s.last[label]
+	return m, ok
+}
+
+// Since возвращает сообщения канала с номером больше seq.
+// Возвращает false, если канал неизвестен или пропущенных
+// сообщений уже нет в буфере.
+func (s *State) Since(label string, seq uint64) ([]Message, bool) {
+	h, ok := s.hist[label]
+	if !ok {
+		return nil, false
+	}
+	return h.since(seq)
+}
+
+// subscriber - подписчик ленты.
+type subscriber struct {
+	fn func(Message)
+}
+
+// Feed - лента обновлений.
+type Feed struct {
+	mu    sync.Mutex
+	size  int
+	state State
+	subs  map[*subscriber]struct{}
+}
+
+// New возвращает новую ленту, которая читает обновления
+// из канала upd, пока он не закрыт. size - сколько последних
+// сообщений каждого канала хранится для досылки.
+func New(upd <-chan []byte, size int) *Feed {
+	if size <= 0 {
+		size = DefaultReplaySize
+	}
+	f := Feed{
+		size: size,
+		state: State{
+			last: make(map[string]Message),
+			hist: make(map[string]*history),
+		},
+		subs: make(map[*subscriber]struct{}),
+	}
+	go func() {
+		for b := range upd {
+			f.Publish(b)
+		}
+	}()
+	return &f
+}
+
+// Publish запоминает сообщение и раздает его подписчикам.
+// Номер берется из сообщения (его присваивает Sequencer лидера),
+// а если его нет - следующий в канале. Если номера идут не подряд
+// (сменился лидер или сообщение потерялось), буфер досылки канала
+// начинается заново, и отстающие клиенты получат разрыв.
+func (f *Feed) Publish(b []byte) {
+	m := Parse(b)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if m.Label != "" {
+		next := f.state.last[m.Label].Seq + 1
+		if m.Seq == 0 {
+			m.Seq = next
+		}
+		f.state.last[m.Label] = m
+		h, ok := f.state.hist[m.Label]
+		if !ok || m.Seq != next {
+			h = &history{size: f.size}
+			f.state.hist[m.Label] = h
+		}
+		h.add(m)
+	}
+	for s := range f.subs {
+		s.fn(m)
+	}
+}
+
+// Subscribe вызывает init с текущим состоянием и подписывает fn
+// на новые сообщения. Оба вызова происходят под семафором ленты,
+// поэтому подписчик не пропустит сообщений между снимком
+// и обновлениями. fn не должна блокироваться.
+// Возвращает функцию отписки.
+func (f *Feed) Subscribe(init func(*State), fn func(Message)) (unsubscribe func()) {
+	s := &subscriber{fn: fn}
+
+	f.mu.Lock()
+	if init != nil {
+		init(&f.state)
+	}
+	f.subs[s] = struct{}{}
+	f.mu.Unlock()
+
+	return func() {
+		f.mu.Lock()
+		delete(f.subs, s)
+		f.mu.Unlock()
+	}
+}
+
+// Do вызывает fn с текущим состоянием под семафором ленты.
+// fn не должна блокироваться.
+func (f *Feed) Do(fn func(*State)) {
+	f.mu.Lock()
+	fn(&f.state)
+	f.mu.Unlock()
+}
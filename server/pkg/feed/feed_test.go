@@ -0,0 +1,127 @@
+package feed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_history_since(t *testing.T) {
+	h := history{size: 3}
+	for seq := uint64(1); seq <= 5; seq++ {
+		h.add(Message{Label: "BTC/USDT", Seq: seq})
+	}
+
+	tests := []struct {
+		name   string
+		seq    uint64
+		want   []uint64
+		wantOk bool
+	}{
+		{name: "up_to_date", seq: 5, want: []uint64{}, wantOk: true},
+		{name: "in_buffer", seq: 3, want: []uint64{4, 5}, wantOk: true},
+		{name: "whole_buffer", seq: 2, want: []uint64{3, 4, 5}, wantOk: true},
+		{name: "gap_too_large", seq: 1, wantOk: false},
+		{name: "from_future", seq: 6, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, ok := h.since(tt.seq)
+			if ok != tt.wantOk {
+				t.Fatalf("since(%d) = %t, want %t", tt.seq, ok, tt.wantOk)
+			}
+			got := []uint64{}
+			for i := range msgs {
+				got = append(got, msgs[i].Seq)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("since(%d) = %v, want %v", tt.seq, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeed_Publish(t *testing.T) {
+	f := New(make(chan []byte), 2)
+
+	f.Publish([]byte(`{"label":"BTC/USDT","data":{"value":1}}`))
+	f.Publish([]byte(`{"label":"BTC/*","data":{"RUB":1}}`))
+
+	var snapshot []Message
+	var got []Message
+	unsubscribe := f.Subscribe(func(s *State) {
+		snapshot = s.Last()
+	}, func(m Message) {
+		got = append(got, m)
+	})
+
+	f.Publish([]byte(`{"label":"BTC/USDT","data":{"value":2}}`))
+	f.Publish([]byte(`not json`))
+	unsubscribe()
+	f.Publish([]byte(`{"label":"BTC/USDT","data":{"value":3}}`))
+
+	if len(snapshot) != 2 {
+		t.Errorf("Subscribe() snapshot len = %d, want %d", len(snapshot), 2)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Subscribe() got len = %d, want %d", len(got), 2)
+	}
+	if got[0].Label != "BTC/USDT" || got[0].Seq != 2 {
+		t.Errorf("Publish() = %q seq %d, want %q seq %d", got[0].Label, got[0].Seq, "BTC/USDT", 2)
+	}
+	if string(got[1].Encode(TypeUpdate)) != "not json" {
+		t.Errorf("Encode() = %q, want %q", got[1].Encode(TypeUpdate), "not json")
+	}
+
+	want := `{"type":"replay","label":"BTC/USDT","seq":3,"data":{"value":3}}`
+	f.Do(func(s *State) {
+		msgs, ok := s.Since("BTC/USDT", 2)
+		if !ok || len(msgs) != 1 {
+			t.Fatalf("Since() = %v %t, want 1 message", msgs, ok)
+		}
+		if got := string(msgs[0].Encode(TypeReplay)); got != want {
+			t.Errorf("Encode() = %s, want %s", got, want)
+		}
+		if _, ok := s.Since("BTC/USDT", 0); ok {
+			t.Errorf("Since() = %t, want %t", ok, false)
+		}
+	})
+}
+
+func TestSequencer_Stamp(t *testing.T) {
+	leader := New(make(chan []byte), 2)
+	replica := New(make(chan []byte), 2)
+
+	// лидер продолжает номера, уже известные его ленте
+	leader.Publish([]byte(`{"label":"BTC/USDT","data":{"value":1}}`))
+	seq := leader.Sequencer()
+	for _, b := range []string{
+		`{"label":"BTC/USDT","data":{"value":2}}`,
+		`{"label":"BTC/USDT","data":{"value":3}}`,
+		`not json`,
+	} {
+		stamped := seq.Stamp([]byte(b))
+		leader.Publish(stamped)
+		replica.Publish(stamped)
+	}
+
+	want := `{"type":"update","label":"BTC/USDT","seq":3,"data":{"value":3}}`
+	for name, f := range map[string]*Feed{"leader": leader, "replica": replica} {
+		f.Do(func(s *State) {
+			last, _ := s.LastOf("BTC/USDT")
+			if got := string(last.Encode(TypeUpdate)); got != want {
+				t.Errorf("%s LastOf() = %s, want %s", name, got, want)
+			}
+		})
+	}
+
+	// реплика не видела первое сообщение лидера
+	replica.Do(func(s *State) {
+		if _, ok := s.Since("BTC/USDT", 0); ok {
+			t.Errorf("replica Since(0) = %t, want %t", ok, false)
+		}
+		if msgs, ok := s.Since("BTC/USDT", 1); !ok || len(msgs) != 2 {
+			t.Errorf("replica Since(1) = %v %t, want 2 messages", msgs, ok)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Пакет leader реализует выбор лидера среди экземпляров
+// сервера: только лидер опрашивает внешние источники,
+// остальные обслуживают клиентов и ждут своей очереди.
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Lock - контракт распределенной блокировки.
+type Lock interface {
+	// Пытается захватить блокировку, не дожидаясь ее освобождения.
+	TryAcquire(ctx context.Context) (bool, error)
+	// Продлевает блокировку, ошибка означает, что она потеряна.
+	Renew(ctx context.Context) error
+	// Освобождает блокировку.
+	Release(ctx context.Context) error
+}
+
+// Run пытается захватить блокировку с интервалом interval.
+// Захватив ее, вызывает lead с контекстом, который отменяется
+// при потере лидерства или отмене ctx, и продлевает блокировку
+// с тем же интервалом. После потери лидерства дожидается
+// завершения lead и снова пытается стать лидером.
+// Возвращает управление после отмены ctx и завершения lead.
+func Run(ctx context.Context, lock Lock, interval time.Duration, logger *slog.Logger, lead func(context.Context)) {
+	for {
+		ok, err := try(ctx, lock, interval)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("acquire leadership", "err", err)
+		}
+		if ok {
+			logger.Info("became leader")
+			term(ctx, lock, interval, logger, lead)
+			if ctx.Err() == nil {
+				logger.Warn("lost leadership")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// term - срок лидерства: выполняет lead, пока удается
+// продлевать блокировку и не отменен ctx.
+func term(ctx context.Context, lock Lock, interval time.Duration, logger *slog.Logger, lead func(context.Context)) {
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(lctx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			<-done
+			release(lock, interval, logger)
+			return
+		case <-done:
+			// lead завершился сам, уступаем лидерство
+			release(lock, interval, logger)
+			return
+		case <-ticker.C:
+			c, cancelRenew := context.WithTimeout(ctx, interval)
+			err := lock.Renew(c)
+			cancelRenew()
+			if err != nil {
+				logger.Error("renew leadership", "err", err)
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+// try пытается захватить блокировку с таймаутом.
+func try(ctx context.Context, lock Lock, timeout time.Duration) (bool, error) {
+	c, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return lock.TryAcquire(c)
+}
+
+// release освобождает блокировку с таймаутом.
+func release(lock Lock, timeout time.Duration, logger *slog.Logger) {
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := lock.Release(c); err != nil {
+		logger.Warn("release leadership", "err", err)
+	}
+}
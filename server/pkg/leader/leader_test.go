@@ -0,0 +1,117 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memLock - блокировка в памяти, общая для нескольких экземпляров.
+type memLock struct {
+	mu     *sync.Mutex
+	holder *string
+	name   string
+	lost   bool // имитирует потерю соединения
+}
+
+func (l *memLock) TryAcquire(context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if *l.holder == "" {
+		*l.holder = l.name
+		l.lost = false
+	}
+	return *l.holder == l.name, nil
+}
+
+func (l *memLock) Renew(context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost {
+		*l.holder = ""
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func (l *memLock) Release(context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if *l.holder == l.name {
+		*l.holder = ""
+	}
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	var holder string
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	leaders := make(chan string, 10)
+	lead := func(name string) func(context.Context) {
+		return func(ctx context.Context) {
+			leaders <- name
+			<-ctx.Done()
+		}
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	lockA := &memLock{mu: &mu, holder: &holder, name: "A"}
+	lockB := &memLock{mu: &mu, holder: &holder, name: "B"}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		Run(ctxA, lockA, 5*time.Millisecond, logger, lead("A"))
+		wg.Done()
+	}()
+	time.Sleep(20 * time.Millisecond) // A успевает стать лидером
+	go func() {
+		Run(ctxB, lockB, 5*time.Millisecond, logger, lead("B"))
+		wg.Done()
+	}()
+
+	next := func() string {
+		select {
+		case name := <-leaders:
+			return name
+		case <-time.After(time.Second):
+			t.Fatal("Run() = no leader elected")
+		}
+		return ""
+	}
+
+	if got := next(); got != "A" {
+		t.Fatalf("Run() leader = %q, want %q", got, "A")
+	}
+
+	// A останавливается - лидером становится B
+	cancelA()
+	if got := next(); got != "B" {
+		t.Fatalf("Run() leader = %q, want %q", got, "B")
+	}
+
+	// B теряет соединение - лидерство снова свободно
+	// и B захватывает его повторно
+	mu.Lock()
+	lockB.lost = true
+	mu.Unlock()
+	if got := next(); got != "B" {
+		t.Fatalf("Run() leader = %q, want %q", got, "B")
+	}
+
+	cancelB()
+	wg.Wait()
+
+	if holder != "" {
+		t.Errorf("Run() holder after shutdown = %q, want none", holder)
+	}
+}
@@ -0,0 +1,85 @@
+// Пакет postgres представляет реализацию распределенной
+// блокировки на основе advisory lock.
+package postgres
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"xtestserver/pkg/leader"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var _ leader.Lock = (*Lock)(nil)
+
+var ErrNotHeld = errors.New("advisory lock is not held")
+
+// Lock - сессионная advisory-блокировка Postgres. Блокировка
+// держится, пока живо соединение, поэтому при падении лидера
+// Postgres освобождает ее сам и лидером становится другой экземпляр.
+// Не потокобезопасна, предназначена для leader.Run.
+type Lock struct {
+	cfg  *pgx.ConnConfig
+	key  int64
+	conn *pgx.Conn
+}
+
+// New возвращает блокировку с ключом, вычисленным из имени.
+// Экземпляры с одинаковым именем конкурируют за одну блокировку.
+func New(connString, name string) (*Lock, error) {
+	cfg, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return &Lock{cfg: cfg, key: int64(h.Sum64())}, nil
+}
+
+// TryAcquire пытается захватить блокировку на отдельном соединении.
+func (l *Lock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn == nil {
+		conn, err := pgx.ConnectConfig(ctx, l.cfg)
+		if err != nil {
+			return false, err
+		}
+		l.conn = conn
+	}
+	var ok bool
+	err := l.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1);`, l.key).Scan(&ok)
+	if err != nil {
+		l.close()
+		return false, err
+	}
+	return ok, nil
+}
+
+// Renew проверяет, что соединение, а значит и блокировка, живо.
+func (l *Lock) Renew(ctx context.Context) error {
+	if l.conn == nil {
+		return ErrNotHeld
+	}
+	if err := l.conn.Ping(ctx); err != nil {
+		l.close()
+		return err
+	}
+	return nil
+}
+
+// Release освобождает блокировку и закрывает соединение.
+func (l *Lock) Release(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1);`, l.key)
+	l.close()
+	return err
+}
+
+// close закрывает соединение; сессионная блокировка
+// освобождается вместе с ним.
+func (l *Lock) close() {
+	_ = l.conn.Close(context.Background())
+	l.conn = nil
+}
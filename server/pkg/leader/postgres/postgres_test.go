@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+const tdbEnv = "TEST_DB"
+
+func TestLock(t *testing.T) {
+	connstr, ok := os.LookupEnv(tdbEnv)
+	if !ok {
+		t.Skipf("environment variable %q is not set, skipped...", tdbEnv)
+	}
+
+	// свое имя, чтобы не мешать запущенным серверам
+	name := fmt.Sprintf("xtest_lock_test_%d", time.Now().UnixNano())
+	a, err := New(connstr, name)
+	if err != nil {
+		t.Fatalf("New() = err: %v", err)
+	}
+	b, err := New(connstr, name)
+	if err != nil {
+		t.Fatalf("New() = err: %v", err)
+	}
+	ctx := context.Background()
+	defer a.Release(ctx)
+	defer b.Release(ctx)
+
+	if err = b.Renew(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Renew() before acquire = %v, want %v", err, ErrNotHeld)
+	}
+
+	ok, err = a.TryAcquire(ctx)
+	if err != nil || !ok {
+		t.Fatalf("TryAcquire() = %v, %v, want true, nil", ok, err)
+	}
+	if err = a.Renew(ctx); err != nil {
+		t.Errorf("Renew() = err: %v", err)
+	}
+
+	// второй экземпляр не получает занятую блокировку
+	ok, err = b.TryAcquire(ctx)
+	if err != nil || ok {
+		t.Fatalf("second TryAcquire() = %v, %v, want false, nil", ok, err)
+	}
+
+	if err = a.Release(ctx); err != nil {
+		t.Errorf("Release() = err: %v", err)
+	}
+	if err = a.Renew(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Renew() after release = %v, want %v", err, ErrNotHeld)
+	}
+
+	// после освобождения блокировку получает второй экземпляр
+	ok, err = b.TryAcquire(ctx)
+	if err != nil || !ok {
+		t.Fatalf("TryAcquire() after release = %v, %v, want true, nil", ok, err)
+	}
+	if err = b.Release(ctx); err != nil {
+		t.Errorf("Release() = err: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupLayout - формат времени в имени архивного файла:
+// xserver.log -> xserver.log.20220724T104348.000
+const backupLayout = "20060102T150405.000"
+
+// Rotation - настройки ротации файла лога.
+// Нулевые значения отключают соответствующее правило.
+type Rotation struct {
+	MaxSize    int64         // размер файла в байтах, после которого он ротируется
+	MaxAge     time.Duration // возраст файла, после которого он ротируется
+	MaxBackups int           // сколько архивных файлов хранить
+	Retention  time.Duration // архивные файлы старше удаляются
+}
+
+// File - файл лога с ротацией по размеру и возрасту.
+// Безопасен для одновременной записи.
+type File struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.Mutex
+	cfg    Rotation
+	f      *os.File
+	size   int64
+	opened time.Time // время создания файла, от него считается MaxAge
+}
+
+// OpenFile открывает файл лога для дозаписи.
+func OpenFile(path string, cfg Rotation) (*File, error) {
+	f := File{path: path, cfg: cfg, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// open открывает файл по пути path. Вызывается под семафором.
+// Возраст непустого файла отсчитывается от времени его изменения
+// (файл мог остаться от прошлого запуска), пустого - от открытия.
+// Прежний файл закрывается, только если новый открылся.
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	opened := f.now()
+	if info.Size() > 0 {
+		opened = info.ModTime()
+	}
+	old := f.f
+	f.f, f.size, f.opened = file, info.Size(), opened
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Write дописывает b в файл, предварительно
+// ротируя его, если он слишком большой или старый.
+// Если ротация не удалась, b дописывается в прежний
+// файл, а Write возвращает ошибку ротации.
+func (f *File) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return 0, os.ErrClosed
+	}
+	var rerr error
+	if f.due(int64(len(b))) {
+		if err := f.rotate(); err != nil {
+			rerr = fmt.Errorf("rotate log: %w", err)
+		}
+	}
+	n, err := f.f.Write(b)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rerr
+}
+
+// due - пора ли ротировать файл перед записью n байт?
+func (f *File) due(n int64) bool {
+	if f.size == 0 {
+		return false // пустой файл ротировать незачем
+	}
+	if f.cfg.MaxSize > 0 && f.size+n > f.cfg.MaxSize {
+		return true
+	}
+	return f.cfg.MaxAge > 0 && f.now().Sub(f.opened) >= f.cfg.MaxAge
+}
+
+// Rotate переименовывает текущий файл в архивный,
+// открывает новый и удаляет лишние архивные файлы.
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// rotate - Rotate под семафором. Текущий файл остается
+// открытым, пока не откроется новый, поэтому при ошибке
+// запись продолжается в прежний файл.
+func (f *File) rotate() error {
+	backup := f.path + "." + f.now().UTC().Format(backupLayout)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		// возвращаем прежнему файлу его имя,
+		// чтобы следующая ротация началась заново
+		return errors.Join(err, os.Rename(backup, f.path))
+	}
+	return f.prune()
+}
+
+// Reopen заново открывает файл по тому же пути,
+// например, если его переместил внешний logrotate.
+// Если открыть не удалось, запись продолжается в прежний файл.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+// SetRotation меняет настройки ротации.
+func (f *File) SetRotation(cfg Rotation) {
+	f.mu.Lock()
+	f.cfg = cfg
+	f.mu.Unlock()
+}
+
+// Close закрывает файл.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+// prune удаляет архивные файлы сверх MaxBackups и старше Retention.
+func (f *File) prune() error {
+	if f.cfg.MaxBackups <= 0 && f.cfg.Retention <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	now := f.now()
+	for i, b := range backups { // от новых к старым
+		if (f.cfg.MaxBackups > 0 && i >= f.cfg.MaxBackups) ||
+			(f.cfg.Retention > 0 && now.Sub(b.t) > f.cfg.Retention) {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backup - архивный файл лога.
+type backup struct {
+	path string
+	t    time.Time // время ротации
+}
+
+// backups возвращает архивные файлы от новых к старым.
+func (f *File) backups() ([]backup, error) {
+	dir, base := filepath.Split(f.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var list []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		t, err := time.Parse(backupLayout, strings.TrimPrefix(name, base+"."))
+		if err != nil {
+			continue // чужой файл
+		}
+		list = append(list, backup{path: filepath.Join(dir, name), t: t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].t.After(list[j].t) })
+	return list, nil
+}
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock - управляемое время для ротации.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func openTestFile(t *testing.T, cfg Rotation) (*File, *clock, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "xserver.log")
+	c := &clock{t: time.Date(2022, 7, 24, 10, 0, 0, 0, time.UTC)}
+	f, err := OpenFile(path, cfg)
+	if err != nil {
+		t.Fatalf("OpenFile() = err: %v", err)
+	}
+	f.now = c.now
+	f.opened = c.now()
+	t.Cleanup(func() { _ = f.Close() })
+	return f, c, path
+}
+
+// files возвращает имена файлов каталога лога.
+func files(t *testing.T, path string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func write(t *testing.T, f *File, s string) {
+	t.Helper()
+	if _, err := f.Write([]byte(s)); err != nil {
+		t.Fatalf("Write() = err: %v", err)
+	}
+}
+
+func TestFile_rotation(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		f, c, path := openTestFile(t, Rotation{MaxSize: 10})
+		write(t, f, "1234\n")
+		write(t, f, "1234\n") // ровно 10 байт
+		c.t = c.t.Add(time.Second)
+		write(t, f, "next\n")
+
+		want := []string{"xserver.log", "xserver.log.20220724T100001.000"}
+		if got := files(t, path); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+		if b, _ := os.ReadFile(path); string(b) != "next\n" {
+			t.Errorf("current file = %q, want %q", b, "next\n")
+		}
+	})
+
+	t.Run("age", func(t *testing.T) {
+		f, c, path := openTestFile(t, Rotation{MaxAge: time.Hour})
+		write(t, f, "old\n")
+		c.t = c.t.Add(59 * time.Minute)
+		write(t, f, "old\n")
+		if got := files(t, path); len(got) != 1 {
+			t.Fatalf("files = %v, want no rotation", got)
+		}
+		c.t = c.t.Add(time.Minute)
+		write(t, f, "new\n")
+		if got := files(t, path); len(got) != 2 {
+			t.Errorf("files = %v, want rotation", got)
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		f, c, path := openTestFile(t, Rotation{MaxBackups: 2, Retention: 3 * time.Hour})
+		for i := 0; i < 4; i++ {
+			write(t, f, "line\n")
+			if err := f.Rotate(); err != nil {
+				t.Fatalf("Rotate() = err: %v", err)
+			}
+			c.t = c.t.Add(time.Hour)
+		}
+		// из четырех архивов остаются два последних
+		want := []string{"xserver.log", "xserver.log.20220724T120000.000", "xserver.log.20220724T130000.000"}
+		if got := files(t, path); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+
+		// через 3 часа после ротации архив устаревает
+		c.t = c.t.Add(2 * time.Hour)
+		write(t, f, "line\n")
+		if err := f.Rotate(); err != nil {
+			t.Fatalf("Rotate() = err: %v", err)
+		}
+		want = []string{"xserver.log", "xserver.log.20220724T130000.000", "xserver.log.20220724T160000.000"}
+		if got := files(t, path); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("files = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestFile_rotateFailed(t *testing.T) {
+	f, c, path := openTestFile(t, Rotation{MaxSize: 5})
+	write(t, f, "1234\n")
+
+	// архивное имя занято непустым каталогом, переименовать не выйдет
+	backup := path + "." + c.now().Format(backupLayout)
+	if err := os.MkdirAll(filepath.Join(backup, "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("kept\n")); err == nil {
+		t.Errorf("Write() = nil, want rotate error")
+	}
+	// запись продолжается в прежний файл, ротация повторяется
+	if err := os.RemoveAll(backup); err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, "next\n")
+
+	if b, _ := os.ReadFile(backup); string(b) != "1234\nkept\n" {
+		t.Errorf("backup file = %q, want %q", b, "1234\nkept\n")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "next\n" {
+		t.Errorf("current file = %q, want %q", b, "next\n")
+	}
+}
+
+func TestOpenFile_age(t *testing.T) {
+	// файл остался от прошлого запуска
+	path := filepath.Join(t.TempDir(), "xserver.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenFile(path, Rotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenFile() = err: %v", err)
+	}
+	defer f.Close()
+	write(t, f, "new\n")
+
+	if got := files(t, path); len(got) != 2 {
+		t.Errorf("files = %v, want rotation", got)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "new\n" {
+		t.Errorf("current file = %q, want %q", b, "new\n")
+	}
+}
+
+func TestFile_Reopen(t *testing.T) {
+	f, _, path := openTestFile(t, Rotation{})
+	write(t, f, "before\n")
+
+	// внешний logrotate переместил файл
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("Reopen() = err: %v", err)
+	}
+	write(t, f, "after\n")
+
+	if b, _ := os.ReadFile(path); string(b) != "after\n" {
+		t.Errorf("reopened file = %q, want %q", b, "after\n")
+	}
+	if b, _ := os.ReadFile(path + ".1"); string(b) != "before\n" {
+		t.Errorf("moved file = %q, want %q", b, "before\n")
+	}
+}
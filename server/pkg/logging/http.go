@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RequestIDKey - атрибут с идентификатором запроса.
+const RequestIDKey = "request_id"
+
+// RequestIDHeader - заголовок с идентификатором запроса.
+// Если клиент или прокси его передал, идентификатор
+// сохраняется, иначе генерируется новый.
+const RequestIDHeader = "X-Request-ID"
+
+type ctxKey struct{}
+
+// WithRequestID возвращает контекст с идентификатором запроса.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestID возвращает идентификатор запроса из контекста.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// newRequestID возвращает случайный идентификатор.
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Middleware присваивает запросу идентификатор и после
+// его обработки логирует метод, путь, статус и длительность.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			ctx := WithRequestID(r.Context(), id)
+
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			logger.InfoContext(ctx, "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"query", r.URL.RawQuery,
+				"remote", r.RemoteAddr,
+				"status", rec.status(),
+				"bytes", rec.bytes,
+				"duration", time.Since(start),
+			)
+		})
+	}
+}
+
+// recorder запоминает статус и размер ответа. Сохраняет
+// http.Flusher и http.Hijacker, они нужны SSE и WebSocket.
+type recorder struct {
+	http.ResponseWriter
+	code     int
+	bytes    int
+	hijacked bool
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *recorder) Flush() {
+	if fl, ok := rec.ResponseWriter.(http.Flusher); ok {
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		fl.Flush()
+	}
+}
+
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	rec.hijacked = true
+	return hj.Hijack()
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// status возвращает статус ответа.
+func (rec *recorder) status() int {
+	switch {
+	case rec.hijacked:
+		return http.StatusSwitchingProtocols
+	case rec.code == 0:
+		return http.StatusOK
+	}
+	return rec.code
+}
@@ -0,0 +1,171 @@
+// Пакет logging настраивает структурированное логирование:
+// формат вывода, уровни подсистем, идентификатор запроса
+// и журнал HTTP запросов.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// SubsystemKey - атрибут с именем подсистемы.
+const SubsystemKey = "subsystem"
+
+// форматы вывода
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config - настройки логирования.
+type Config struct {
+	Format string                // FormatText или FormatJSON
+	Level  slog.Level            // уровень подсистем по умолчанию
+	Levels map[string]slog.Level // уровни отдельных подсистем
+}
+
+// Logging раздает логгеры подсистем, пишущие в один поток.
+type Logging struct {
+	h slog.Handler // пропускает все уровни, фильтруют логгеры подсистем
+
+	mu     sync.Mutex
+	def    slog.Level
+	levels map[string]*slog.LevelVar
+}
+
+// New возвращает логирование в w с настройками cfg.
+func New(w io.Writer, cfg Config) (*Logging, error) {
+	opts := &slog.HandlerOptions{Level: slog.Level(-8)} // ниже Debug
+	var h slog.Handler
+	switch cfg.Format {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	l := Logging{
+		h:      contextHandler{h},
+		levels: make(map[string]*slog.LevelVar),
+	}
+	l.SetLevels(cfg.Level, cfg.Levels)
+	return &l, nil
+}
+
+// Logger возвращает логгер подсистемы name.
+func (l *Logging) Logger(name string) *slog.Logger {
+	return slog.New(levelHandler{h: l.h, level: l.level(name)}).With(SubsystemKey, name)
+}
+
+// SetLevels меняет уровни уже выданных логгеров: подсистемы
+// из levels получают свой уровень, остальные - def.
+func (l *Logging) SetLevels(def slog.Level, levels map[string]slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def = def
+	for name, v := range l.levels {
+		if lvl, ok := levels[name]; ok {
+			v.Set(lvl)
+		} else {
+			v.Set(def)
+		}
+	}
+	for name, lvl := range levels {
+		if _, ok := l.levels[name]; !ok {
+			l.levels[name] = new(slog.LevelVar)
+			l.levels[name].Set(lvl)
+		}
+	}
+}
+
+// level возвращает уровень подсистемы name.
+func (l *Logging) level(name string) *slog.LevelVar {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.levels[name]
+	if !ok {
+		v = new(slog.LevelVar)
+		v.Set(l.def)
+		l.levels[name] = v
+	}
+	return v
+}
+
+// ParseLevel разбирает уровень: debug, info, warn или error.
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(s))
+	return lvl, err
+}
+
+// ParseLevels разбирает уровни подсистем
+// в формате "rest=debug,websocket=warn".
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, lvl, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad subsystem level %q, want name=level", kv)
+		}
+		var err error
+		if levels[strings.TrimSpace(name)], err = ParseLevel(strings.TrimSpace(lvl)); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+// levelHandler пропускает записи не ниже уровня подсистемы.
+type levelHandler struct {
+	h     slog.Handler
+	level slog.Leveler
+}
+
+func (h levelHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.h.Handle(ctx, r)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h: h.h.WithAttrs(attrs), level: h.level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h: h.h.WithGroup(name), level: h.level}
+}
+
+// contextHandler добавляет к записи
+// идентификатор запроса из контекста.
+type contextHandler struct {
+	h slog.Handler
+}
+
+func (h contextHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return h.h.Enabled(ctx, lvl)
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	return h.h.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.h.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.h.WithGroup(name)}
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// records разбирает JSON записи лога.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var recs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		recs = append(recs, m)
+	}
+	return recs
+}
+
+func TestLogging_levels(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, Config{
+		Format: FormatJSON,
+		Level:  slog.LevelInfo,
+		Levels: map[string]slog.Level{"rest": slog.LevelDebug},
+	})
+	if err != nil {
+		t.Fatalf("New() = err: %v", err)
+	}
+	rest, ws := l.Logger("rest"), l.Logger("websocket")
+
+	rest.Debug("rest debug")
+	ws.Debug("ws debug")
+	ws.Info("ws info")
+
+	recs := records(t, &buf)
+	var got []string
+	for _, r := range recs {
+		got = append(got, r[SubsystemKey].(string)+": "+r["msg"].(string))
+	}
+	if want := []string{"rest: rest debug", "websocket: ws info"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	// уровни меняются у уже выданных логгеров
+	buf.Reset()
+	l.SetLevels(slog.LevelWarn, nil)
+	rest.Debug("rest debug")
+	ws.Info("ws info")
+	ws.Warn("ws warn")
+	if recs := records(t, &buf); len(recs) != 1 || recs[0]["msg"] != "ws warn" {
+		t.Errorf("after SetLevels records = %v, want only ws warn", recs)
+	}
+
+	if _, err := New(&buf, Config{Format: "xml"}); err == nil {
+		t.Error("New() = nil, want error for unknown format")
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	got, err := ParseLevels("rest=debug, websocket=WARN,")
+	if err != nil {
+		t.Fatalf("ParseLevels() = err: %v", err)
+	}
+	want := map[string]slog.Level{"rest": slog.LevelDebug, "websocket": slog.LevelWarn}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLevels() = %v, want %v", got, want)
+	}
+	for _, s := range []string{"rest", "rest=loud"} {
+		if _, err := ParseLevels(s); err == nil {
+			t.Errorf("ParseLevels(%q) = nil, want error", s)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	l, _ := New(&buf, Config{Format: FormatJSON})
+	logger := l.Logger("rest")
+
+	h := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter is not http.Flusher")
+		}
+		logger.ErrorContext(r.Context(), "db err")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/status?x=1", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc" {
+		t.Errorf("%s = %q, want %q", RequestIDHeader, got, "abc")
+	}
+	recs := records(t, &buf)
+	if len(recs) != 2 {
+		t.Fatalf("records = %v, want 2", recs)
+	}
+	// запись обработчика получает идентификатор из контекста
+	if recs[0][RequestIDKey] != "abc" {
+		t.Errorf("handler record %s = %v, want %q", RequestIDKey, recs[0][RequestIDKey], "abc")
+	}
+	r := recs[1]
+	if r["msg"] != "request" || r[RequestIDKey] != "abc" || r["status"] != float64(http.StatusTeapot) ||
+		r["path"] != "/api/status" || r["method"] != http.MethodGet || r["duration"] == nil {
+		t.Errorf("request record = %v", r)
+	}
+
+	// без заголовка идентификатор генерируется
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get(RequestIDHeader); len(got) != 16 {
+		t.Errorf("%s = %q, want generated id", RequestIDHeader, got)
+	}
+}
@@ -0,0 +1,162 @@
+// Пакет metrics предоставляет метрики Prometheus для конвейера
+// получения курсов и HTTP серверов. Метрики конвейера снимаются
+// промежуточными стадиями и стоками ошибок, которые пропускают
+// данные через себя без изменений, поэтому сами стадии о метриках
+// не знают.
+package metrics
+
+import (
+	"net/http"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/pipeline"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// стадии конвейера для метки stage
+const (
+	StagePoll      = "poll"
+	StageDecode    = "decode"
+	StageAggregate = "aggregate"
+	StageProcess   = "process"
+	StagePublish   = "publish"
+)
+
+// Pipeline - метрики конвейера получения курсов.
+type Pipeline struct {
+	polls     *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	lastPoll  *prometheus.GaugeVec
+	errors    *prometheus.CounterVec
+	processed *prometheus.CounterVec
+	now       func() time.Time
+}
+
+// NewPipeline возвращает метрики конвейера,
+// зарегистрированные в reg.
+func NewPipeline(reg prometheus.Registerer) *Pipeline {
+	p := Pipeline{
+		polls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_poller_attempts_total",
+			Help: "Number of poll attempts by source.",
+		}, []string{"source"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_poller_failures_total",
+			Help: "Number of failed polls by source.",
+		}, []string{"source"}),
+		lastPoll: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "xtest_poller_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful poll by source.",
+		}, []string{"source"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_pipeline_errors_total",
+			Help: "Number of pipeline errors by stage and source.",
+		}, []string{"stage", "source"}),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_pipeline_processed_rates_total",
+			Help: "Number of rates passed to processing by source.",
+		}, []string{"source"}),
+		now: time.Now,
+	}
+	reg.MustRegister(p.polls, p.failures, p.lastPoll, p.errors, p.processed)
+	return &p
+}
+
+// Polled считает успешные опросы источника source:
+// каждое сообщение в out - успешный опрос.
+func (p *Pipeline) Polled(source string, out <-chan []byte) <-chan []byte {
+	polls := p.polls.WithLabelValues(source)
+	last := p.lastPoll.WithLabelValues(source)
+	return tap(out, func([]byte) {
+		polls.Inc()
+		last.Set(float64(p.now().UnixNano()) / 1e9)
+	})
+}
+
+// PollFailed считает неудачные опросы источника source:
+// каждая ошибка, переданная в возвращаемый сток, - неудачный
+// опрос. Ошибки передаются дальше в errs.
+func (p *Pipeline) PollFailed(source string, errs pipeline.Sink) pipeline.Sink {
+	polls := p.polls.WithLabelValues(source)
+	failures := p.failures.WithLabelValues(source)
+	return func(err error) {
+		polls.Inc()
+		failures.Inc()
+		errs(err)
+	}
+}
+
+// Errors считает ошибки стадии stage источника source
+// и передает их дальше в errs.
+func (p *Pipeline) Errors(stage, source string, errs pipeline.Sink) pipeline.Sink {
+	c := p.errors.WithLabelValues(stage, source)
+	return func(err error) {
+		c.Inc()
+		errs(err)
+	}
+}
+
+// Processed считает курсы источника source,
+// поступающие на обработку.
+func (p *Pipeline) Processed(source string, in <-chan []domain.Rate) <-chan []domain.Rate {
+	c := p.processed.WithLabelValues(source)
+	return tap(in, func(r []domain.Rate) { c.Add(float64(len(r))) })
+}
+
+// tap вызывает fn для каждого значения из in и передает
+// значение дальше. Выходной канал закрывается вслед за in.
+func tap[T any](in <-chan T, fn func(T)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			fn(v)
+			out <- v
+		}
+	}()
+	return out
+}
+
+// HTTP - метрики HTTP серверов.
+type HTTP struct {
+	duration *prometheus.HistogramVec
+}
+
+// NewHTTP возвращает метрики HTTP серверов,
+// зарегистрированные в reg.
+func NewHTTP(reg prometheus.Registerer) *HTTP {
+	h := HTTP{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "xtest_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by server, route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"server", "route", "method", "code"}),
+	}
+	reg.MustRegister(h.duration)
+	return &h
+}
+
+// Middleware возвращает промежуточный обработчик, который
+// измеряет время запросов к маршрутам сервера server.
+// Маршрут берется из шаблона пути mux, чтобы параметры
+// пути не порождали новых временных рядов.
+func (h *HTTP) Middleware(server string) mux.MiddlewareFunc {
+	obs := h.duration.MustCurryWith(prometheus.Labels{"server": server})
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := "unknown"
+			if cr := mux.CurrentRoute(r); cr != nil {
+				if tmpl, err := cr.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+			// promhttp сохраняет http.Flusher и http.Hijacker
+			// у ResponseWriter, они нужны SSE и WebSocket
+			promhttp.InstrumentHandlerDuration(
+				obs.MustCurryWith(prometheus.Labels{"route": route}), next).ServeHTTP(w, r)
+		})
+	}
+}
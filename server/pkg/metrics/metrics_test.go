@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+	"xtestserver/domain"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPipeline_Poll(t *testing.T) {
+	p := NewPipeline(prometheus.NewRegistry())
+	p.now = func() time.Time { return time.Unix(1658252361, 0) }
+
+	var failed []error
+	errs := p.PollFailed("kucoin", func(err error) { failed = append(failed, err) })
+	out := make(chan []byte)
+	tout := p.Polled("kucoin", out)
+
+	go func() {
+		out <- []byte("{}")
+		errs(errors.New("timeout"))
+		out <- []byte("{}")
+		close(out)
+	}()
+	for range tout {
+	}
+
+	if len(failed) != 1 {
+		t.Errorf("PollFailed() passed %d errors, want %d", len(failed), 1)
+	}
+	if got := testutil.ToFloat64(p.polls.WithLabelValues("kucoin")); got != 3 {
+		t.Errorf("attempts = %v, want %v", got, 3)
+	}
+	if got := testutil.ToFloat64(p.failures.WithLabelValues("kucoin")); got != 1 {
+		t.Errorf("failures = %v, want %v", got, 1)
+	}
+	if got := testutil.ToFloat64(p.lastPoll.WithLabelValues("kucoin")); got != 1658252361 {
+		t.Errorf("last success = %v, want %v", got, 1658252361)
+	}
+}
+
+func TestPipeline_Errors(t *testing.T) {
+	p := NewPipeline(prometheus.NewRegistry())
+	n := 0
+	errs := p.Errors(StageDecode, "cbr", func(error) { n++ })
+	errs(errors.New("bad xml"))
+	errs(errors.New("bad xml"))
+
+	if n != 2 {
+		t.Errorf("Errors() passed %d errors, want %d", n, 2)
+	}
+	if got := testutil.ToFloat64(p.errors.WithLabelValues(StageDecode, "cbr")); got != 2 {
+		t.Errorf("errors = %v, want %v", got, 2)
+	}
+}
+
+func TestPipeline_Processed(t *testing.T) {
+	p := NewPipeline(prometheus.NewRegistry())
+
+	in := make(chan []domain.Rate)
+	out := p.Processed("cbr", in)
+	go func() {
+		in <- make([]domain.Rate, 3)
+		in <- make([]domain.Rate, 2)
+		close(in)
+	}()
+	n := 0
+	for r := range out {
+		n += len(r)
+	}
+	if n != 5 {
+		t.Fatalf("Processed() passed %d rates, want %d", n, 5)
+	}
+	if got := testutil.ToFloat64(p.processed.WithLabelValues("cbr")); got != 5 {
+		t.Errorf("processed = %v, want %v", got, 5)
+	}
+}
+
+func TestHTTP_Middleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := NewHTTP(reg)
+
+	r := mux.NewRouter()
+	r.Use(h.Middleware("rest"))
+	r.HandleFunc("/api/pairs/{pair}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter is not http.Flusher")
+		}
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/api/pairs/a", "/api/pairs/b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// оба запроса попали в один временной ряд маршрута
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() = err: %v", err)
+	}
+	if len(mfs) != 1 || len(mfs[0].GetMetric()) != 1 {
+		t.Fatalf("Gather() = %v, want one series", mfs)
+	}
+	m := mfs[0].GetMetric()[0]
+	labels := make(map[string]string)
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	want := map[string]string{"server": "rest", "route": "/api/pairs/{pair}", "method": "get", "code": "418"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("sample count = %d, want %d", got, 2)
+	}
+}
@@ -0,0 +1,250 @@
+// Пакет pipeline предоставляет типизированные стадии конвейера:
+// стадия читает значения из входного канала, обрабатывает их
+// в одной или нескольких горутинах и отдает результаты в выходной
+// канал ограниченного размера, а ошибки - в сток ошибок, поэтому
+// непрочитанный канал ошибок не может остановить стадию.
+//
+// Выходной канал стадии закрывается, когда закрыт входной канал,
+// отменен контекст или обработчик запаниковал. После этого стадия
+// дочитывает и отбрасывает оставшийся вход, чтобы предыдущие стадии
+// не заблокировались на отправке.
+package pipeline
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"xtestserver/pkg/recovery"
+)
+
+// Sink - сток ошибок стадии. Вызывается синхронно
+// из горутин стадии и не должен надолго блокироваться.
+type Sink func(err error)
+
+// Discard - сток, отбрасывающий ошибки.
+func Discard(error) {}
+
+// Func обрабатывает одно значение: отправляет ноль и более
+// результатов через emit и возвращает ошибку обработки, которая
+// уходит в сток и не останавливает стадию. emit возвращает false,
+// если контекст отменен и результат отброшен.
+type Func[In, Out any] func(ctx context.Context, v In, emit func(Out) bool) error
+
+// Map превращает функцию "значение - результат"
+// в Func, отправляющую ровно один результат без ошибки.
+func Map[In, Out any](f func(In) (Out, error)) Func[In, Out] {
+	return func(_ context.Context, v In, emit func(Out) bool) error {
+		out, err := f(v)
+		if err != nil {
+			return err
+		}
+		emit(out)
+		return nil
+	}
+}
+
+// options - настройки стадии.
+type options struct {
+	buffer  int // размер выходного канала
+	workers int // число горутин-обработчиков
+}
+
+// Option - функция, изменяющая настройки стадии.
+type Option func(*options)
+
+// WithBuffer устанавливает размер выходного канала. Когда он
+// заполнен, стадия ждет следующую и перестает читать вход.
+func WithBuffer(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.buffer = n
+		}
+	}
+}
+
+// WithWorkers устанавливает число горутин, параллельно
+// обрабатывающих вход. Порядок результатов при этом не сохраняется.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// newOptions возвращает настройки по умолчанию
+// (один обработчик, небуферизованный выход) с учетом opts.
+func newOptions(opts []Option) options {
+	o := options{workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Stage - стадия конвейера, превращающая значения In в значения Out.
+type Stage[In, Out any] struct {
+	f    Func[In, Out]
+	errs Sink
+	opts options
+}
+
+// New возвращает стадию с обработчиком f и стоком ошибок errs.
+func New[In, Out any](f Func[In, Out], errs Sink, opts ...Option) *Stage[In, Out] {
+	if errs == nil {
+		errs = Discard
+	}
+	return &Stage[In, Out]{f: f, errs: errs, opts: newOptions(opts)}
+}
+
+// Run запускает стадию на входе in и возвращает выходной канал.
+// Стадию можно запускать несколько раз на разных входах.
+func (s *Stage[In, Out]) Run(ctx context.Context, in <-chan In) <-chan Out {
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan Out, s.opts.buffer)
+	emit := func(v Out) bool {
+		select {
+		case out <- v:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(s.opts.workers)
+	for i := 0; i < s.opts.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					if !s.call(ctx, v, emit) {
+						cancel() // паника останавливает всю стадию
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+		for range in {
+		}
+	}()
+	return out
+}
+
+// call обрабатывает значение, отправляя ошибку и панику в сток.
+// Возвращает false, если обработчик запаниковал.
+func (s *Stage[In, Out]) call(ctx context.Context, v In, emit func(Out) bool) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			s.errs(&recovery.PanicError{Value: p, Stack: debug.Stack()})
+			ok = false
+		}
+	}()
+	if err := s.f(ctx, v, emit); err != nil {
+		s.errs(err)
+	}
+	return true
+}
+
+// Generate запускает источник значений: f выполняется в отдельной
+// горутине и отправляет значения через emit, пока не отменен ctx.
+// Выходной канал закрывается, когда f вернет управление.
+// Паника в f уходит в сток ошибок и тоже закрывает канал.
+func Generate[Out any](ctx context.Context, f func(ctx context.Context, emit func(Out) bool), errs Sink,
+	opts ...Option) <-chan Out {
+	if errs == nil {
+		errs = Discard
+	}
+	o := newOptions(opts)
+	out := make(chan Out, o.buffer)
+	emit := func(v Out) bool {
+		select {
+		case out <- v:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		defer func() {
+			if p := recover(); p != nil {
+				errs(&recovery.PanicError{Value: p, Stack: debug.Stack()})
+			}
+		}()
+		f(ctx, emit)
+	}()
+	return out
+}
+
+// Merge сливает несколько каналов в один (fan-in).
+// Выходной канал закрывается, когда закрыты все входные
+// или отменен ctx; после отмены входные каналы вычитываются
+// до закрытия, чтобы не блокировать предыдущие стадии.
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			forward(ctx, in, out)
+			wg.Done()
+			for range in {
+			}
+		}(in)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// OnClose передает значения из in в выходной канал, пока
+// не отменен ctx, и вызывает f, когда in закрыт.
+func OnClose[T any](ctx context.Context, in <-chan T, f func()) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer f()
+		forward(ctx, in, out)
+		close(out)
+		for range in {
+		}
+	}()
+	return out
+}
+
+// forward передает значения из in в out,
+// пока in не закрыт или не отменен ctx.
+func forward[T any](ctx context.Context, in <-chan T, out chan<- T) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"xtestserver/pkg/recovery"
+)
+
+// collect читает канал до закрытия.
+func collect[T any](t *testing.T, ch <-chan T) []T {
+	t.Helper()
+	var got []T
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		case <-timeout:
+			t.Fatal("channel was not closed")
+		}
+	}
+}
+
+// feed отправляет значения в новый канал и закрывает его.
+func feed[T any](vs ...T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, v := range vs {
+			ch <- v
+		}
+	}()
+	return ch
+}
+
+// errSink собирает ошибки.
+type errSink struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (s *errSink) sink(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *errSink) get() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+func TestStage_Run(t *testing.T) {
+	var errs errSink
+	atoi := New(Map(strconv.Atoi), errs.sink)
+	// на каждое число - само число и его квадрат
+	square := New(func(_ context.Context, v int, emit func(int) bool) error {
+		if v < 0 {
+			return errors.New("negative")
+		}
+		emit(v)
+		emit(v * v)
+		return nil
+	}, errs.sink)
+
+	ctx := context.Background()
+	got := collect(t, square.Run(ctx, atoi.Run(ctx, feed("1", "x", "3", "-2"))))
+
+	if want := []int{1, 1, 3, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Run() = %v, want %v", got, want)
+	}
+	if e := errs.get(); len(e) != 2 {
+		t.Errorf("Run() errors = %v, want 2 errors", e)
+	}
+}
+
+func TestStage_backpressure(t *testing.T) {
+	in := make(chan int)
+	out := New(Map(func(v int) (int, error) { return v, nil }), nil, WithBuffer(2)).Run(context.Background(), in)
+
+	// выход никто не читает: стадия принимает значения в буфер (2)
+	// и одно значение в обработчик, а потом перестает читать вход
+	sent := 0
+	for sent < 10 {
+		select {
+		case in <- sent:
+			sent++
+			continue
+		case <-time.After(50 * time.Millisecond):
+		}
+		break
+	}
+	if sent != 3 {
+		t.Errorf("Run() accepted %d values without reader, want %d", sent, 3)
+	}
+
+	close(in)
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("Run() = %v, want %v", got, []int{0, 1, 2})
+	}
+}
+
+func TestStage_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	blocked := make(chan struct{})
+	out := New(func(ctx context.Context, v int, emit func(int) bool) error {
+		close(blocked)
+		if emit(v) {
+			t.Error("emit() = true after cancel without reader")
+		}
+		return nil
+	}, nil).Run(ctx, in)
+
+	in <- 1
+	<-blocked
+	cancel()
+	collect(t, out)
+
+	// после отмены стадия дочитывает вход, не блокируя отправителя
+	select {
+	case in <- 2:
+	case <-time.After(time.Second):
+		t.Fatal("Run() blocked upstream after cancel")
+	}
+	close(in)
+}
+
+func TestStage_panic(t *testing.T) {
+	var errs errSink
+	in := make(chan int)
+	out := New(func(_ context.Context, v int, emit func(int) bool) error {
+		if v == 2 {
+			panic("boom")
+		}
+		emit(v)
+		return nil
+	}, errs.sink, WithBuffer(10)).Run(context.Background(), in)
+
+	for _, v := range []int{1, 2, 3, 4} {
+		in <- v // не блокируется и после паники
+	}
+	close(in)
+
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Run() = %v, want %v", got, []int{1})
+	}
+	var perr *recovery.PanicError
+	if e := errs.get(); len(e) != 1 || !errors.As(e[0], &perr) || perr.Value != "boom" {
+		t.Errorf("Run() errors = %v, want panic boom", e)
+	}
+}
+
+func TestStage_workers(t *testing.T) {
+	// три значения обрабатываются одновременно:
+	// каждое ждет, пока начнут обрабатываться все
+	var started sync.WaitGroup
+	started.Add(3)
+	out := New(Map(func(v int) (int, error) {
+		started.Done()
+		started.Wait()
+		return v, nil
+	}), nil, WithWorkers(3)).Run(context.Background(), feed(1, 2, 3))
+
+	got := collect(t, out)
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("Run() = %v, want %v", got, []int{1, 2, 3})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := Generate(ctx, func(ctx context.Context, emit func(int) bool) {
+		for i := 0; emit(i); i++ {
+		}
+	}, nil)
+	for i := 0; i < 3; i++ {
+		if v := <-out; v != i {
+			t.Fatalf("Generate() = %d, want %d", v, i)
+		}
+	}
+	cancel()
+	collect(t, out)
+
+	var errs errSink
+	out = Generate(context.Background(), func(context.Context, func(int) bool) { panic("boom") }, errs.sink)
+	collect(t, out)
+	if e := errs.get(); len(e) != 1 {
+		t.Errorf("Generate() errors = %v, want panic", e)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := collect(t, Merge(context.Background(), feed(1, 2), feed(3), feed[int]()))
+	sort.Ints(got)
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %v, want %v", got, want)
+	}
+
+	// после отмены выход закрывается, даже если его никто
+	// не читает, а входы вычитываются до закрытия
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := Merge(ctx, in)
+	cancel()
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		in <- 1
+		in <- 2
+		close(in)
+	}()
+	collect(t, out)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Merge() blocked input after cancel")
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	closed := make(chan struct{})
+	out := OnClose(context.Background(), feed(1, 2), func() { close(closed) })
+
+	if got := collect(t, out); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("OnClose() = %v, want %v", got, []int{1, 2})
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose() did not call f")
+	}
+
+	// после отмены выход закрывается, а f вызывается,
+	// когда вычитанный вход закрыт
+	ctx, cancel := context.WithCancel(context.Background())
+	closed = make(chan struct{})
+	out = OnClose(ctx, feed(1, 2, 3), func() { close(closed) })
+	cancel()
+	collect(t, out)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose() did not call f after cancel")
+	}
+}
@@ -8,27 +8,48 @@ import (
 	"io"
 	"net/http"
 	"time"
+	"xtestserver/pkg/pipeline"
 )
 
-// Poll - опрашивает переданную url-ссылку
-// с заданным интервалом и отдает байты тела ответа в канал.
-func Poll(ctx context.Context, url string, interval time.Duration) (<-chan []byte, <-chan error) {
+// DefaultTimeout - таймаут одного запроса по умолчанию.
+const DefaultTimeout = 5 * time.Second
 
-	out := make(chan []byte)
-	errs := make(chan error)
+// Option - функция, изменяющая настройки опроса.
+type Option func(*options)
 
-	go func() {
-		defer func() {
-			close(out)
-			close(errs)
-		}()
+// options - настройки опроса.
+type options struct {
+	timeout time.Duration // таймаут одного запроса
+}
+
+// WithTimeout устанавливает таймаут одного запроса.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.timeout = d
+		}
+	}
+}
 
+// Poll - опрашивает переданную url-ссылку с заданным интервалом
+// и отдает байты тела ответа в канал, а ошибки опроса - в errs.
+// Канал закрывается после отмены контекста.
+func Poll(ctx context.Context, url string, interval time.Duration, errs pipeline.Sink, opts ...Option) <-chan []byte {
+	o := options{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if errs == nil {
+		errs = pipeline.Discard
+	}
+
+	return pipeline.Generate(ctx, func(ctx context.Context, emit func([]byte) bool) {
 		poll := func() {
-			b, err := request(ctx, url) // выполняем опрос
+			b, err := request(ctx, url, o.timeout) // выполняем опрос
 			if err == nil {
-				out <- b
+				emit(b)
 			} else {
-				errs <- fmt.Errorf("poll %s: %w", url, err)
+				errs(fmt.Errorf("poll %s: %w", url, err))
 			}
 		}
 
@@ -42,16 +63,13 @@ func Poll(ctx context.Context, url string, interval time.Duration) (<-chan []byt
 				return
 			}
 		}
-
-	}()
-
-	return out, errs
+	}, errs)
 }
 
 // request - вспомогательная функция, выполняющая обращение
 // к ресурсу с таймаутом.
-func request(ctx context.Context, url string) ([]byte, error) {
-	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+func request(ctx context.Context, url string, timeout time.Duration) ([]byte, error) {
+	c, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(c, http.MethodGet, url, nil)
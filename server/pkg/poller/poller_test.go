@@ -40,27 +40,12 @@ func TestPoll(t *testing.T) {
 
 	t.Run("count_polls", func(t *testing.T) {
 
-		values, errs := Poll(ctx, ts.URL, time.Millisecond)
-
-		var wg sync.WaitGroup
-		wg.Add(2)
+		values := Poll(ctx, ts.URL, time.Millisecond, nil)
 
 		got := 0
-
-		go func() {
-			for range values {
-				got++
-			}
-			wg.Done()
-		}()
-
-		go func() {
-			for range errs {
-			}
-			wg.Done()
-		}()
-
-		wg.Wait()
+		for range values {
+			got++
+		}
 
 		if got != want {
 			t.Fatalf("Poll() got values = %d, want values = %d", got, want)
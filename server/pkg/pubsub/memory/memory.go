@@ -0,0 +1,78 @@
+// Пакет memory представляет реализацию шины обновлений
+// в памяти процесса: для одного экземпляра сервера и тестов.
+package memory
+
+import (
+	"context"
+	"sync"
+	"xtestserver/pkg/pubsub"
+)
+
+var _ pubsub.PubSub = (*PubSub)(nil)
+
+// буфер канала каждого подписчика
+const bufSize = 64
+
+// subscriber - подписчик шины.
+type subscriber struct {
+	ch   chan []byte
+	done chan struct{}
+}
+
+// PubSub раздает сообщения подписчикам в памяти.
+type PubSub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// New возвращает новую шину.
+func New() *PubSub {
+	return &PubSub{subs: make(map[*subscriber]struct{})}
+}
+
+// Publish отправляет сообщение всем подписчикам.
+// Блокируется, пока каждый подписчик не примет сообщение,
+// не отпишется или не будет отменен контекст.
+func (ps *PubSub) Publish(ctx context.Context, msg []byte) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for s := range ps.subs {
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe возвращает канал сообщений,
+// который закрывается при отмене контекста.
+func (ps *PubSub) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	s := &subscriber{
+		ch:   make(chan []byte, bufSize),
+		done: make(chan struct{}),
+	}
+
+	ps.mu.Lock()
+	ps.subs[s] = struct{}{}
+	ps.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		close(s.done) // отпускаем заблокированного издателя
+		ps.mu.Lock()
+		delete(ps.subs, s)
+		ps.mu.Unlock()
+		close(s.ch)
+	}()
+
+	return s.ch, nil
+}
+
+// Close - no-op
+func (ps *PubSub) Close() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"xtestserver/pkg/pubsub"
+)
+
+func TestPubSub(t *testing.T) {
+	ps := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subs := make([]<-chan []byte, 3)
+	for i := range subs {
+		var err error
+		subs[i], err = ps.Subscribe(ctx)
+		if err != nil {
+			t.Fatalf("Subscribe() = err: %v", err)
+		}
+	}
+
+	in := make(chan []byte)
+	done := pubsub.Forward(context.Background(), ps, in, func(err error) {
+		t.Errorf("Forward() = err: %v", err)
+	})
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- []byte(fmt.Sprint(i))
+		}
+		close(in)
+	}()
+	<-done
+
+	for i := range subs {
+		for j := 0; j < 10; j++ {
+			select {
+			case got := <-subs[i]:
+				if string(got) != fmt.Sprint(j) {
+					t.Errorf("Subscribe() = %s, want %d", got, j)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Subscribe() = err: didn't receive value from channel")
+			}
+		}
+	}
+
+	cancel()
+	for i := range subs {
+		if _, ok := <-subs[i]; ok {
+			t.Error("Subscribe() channel is open after context cancel")
+		}
+	}
+
+	// после отписки публикация не блокируется
+	if err := ps.Publish(context.Background(), []byte("x")); err != nil {
+		t.Errorf("Publish() = err: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Пакет postgres представляет реализацию шины
+// обновлений на основе LISTEN/NOTIFY.
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"xtestserver/pkg/pubsub"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var _ pubsub.PubSub = (*PubSub)(nil)
+
+// Channel - канал NOTIFY, по которому
+// экземпляры сервера обмениваются обновлениями.
+const Channel = "xtest_updates"
+
+// maxPayload - ограничение Postgres на размер сообщения NOTIFY.
+const maxPayload = 8000
+
+// retryInterval - пауза перед повторным
+// подключением слушателя после ошибки.
+const retryInterval = time.Second
+
+var ErrPayloadTooLarge = fmt.Errorf("notify payload exceeds %d bytes", maxPayload)
+
+// PubSub публикует и получает обновления через LISTEN/NOTIFY.
+type PubSub struct {
+	db *pgxpool.Pool
+}
+
+// New выполняет подключение
+// и возвращает объект шины обновлений.
+func New(connString string) (*PubSub, error) {
+
+	pool, err := pgxpool.Connect(context.Background(), connString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PubSub{db: pool}, pool.Ping(context.Background())
+}
+
+// Close выполняет закрытие подключения к БД.
+func (ps *PubSub) Close() error {
+	ps.db.Close()
+	return nil
+}
+
+// Publish отправляет сообщение всем слушателям канала,
+// в том числе слушателям этого же экземпляра.
+func (ps *PubSub) Publish(ctx context.Context, msg []byte) error {
+	if len(msg) > maxPayload {
+		return ErrPayloadTooLarge
+	}
+	_, err := ps.db.Exec(ctx, `SELECT pg_notify($1, $2);`, Channel, string(msg))
+	return err
+}
+
+// Subscribe открывает отдельное соединение под LISTEN и возвращает
+// канал сообщений, который закрывается при отмене контекста.
+// Если соединение с БД потеряно, слушатель переподключается;
+// сообщения, отправленные за это время, теряются.
+func (ps *PubSub) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	conn, err := ps.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan []byte)
+
+	go func() {
+		defer close(out)
+
+		for {
+			err := ps.receive(ctx, conn, out)
+			_ = conn.Close(context.Background())
+			if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+				return
+			}
+			for {
+				select {
+				case <-time.After(retryInterval):
+				case <-ctx.Done():
+					return
+				}
+				if conn, err = ps.listen(ctx); err == nil {
+					break
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// listen открывает отдельное от пула соединение и подписывает его
+// на канал, чтобы уведомления не копились в соединениях пула.
+func (ps *PubSub) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, ps.db.Config().ConnConfig)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize())
+	if err != nil {
+		_ = conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receive отправляет полученные уведомления в канал out,
+// пока не случится ошибка соединения или отмена контекста.
+func (ps *PubSub) receive(ctx context.Context, conn *pgx.Conn, out chan<- []byte) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case out <- []byte(n.Payload):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
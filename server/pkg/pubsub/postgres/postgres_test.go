@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tdbEnv = "TEST_DB"
+
+func TestPubSub(t *testing.T) {
+	connstr, ok := os.LookupEnv(tdbEnv)
+	if !ok {
+		t.Skipf("environment variable %q is not set, skipped...", tdbEnv)
+	}
+
+	ps, err := New(connstr)
+	if err != nil {
+		t.Fatalf("db connection: %v", err)
+	}
+	defer ps.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := ps.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe() = err: %v", err)
+	}
+
+	want := `{"label":"BTC/USDT","data":{"value":1}}`
+	if err = ps.Publish(context.Background(), []byte(want)); err != nil {
+		t.Fatalf("Publish() = err: %v", err)
+	}
+
+	select {
+	case got := <-sub:
+		if string(got) != want {
+			t.Errorf("Subscribe() = %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe() = err: didn't receive value from channel")
+	}
+
+	err = ps.Publish(context.Background(), []byte(strings.Repeat("x", maxPayload+1)))
+	if err != ErrPayloadTooLarge {
+		t.Errorf("Publish() = %v, want %v", err, ErrPayloadTooLarge)
+	}
+
+	cancel()
+	for range sub {
+	}
+}
@@ -0,0 +1,33 @@
+// Пакет pubsub описывает шину обновлений между экземплярами
+// сервера: экземпляр, получивший данные из источника, публикует
+// обновления, а все экземпляры (включая его самого) раздают их
+// своим WebSocket и SSE клиентам.
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"xtestserver/pkg/pipeline"
+)
+
+// PubSub - контракт шины обновлений.
+type PubSub interface {
+	// Публикует сообщение для всех подписчиков.
+	Publish(ctx context.Context, msg []byte) error
+	// Возвращает канал сообщений, который
+	// закрывается при отмене контекста.
+	Subscribe(ctx context.Context) (<-chan []byte, error)
+	Close() error // закрываем соединение с шиной.
+}
+
+// Forward публикует в шину все сообщения из канала in, пока он
+// не закрыт, ошибки публикации уходят в errs. Возвращает канал,
+// который закрывается, когда все сообщения опубликованы.
+func Forward(ctx context.Context, ps PubSub, in <-chan []byte, errs pipeline.Sink) <-chan struct{} {
+	return pipeline.New(func(ctx context.Context, msg []byte, _ func(struct{}) bool) error {
+		if err := ps.Publish(ctx, msg); err != nil {
+			return fmt.Errorf("publish update: %w", err)
+		}
+		return nil
+	}, errs).Run(ctx, in)
+}
@@ -0,0 +1,16 @@
+// Пакет recovery описывает перехваченные паники,
+// общие для конвейеров и их супервизора.
+package recovery
+
+import "fmt"
+
+// PanicError - паника, перехваченная в задаче
+// или в обработчике стадии конвейера.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
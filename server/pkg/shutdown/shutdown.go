@@ -0,0 +1,67 @@
+// Пакет shutdown выполняет упорядоченную остановку
+// подсистем сервера в пределах общего таймаута.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Step - шаг остановки.
+type Step struct {
+	Name string
+	// Останавливает подсистему. Контекст отменяется,
+	// когда истекает общий таймаут остановки.
+	Func func(ctx context.Context) error
+}
+
+// Run выполняет шаги по порядку и логирует результат каждого.
+// Ошибка шага не прерывает остановку: следующие шаги
+// выполняются даже после истечения timeout, чтобы успеть
+// хотя бы закрыть соединения. Возвращает ошибки всех шагов.
+func Run(ctx context.Context, timeout time.Duration, logger *slog.Logger, steps ...Step) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var errs []error
+	for _, s := range steps {
+		start := time.Now()
+		err := s.Func(ctx)
+		if err != nil {
+			logger.Error("shutdown step failed", "step", s.Name, "duration", time.Since(start), "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+			continue
+		}
+		logger.Info("shutdown step done", "step", s.Name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// Wait ждет закрытия канала done или отмены контекста.
+func Wait(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Grace возвращает контекст, который переживает parent не дольше
+// чем на d: после отмены parent у работы, начатой с этим контекстом
+// (например, записи в БД уже полученных данных), есть d на завершение.
+// Значения контекста наследуются от parent.
+func Grace(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(parent, func() {
+		t := time.AfterFunc(d, cancel)
+		context.AfterFunc(ctx, func() { t.Stop() })
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
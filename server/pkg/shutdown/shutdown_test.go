@@ -0,0 +1,81 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	errClose := errors.New("close failed")
+
+	var order []string
+	step := func(name string, err error) Step {
+		return Step{Name: name, Func: func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+	slow := Step{Name: "slow", Func: func(ctx context.Context) error {
+		order = append(order, "slow")
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	err := Run(context.Background(), 10*time.Millisecond, logger,
+		step("ingest", nil), slow, step("clients", errClose), step("db", nil))
+
+	if want := []string{"ingest", "slow", "clients", "db"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Run() steps = %v, want %v", order, want)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errClose) {
+		t.Errorf("Run() = %v, want %v and %v", err, context.DeadlineExceeded, errClose)
+	}
+
+	if err := Run(context.Background(), time.Second, logger, step("db", nil)); err != nil {
+		t.Errorf("Run() = %v, want nil", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	done := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, done); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(done)
+	if err := Wait(context.Background(), done); err != nil {
+		t.Errorf("Wait() = %v, want nil", err)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := Grace(parent, 50*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+	select {
+	case <-ctx.Done():
+		t.Fatal("Grace() context canceled together with parent")
+	case <-time.After(20 * time.Millisecond):
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Grace() context not canceled after grace period")
+	}
+
+	// отмена без отмены parent
+	ctx, cancel = Grace(context.Background(), time.Hour)
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("Grace() context not canceled by cancel func")
+	}
+}
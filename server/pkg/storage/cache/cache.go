@@ -0,0 +1,298 @@
+// Пакет cache представляет кэширующую обертку над контрактом БД.
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
+)
+
+var _ storage.Storage = (*Cache)(nil)
+
+// Stats - счетчики обращений к кэшу.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// entry - закэшированный результат запроса.
+type entry struct {
+	rates   []domain.Rate
+	expires time.Time // нулевое - не устаревает
+	used    uint64    // момент последнего обращения по счетчику Cache.tick
+}
+
+// valid - актуальна ли запись?
+func (e entry) valid(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
+}
+
+// options - настройки кэша.
+type options struct {
+	currentTTL time.Duration // время жизни текущих курсов, 0 - до записи в БД
+	historyTTL time.Duration // время жизни истории, 0 - не кэшируем
+	maxEntries int           // наибольшее число записей в каждом отображении
+}
+
+// defaultMaxEntries - наибольшее число записей
+// в каждом отображении кэша по умолчанию.
+const defaultMaxEntries = 1024
+
+// Option - функция, изменяющая настройки кэша.
+type Option func(*options)
+
+// WithCurrentTTL ограничивает время жизни текущих курсов.
+// Нужно, если в БД пишет другой экземпляр сервера
+// и кэш не узнает о записи.
+func WithCurrentTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.currentTTL = d
+	}
+}
+
+// WithHistoryTTL включает кэширование
+// запросов истории на время d.
+func WithHistoryTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.historyTTL = d
+	}
+}
+
+// WithMaxEntries ограничивает число записей в каждом отображении
+// кэша. Когда отображение заполнено, из него удаляются устаревшие
+// записи, а если таких нет - запись, к которой дольше всего не обращались.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// Cache кэширует текущие курсы до записи новых значений
+// и, если включено, запросы истории на заданное время.
+type Cache struct {
+	db   storage.Storage
+	opts options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	rates    map[pairKey]entry        // PairRate, текущий курс - storage.Filter{Limit: 1}
+	fiats    map[storage.Filter]entry // Fiats
+	current  map[string]entry         // FiatsCurrent по базовой валюте
+	usd      map[string]entry         // USDRate по базовой валюте
+	ratesGen uint64                   // номер сброса кэша курсов пар
+	fiatsGen uint64                   // номер сброса кэша фиатных валют
+	tick     uint64                   // счетчик обращений для вытеснения LRU
+
+	hits   uint64
+	misses uint64
+}
+
+// New возвращает кэширующую обертку над db.
+func New(db storage.Storage, opts ...Option) *Cache {
+	c := Cache{
+		db:      db,
+		now:     time.Now,
+		rates:   make(map[pairKey]entry),
+		fiats:   make(map[storage.Filter]entry),
+		current: make(map[string]entry),
+		usd:     make(map[string]entry),
+		opts:    options{maxEntries: defaultMaxEntries},
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	return &c
+}
+
+// Stats возвращает счетчики попаданий и промахов.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Close закрывает соединение с БД.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Ping проверяет соединение с БД в обход кэша.
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.db.Ping(ctx)
+}
+
+// AddPairRate добавляет курс в БД и сбрасывает кэш курса этой пары.
+func (c *Cache) AddPairRate(ctx context.Context, policy storage.Conflict, pair domain.Pair, rate domain.Rate) (storage.Result, error) {
+	res, err := c.db.AddPairRate(ctx, policy, pair, rate)
+	if err == nil && res.Inserted+res.Updated > 0 {
+		c.mu.Lock()
+		for k := range c.rates {
+			if k.pair == pair {
+				delete(c.rates, k)
+			}
+		}
+		c.ratesGen++
+		c.mu.Unlock()
+	}
+	return res, err
+}
+
+// AddQuotes добавляет котировки бирж в БД.
+// Котировки не кэшируются, поэтому кэш не сбрасывается.
+func (c *Cache) AddQuotes(ctx context.Context, policy storage.Conflict, pair domain.Pair, quotes ...domain.Rate) (storage.Result, error) {
+	return c.db.AddQuotes(ctx, policy, pair, quotes...)
+}
+
+// Pairs возвращает пары из БД. Список нужен редко и не кэшируется.
+func (c *Cache) Pairs(ctx context.Context) ([]domain.Pair, error) {
+	return c.db.Pairs(ctx)
+}
+
+// AddFiats добавляет курсы в БД и сбрасывает кэш фиатных валют.
+func (c *Cache) AddFiats(ctx context.Context, policy storage.Conflict, rates ...domain.Rate) (storage.Result, error) {
+	res, err := c.db.AddFiats(ctx, policy, rates...)
+	if err == nil && res.Inserted+res.Updated > 0 {
+		c.mu.Lock()
+		clear(c.fiats)
+		clear(c.current)
+		clear(c.usd)
+		c.fiatsGen++
+		c.mu.Unlock()
+	}
+	return res, err
+}
+
+// pairKey - ключ кэша курсов пар.
+type pairKey struct {
+	pair   domain.Pair
+	filter storage.Filter
+}
+
+// PairRate возвращает курс пары из кэша или БД.
+func (c *Cache) PairRate(ctx context.Context, pair domain.Pair, filter storage.Filter) ([]domain.Rate, error) {
+	ttl := c.opts.historyTTL
+	if filter == (storage.Filter{Limit: 1}) {
+		ttl = c.opts.currentTTL
+	} else if ttl == 0 {
+		return c.db.PairRate(ctx, pair, filter)
+	}
+	return lookup(c, c.rates, &c.ratesGen, pairKey{pair, filter}, ttl, func() ([]domain.Rate, error) {
+		return c.db.PairRate(ctx, pair, filter)
+	})
+}
+
+// Fiats возвращает историю курсов фиатных валют из кэша или БД.
+func (c *Cache) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+	if c.opts.historyTTL == 0 {
+		return c.db.Fiats(ctx, filter)
+	}
+	return lookup(c, c.fiats, &c.fiatsGen, filter, c.opts.historyTTL, func() ([]domain.Rate, error) {
+		return c.db.Fiats(ctx, filter)
+	})
+}
+
+// FiatsCurrent возвращает текущие курсы фиатных валют
+// к базовой валюте base из кэша или БД.
+func (c *Cache) FiatsCurrent(ctx context.Context, base string) ([]domain.Rate, error) {
+	return lookup(c, c.current, &c.fiatsGen, base, c.opts.currentTTL, func() ([]domain.Rate, error) {
+		return c.db.FiatsCurrent(ctx, base)
+	})
+}
+
+// USDRate возвращает текущий курс доллара
+// в базовой валюте base из кэша или БД.
+func (c *Cache) USDRate(ctx context.Context, base string) (domain.Rate, error) {
+	rates, err := lookup(c, c.usd, &c.fiatsGen, base, c.opts.currentTTL, func() ([]domain.Rate, error) {
+		rate, err := c.db.USDRate(ctx, base)
+		return []domain.Rate{rate}, err
+	})
+	if err != nil {
+		return domain.Rate{}, err
+	}
+	return rates[0], nil
+}
+
+// lookup возвращает результат запроса из отображения m
+// или выполняет запрос и сохраняет результат на время ttl.
+// Устаревшая запись удаляется при обращении к ней.
+// Если за время запроса кэш был сброшен записью в БД
+// (изменился *gen), результат не сохраняется.
+func lookup[K comparable](c *Cache, m map[K]entry, gen *uint64, key K,
+	ttl time.Duration, query func() ([]domain.Rate, error)) ([]domain.Rate, error) {
+
+	c.mu.Lock()
+	if e, ok := m[key]; ok {
+		if e.valid(c.now()) {
+			c.tick++
+			e.used = c.tick
+			m[key] = e
+			rates := copyRates(e.rates)
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return rates, nil
+		}
+		delete(m, key)
+	}
+	g := *gen
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	rates, err := query()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if g == *gen {
+		if _, ok := m[key]; !ok && len(m) >= c.opts.maxEntries {
+			evict(m, c.now())
+		}
+		c.tick++
+		m[key] = entry{rates: copyRates(rates), expires: c.expires(ttl), used: c.tick}
+	}
+	c.mu.Unlock()
+	return rates, nil
+}
+
+// evict освобождает место в заполненном отображении m:
+// удаляет устаревшие записи, а если таких нет - запись,
+// к которой дольше всего не обращались.
+func evict[K comparable](m map[K]entry, now time.Time) {
+	n := len(m)
+	var (
+		lru   K
+		found bool
+	)
+	for k, e := range m {
+		if !e.valid(now) {
+			delete(m, k)
+			continue
+		}
+		if !found || e.used < m[lru].used {
+			lru, found = k, true
+		}
+	}
+	if len(m) == n && found {
+		delete(m, lru)
+	}
+}
+
+// expires возвращает момент устаревания записи.
+func (c *Cache) expires(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+// copyRates копирует срез, чтобы вызывающий
+// не мог изменить закэшированные данные.
+func copyRates(rates []domain.Rate) []domain.Rate {
+	if rates == nil {
+		return nil
+	}
+	return append(make([]domain.Rate, 0, len(rates)), rates...)
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
+	"xtestserver/pkg/storage/memdb"
+)
+
+// counter считает запросы к БД.
+type counter struct {
+	*memdb.MemDB
+	calls map[string]int
+}
+
+func newCounter() *counter {
+	return &counter{MemDB: memdb.New(), calls: make(map[string]int)}
+}
+
+func (c *counter) PairRate(ctx context.Context, pair domain.Pair, filter storage.Filter) ([]domain.Rate, error) {
+	c.calls["PairRate "+pair.String()]++
+	return c.MemDB.PairRate(ctx, pair, filter)
+}
+
+func (c *counter) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+	c.calls["Fiats"]++
+	return c.MemDB.Fiats(ctx, filter)
+}
+
+func (c *counter) FiatsCurrent(ctx context.Context, base string) ([]domain.Rate, error) {
+	c.calls["FiatsCurrent "+base]++
+	return c.MemDB.FiatsCurrent(ctx, base)
+}
+
+func (c *counter) USDRate(ctx context.Context, base string) (domain.Rate, error) {
+	c.calls["USDRate "+base]++
+	return c.MemDB.USDRate(ctx, base)
+}
+
+func TestCache_current(t *testing.T) {
+	ctx := context.Background()
+	db := newCounter()
+	c := New(db)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.PairRate(ctx, domain.BTCUSDT, storage.Filter{Limit: 1}); err != nil {
+			t.Fatalf("PairRate() = err: %v", err)
+		}
+		if _, err := c.FiatsCurrent(ctx, domain.BaseRUB); err != nil {
+			t.Fatalf("FiatsCurrent() = err: %v", err)
+		}
+		if _, err := c.USDRate(ctx, domain.BaseRUB); err != nil {
+			t.Fatalf("USDRate() = err: %v", err)
+		}
+	}
+	want := map[string]int{"PairRate BTC/USDT": 1, "FiatsCurrent RUB": 1, "USDRate RUB": 1}
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("calls = %v, want %v", db.calls, want)
+	}
+	if got, want := c.Stats(), (Stats{Hits: 6, Misses: 3}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// курсы других пар кэшируются отдельно
+	eth := domain.Pair{Base: "ETH", Quote: "USDT"}
+	_, _ = c.PairRate(ctx, eth, storage.Filter{Limit: 1})
+	_, _ = c.PairRate(ctx, eth, storage.Filter{Limit: 1})
+	want["PairRate ETH/USDT"]++
+	if _, err := c.AddPairRate(ctx, storage.ConflictIgnore, eth, memdb.SampleItem); err != nil {
+		t.Fatalf("AddPairRate() = err: %v", err)
+	}
+	_, _ = c.PairRate(ctx, domain.BTCUSDT, storage.Filter{Limit: 1})
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("after AddPairRate(ETH/USDT) calls = %v, want %v", db.calls, want)
+	}
+
+	// запись курса BTC сбрасывает только кэш BTC
+	if _, err := c.AddPairRate(ctx, storage.ConflictIgnore, domain.BTCUSDT, memdb.SampleItem); err != nil {
+		t.Fatalf("AddPairRate() = err: %v", err)
+	}
+	_, _ = c.PairRate(ctx, domain.BTCUSDT, storage.Filter{Limit: 1})
+	_, _ = c.FiatsCurrent(ctx, domain.BaseRUB)
+	want["PairRate BTC/USDT"]++
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("after AddPairRate calls = %v, want %v", db.calls, want)
+	}
+
+	// запись фиатных курсов сбрасывает текущие курсы валют
+	if _, err := c.AddFiats(ctx, storage.ConflictOverwrite, memdb.SampleItem); err != nil {
+		t.Fatalf("AddFiats() = err: %v", err)
+	}
+	_, _ = c.FiatsCurrent(ctx, domain.BaseRUB)
+	_, _ = c.USDRate(ctx, domain.BaseRUB)
+	want["FiatsCurrent RUB"]++
+	want["USDRate RUB"]++
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("after AddFiats calls = %v, want %v", db.calls, want)
+	}
+
+	// курсы к другой базовой валюте кэшируются отдельно
+	_, _ = c.FiatsCurrent(ctx, domain.BaseEUR)
+	_, _ = c.FiatsCurrent(ctx, domain.BaseEUR)
+	_, _ = c.FiatsCurrent(ctx, domain.BaseRUB)
+	want["FiatsCurrent EUR"]++
+	if !reflect.DeepEqual(db.calls, want) {
+		t.Errorf("after FiatsCurrent(EUR) calls = %v, want %v", db.calls, want)
+	}
+}
+
+func TestCache_history(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1658252361, 0)
+
+	t.Run("disabled", func(t *testing.T) {
+		db := newCounter()
+		c := New(db)
+		for i := 0; i < 2; i++ {
+			_, _ = c.PairRate(ctx, domain.BTCUSDT, storage.Filter{Limit: 10})
+			_, _ = c.Fiats(ctx, storage.Filter{Limit: 10})
+		}
+		if want := (map[string]int{"PairRate BTC/USDT": 2, "Fiats": 2}); !reflect.DeepEqual(db.calls, want) {
+			t.Errorf("calls = %v, want %v", db.calls, want)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		db := newCounter()
+		c := New(db, WithHistoryTTL(time.Minute))
+		c.now = func() time.Time { return now }
+
+		f := storage.Filter{Limit: 10, Currency: "USD"}
+		got, _ := c.Fiats(ctx, f)
+		// вызывающий не может изменить закэшированные данные
+		got[0].Value = 0
+		got, _ = c.Fiats(ctx, f)
+		if got[0] != memdb.SampleItem {
+			t.Errorf("Fiats() = %v, want %v", got[0], memdb.SampleItem)
+		}
+		_, _ = c.Fiats(ctx, storage.Filter{Limit: 5})
+		if db.calls["Fiats"] != 2 {
+			t.Errorf("calls = %d, want %d", db.calls["Fiats"], 2)
+		}
+
+		c.now = func() time.Time { return now.Add(time.Minute) }
+		_, _ = c.Fiats(ctx, f)
+		if db.calls["Fiats"] != 3 {
+			t.Errorf("after ttl calls = %d, want %d", db.calls["Fiats"], 3)
+		}
+	})
+}
+
+func TestCache_evict(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1658252361, 0)
+	f := func(limit int) storage.Filter { return storage.Filter{Limit: limit} }
+
+	t.Run("expired", func(t *testing.T) {
+		c := New(newCounter(), WithHistoryTTL(time.Minute))
+		c.now = func() time.Time { return now }
+		_, _ = c.Fiats(ctx, f(1))
+		_, _ = c.Fiats(ctx, f(2))
+
+		// устаревшая запись удаляется при обращении к ней,
+		// даже если запрос к БД не удался
+		c.now = func() time.Time { return now.Add(time.Minute) }
+		c.db = failing{c.db}
+		_, _ = c.Fiats(ctx, f(1))
+		if _, ok := c.fiats[f(1)]; ok {
+			t.Errorf("expired entry %v is not evicted", f(1))
+		}
+		if len(c.fiats) != 1 {
+			t.Errorf("len(fiats) = %d, want %d", len(c.fiats), 1)
+		}
+	})
+
+	t.Run("full", func(t *testing.T) {
+		c := New(newCounter(), WithHistoryTTL(time.Minute), WithMaxEntries(2))
+		c.now = func() time.Time { return now }
+		_, _ = c.Fiats(ctx, f(1))
+		c.now = func() time.Time { return now.Add(time.Second) }
+		_, _ = c.Fiats(ctx, f(2))
+
+		// заполненное отображение сначала избавляется от устаревших записей
+		c.now = func() time.Time { return now.Add(time.Minute) }
+		_, _ = c.Fiats(ctx, f(3))
+		if want := 2; len(c.fiats) != want {
+			t.Fatalf("len(fiats) = %d, want %d", len(c.fiats), want)
+		}
+		if _, ok := c.fiats[f(1)]; ok {
+			t.Errorf("expired entry %v is not evicted", f(1))
+		}
+
+		// а если устаревших нет - от давно не использованной
+		_, _ = c.Fiats(ctx, f(2))
+		_, _ = c.Fiats(ctx, f(4))
+		for _, k := range []storage.Filter{f(2), f(4)} {
+			if _, ok := c.fiats[k]; !ok {
+				t.Errorf("entry %v is evicted", k)
+			}
+		}
+		if _, ok := c.fiats[f(3)]; ok {
+			t.Errorf("least recently used entry %v is not evicted", f(3))
+		}
+	})
+}
+
+// failing - БД, запросы к которой завершаются ошибкой.
+type failing struct {
+	storage.Storage
+}
+
+func (failing) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+	return nil, context.DeadlineExceeded
+}
@@ -0,0 +1,178 @@
+// Пакет instrument представляет обертку над контрактом БД,
+// которая собирает метрики запросов и логирует медленные запросы.
+package instrument
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// DefaultSlowQuery - порог медленного запроса по умолчанию.
+const DefaultSlowQuery = 500 * time.Millisecond
+
+// options - настройки обертки.
+type options struct {
+	slow time.Duration // запросы дольше логируются, 0 - не логируются
+}
+
+// Option - функция, изменяющая настройки обертки.
+type Option func(*options)
+
+// WithSlowQuery устанавливает порог, дольше
+// которого запрос логируется как медленный.
+// 0 отключает логирование.
+func WithSlowQuery(d time.Duration) Option {
+	return func(o *options) {
+		if d >= 0 {
+			o.slow = d
+		}
+	}
+}
+
+// Storage - обертка над БД, собирающая метрики:
+// время выполнения, число ошибок и строк по методам.
+type Storage struct {
+	db     storage.Storage
+	logger *slog.Logger
+	opts   options
+
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	rows     *prometheus.CounterVec
+}
+
+// New возвращает обертку над db и регистрирует ее метрики в reg.
+func New(db storage.Storage, reg prometheus.Registerer, logger *slog.Logger, opts ...Option) *Storage {
+	s := Storage{
+		db:     db,
+		logger: logger,
+		opts:   options{slow: DefaultSlowQuery},
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "xtest_storage_query_duration_seconds",
+			Help:    "Duration of storage queries by method.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_storage_errors_total",
+			Help: "Number of failed storage queries by method.",
+		}, []string{"method"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtest_storage_rows_total",
+			Help: "Number of rows returned or written by storage queries by method.",
+		}, []string{"method"}),
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	reg.MustRegister(s.duration, s.errors, s.rows)
+	return &s
+}
+
+// observe записывает метрики запроса method, начатого в start,
+// и логирует его, если он медленный. filter - фильтр запроса, если есть,
+// attrs - другие параметры запроса для лога.
+func (s *Storage) observe(method string, start time.Time, rows int, err error, filter *storage.Filter, attrs ...any) {
+	d := time.Since(start)
+	s.duration.WithLabelValues(method).Observe(d.Seconds())
+	if err != nil {
+		s.errors.WithLabelValues(method).Inc()
+	}
+	s.rows.WithLabelValues(method).Add(float64(rows))
+
+	if s.opts.slow == 0 || d < s.opts.slow {
+		return
+	}
+	args := append([]any{"method", method, "duration", d}, attrs...)
+	if filter != nil {
+		args = append(args, "filter", *filter)
+	}
+	s.logger.Warn("slow query", args...)
+}
+
+// AddPairRate добавляет курс пары в БД.
+func (s *Storage) AddPairRate(ctx context.Context, policy storage.Conflict, pair domain.Pair, rate domain.Rate) (storage.Result, error) {
+	start := time.Now()
+	res, err := s.db.AddPairRate(ctx, policy, pair, rate)
+	s.observe("AddPairRate", start, res.Inserted+res.Updated, err, nil, "pair", pair.String())
+	return res, err
+}
+
+// AddQuotes добавляет котировки бирж в БД.
+func (s *Storage) AddQuotes(ctx context.Context, policy storage.Conflict, pair domain.Pair, quotes ...domain.Rate) (storage.Result, error) {
+	start := time.Now()
+	res, err := s.db.AddQuotes(ctx, policy, pair, quotes...)
+	s.observe("AddQuotes", start, res.Inserted+res.Updated, err, nil, "pair", pair.String())
+	return res, err
+}
+
+// Pairs возвращает известные БД пары.
+func (s *Storage) Pairs(ctx context.Context) ([]domain.Pair, error) {
+	start := time.Now()
+	pairs, err := s.db.Pairs(ctx)
+	s.observe("Pairs", start, len(pairs), err, nil)
+	return pairs, err
+}
+
+// AddFiats добавляет курсы фиатных валют в БД.
+func (s *Storage) AddFiats(ctx context.Context, policy storage.Conflict, rates ...domain.Rate) (storage.Result, error) {
+	start := time.Now()
+	res, err := s.db.AddFiats(ctx, policy, rates...)
+	s.observe("AddFiats", start, res.Inserted+res.Updated, err, nil)
+	return res, err
+}
+
+// USDRate возвращает текущий курс доллара в базовой валюте base.
+func (s *Storage) USDRate(ctx context.Context, base string) (domain.Rate, error) {
+	start := time.Now()
+	rate, err := s.db.USDRate(ctx, base)
+	rows := 1
+	if err != nil {
+		rows = 0
+	}
+	s.observe("USDRate", start, rows, err, nil, "base", base)
+	return rate, err
+}
+
+// PairRate возвращает историю курса пары.
+func (s *Storage) PairRate(ctx context.Context, pair domain.Pair, filter storage.Filter) ([]domain.Rate, error) {
+	start := time.Now()
+	rates, err := s.db.PairRate(ctx, pair, filter)
+	s.observe("PairRate", start, len(rates), err, &filter, "pair", pair.String())
+	return rates, err
+}
+
+// Fiats возвращает историю курсов фиатных валют.
+func (s *Storage) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+	start := time.Now()
+	rates, err := s.db.Fiats(ctx, filter)
+	s.observe("Fiats", start, len(rates), err, &filter)
+	return rates, err
+}
+
+// FiatsCurrent возвращает текущие курсы фиатных валют к base.
+func (s *Storage) FiatsCurrent(ctx context.Context, base string) ([]domain.Rate, error) {
+	start := time.Now()
+	rates, err := s.db.FiatsCurrent(ctx, base)
+	s.observe("FiatsCurrent", start, len(rates), err, nil, "base", base)
+	return rates, err
+}
+
+// Ping проверяет соединение с БД.
+func (s *Storage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.db.Ping(ctx)
+	s.observe("Ping", start, 0, err, nil)
+	return err
+}
+
+// Close закрывает соединение с БД.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,84 @@
+package instrument
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
+	"xtestserver/pkg/storage/memdb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var errFail = errors.New("fail")
+
+// slowDB замедляет запросы истории
+// и возвращает ошибку текущих курсов.
+type slowDB struct {
+	*memdb.MemDB
+	delay time.Duration
+}
+
+func (db *slowDB) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+	time.Sleep(db.delay)
+	return db.MemDB.Fiats(ctx, filter)
+}
+
+func (db *slowDB) FiatsCurrent(context.Context, string) ([]domain.Rate, error) {
+	return nil, errFail
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	var buf bytes.Buffer
+	s := New(&slowDB{MemDB: memdb.New(), delay: 20 * time.Millisecond}, reg,
+		slog.New(slog.NewTextHandler(&buf, nil)), WithSlowQuery(10*time.Millisecond))
+
+	if _, err := s.PairRate(ctx, domain.BTCUSDT, storage.Filter{Limit: 5}); err != nil {
+		t.Fatalf("PairRate() = err: %v", err)
+	}
+	if _, err := s.AddFiats(ctx, storage.ConflictOverwrite, memdb.SampleItem, memdb.SampleItem2); err != nil {
+		t.Fatalf("AddFiats() = err: %v", err)
+	}
+	if _, err := s.FiatsCurrent(ctx, domain.BaseRUB); !errors.Is(err, errFail) {
+		t.Fatalf("FiatsCurrent() = err: %v, want %v", err, errFail)
+	}
+	if _, err := s.Fiats(ctx, storage.Filter{Currency: "USD", Limit: 1}); err != nil {
+		t.Fatalf("Fiats() = err: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		rows   float64
+		errs   float64
+	}{
+		{"PairRate", 5, 0},
+		{"AddFiats", 2, 0},
+		{"FiatsCurrent", 0, 1},
+		{"Fiats", 1, 0},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(s.rows.WithLabelValues(tt.method)); got != tt.rows {
+			t.Errorf("rows{%s} = %v, want %v", tt.method, got, tt.rows)
+		}
+		if got := testutil.ToFloat64(s.errors.WithLabelValues(tt.method)); got != tt.errs {
+			t.Errorf("errors{%s} = %v, want %v", tt.method, got, tt.errs)
+		}
+	}
+	if got := testutil.CollectAndCount(s.duration); got != len(tests) {
+		t.Errorf("duration series = %d, want %d", got, len(tests))
+	}
+
+	// медленный только Fiats
+	logs := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(logs) != 1 || !strings.Contains(logs[0], "msg=\"slow query\" method=Fiats") || !strings.Contains(logs[0], "Currency:USD") {
+		t.Errorf("logs = %q, want one slow Fiats query with filter", logs)
+	}
+}
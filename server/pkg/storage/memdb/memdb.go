@@ -17,31 +17,44 @@ func New() *MemDB {
 	return &MemDB{}
 }
 
-func (db *MemDB) RUBUSDRate(ctx context.Context) (item, error) {
+// USDRate - возвращает SampleItem для любой базовой валюты
+func (db *MemDB) USDRate(_ context.Context, _ string) (item, error) {
 	return SampleItem, nil
 }
 
-// AddBtcRate - no-op
-func (db *MemDB) AddBtcRate(_ context.Context, _ item) error {
-	return nil
+// AddPairRate - no-op, сообщает о добавлении
+func (db *MemDB) AddPairRate(_ context.Context, _ storage.Conflict, _ domain.Pair, _ item) (storage.Result, error) {
+	return storage.Result{Inserted: 1}, nil
 }
 
-// AddFiats - no-op
-func (db *MemDB) AddFiats(_ context.Context, _ ...item) error {
-	return nil
+// AddQuotes - no-op, сообщает о добавлении
+func (db *MemDB) AddQuotes(_ context.Context, _ storage.Conflict, _ domain.Pair, items ...item) (storage.Result, error) {
+	return storage.Result{Inserted: len(items)}, nil
 }
 
-// BtcRate - возвращает столько Rate, сколько запрошено
-func (db *MemDB) BtcRate(_ context.Context, filter storage.Filter) ([]item, error) {
+// AddFiats - no-op, сообщает о добавлении
+func (db *MemDB) AddFiats(_ context.Context, _ storage.Conflict, items ...item) (storage.Result, error) {
+	return storage.Result{Inserted: len(items)}, nil
+}
+
+// PairRate - возвращает столько Rate, сколько запрошено, для любой пары
+func (db *MemDB) PairRate(_ context.Context, _ domain.Pair, filter storage.Filter) ([]item, error) {
+	sample := SampleItem
+	sample.Source = domain.SourceAggregate
 	items := make([]item, 0, filter.Limit+filter.Offset)
 	for i := 0; i < filter.Limit+filter.Offset; i++ {
-		items = append(items, SampleItem)
+		items = append(items, sample)
 	}
 	return items, nil
 }
 
-// FiatsCurrent - return exactly three items
-func (db *MemDB) FiatsCurrent(_ context.Context) ([]item, error) {
+// Pairs - возвращает только BTC/USDT
+func (db *MemDB) Pairs(_ context.Context) ([]domain.Pair, error) {
+	return []domain.Pair{domain.BTCUSDT}, nil
+}
+
+// FiatsCurrent - return exactly three items for any base
+func (db *MemDB) FiatsCurrent(_ context.Context, _ string) ([]item, error) {
 	return []item{SampleItem, SampleItem2, SampleItem3}, nil
 }
 
@@ -58,6 +71,11 @@ func (db *MemDB) Fiats(_ context.Context, filter storage.Filter) ([]item, error)
 	return items, nil
 }
 
+// Ping - no-op
+func (db *MemDB) Ping(_ context.Context) error {
+	return nil
+}
+
 // Close - no-op
 func (db *MemDB) Close() error {
 	return nil
@@ -70,6 +88,8 @@ var SampleItem = item{
 	Nominal:  1,
 	Time:     1658252361,
 	Value:    56.4783,
+	Source:   domain.SourceCBR,
+	Base:     domain.BaseRUB,
 }
 
 var SampleItem2 = item{
@@ -78,6 +98,8 @@ var SampleItem2 = item{
 	Nominal:  100,
 	Time:     1658252361,
 	Value:    14.3324,
+	Source:   domain.SourceCBR,
+	Base:     domain.BaseRUB,
 }
 var SampleItem3 = item{
 	Id:       3,
@@ -85,4 +107,6 @@ var SampleItem3 = item{
 	Nominal:  1,
 	Time:     1658252361,
 	Value:    67.7627,
+	Source:   domain.SourceCBR,
+	Base:     domain.BaseRUB,
 }
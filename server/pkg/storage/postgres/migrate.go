@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock - ключ advisory lock, под которым экземпляры
+// сервера по очереди применяют миграции.
+const migrationLock = 7305317
+
+// Migrate применяет к БД миграции из каталога migrations, которые еще
+// не применены, по порядку имен файлов. Каждая миграция выполняется
+// в своей транзакции вместе с записью о ней в schema_migrations.
+// Возвращает имена примененных миграций.
+//
+// schema.sql описывает схему после всех миграций и сразу
+// отмечает их примененными; новая миграция дописывается в него
+// вместе со своей строкой в schema_migrations.
+func (p *Postgres) Migrate(ctx context.Context) ([]string, error) {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	conn, err := p.db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return nil, fmt.Errorf("lock migrations: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at BIGINT DEFAULT extract(epoch from now())
+		);`)
+	if err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var applied []string
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		b, err := migrations.ReadFile(name)
+		if err != nil {
+			return applied, err
+		}
+
+		var done bool
+		err = conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+			tag, err := tx.Exec(ctx, `INSERT INTO schema_migrations(version) VALUES ($1) ON CONFLICT DO NOTHING`, version)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				done = true // уже применена
+				return nil
+			}
+			_, err = tx.Exec(ctx, string(b))
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", version, err)
+		}
+		if !done {
+			applied = append(applied, version)
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,11 @@
+-- исправления курсов, которые ЦБ внес задним числом;
+-- в БД, созданных из schema.sql до их появления, таблицы нет
+CREATE TABLE IF NOT EXISTS rub_corrections (
+    id BIGSERIAL PRIMARY KEY,
+    char_code VARCHAR(3),
+    time BIGINT CHECK(time > 0),
+    old_value NUMERIC(20, 4) NOT NULL,
+    new_value NUMERIC(20, 4) NOT NULL,
+    corrected_at BIGINT DEFAULT extract(epoch from now()),
+    FOREIGN KEY (char_code) REFERENCES fiats(char_code)
+);
@@ -0,0 +1,2 @@
+-- последний курс каждой валюты (FiatsCurrent)
+CREATE INDEX IF NOT EXISTS rub_code_time_idx ON rub(char_code, time DESC);
@@ -0,0 +1,12 @@
+-- котировки отдельных бирж, из которых
+-- рассчитывается опорный курс в btc_usdt
+CREATE TABLE IF NOT EXISTS btc_quotes (
+    id BIGSERIAL PRIMARY KEY,
+    source VARCHAR(32) NOT NULL,
+    time BIGINT CHECK(time > 0),
+    value NUMERIC(20, 4) NOT NULL,
+    volume NUMERIC(28, 8) NOT NULL DEFAULT 0,
+    UNIQUE(source, time)
+);
+
+CREATE INDEX IF NOT EXISTS btc_quotes_time_idx ON btc_quotes(time DESC);
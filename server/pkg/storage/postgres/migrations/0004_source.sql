@@ -0,0 +1,6 @@
+-- источник курса: биржа, aggregate или ЦБ;
+-- у курсов, записанных до миграции, источник неизвестен ('')
+ALTER TABLE btc_usdt ADD COLUMN IF NOT EXISTS source VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE rub ADD COLUMN IF NOT EXISTS source VARCHAR(32) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS btc_source_time_idx ON btc_usdt(source, time DESC);
@@ -0,0 +1,46 @@
+-- курсы любых пар вместо одной BTC/USDT: btc_usdt переносится
+-- в pair_rates, btc_quotes - в quotes с парой BTC/USDT
+CREATE TABLE IF NOT EXISTS pairs (
+    id SERIAL PRIMARY KEY,
+    base VARCHAR(10) NOT NULL,
+    quote VARCHAR(10) NOT NULL,
+    UNIQUE(base, quote)
+);
+
+INSERT INTO pairs(base, quote) VALUES ('BTC', 'USDT') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS pair_rates (
+    id BIGSERIAL PRIMARY KEY,
+    pair_id INT NOT NULL REFERENCES pairs(id),
+    time BIGINT CHECK(time > 0) DEFAULT extract(epoch from now()),
+    value NUMERIC(28, 8) NOT NULL,
+    source VARCHAR(32) NOT NULL DEFAULT '',
+    UNIQUE(pair_id, time)
+);
+
+CREATE TABLE IF NOT EXISTS quotes (
+    id BIGSERIAL PRIMARY KEY,
+    pair_id INT NOT NULL REFERENCES pairs(id),
+    source VARCHAR(32) NOT NULL,
+    time BIGINT CHECK(time > 0),
+    value NUMERIC(28, 8) NOT NULL,
+    volume NUMERIC(28, 8) NOT NULL DEFAULT 0,
+    UNIQUE(pair_id, source, time)
+);
+
+-- порядок id сохраняется: история курса отдается по убыванию id
+INSERT INTO pair_rates(pair_id, time, value, source)
+SELECT pairs.id, btc.time, btc.value, btc.source
+FROM btc_usdt as btc, pairs WHERE pairs.base = 'BTC' AND pairs.quote = 'USDT'
+ORDER BY btc.id;
+
+INSERT INTO quotes(pair_id, source, time, value, volume)
+SELECT pairs.id, q.source, q.time, q.value, q.volume
+FROM btc_quotes as q, pairs WHERE pairs.base = 'BTC' AND pairs.quote = 'USDT'
+ORDER BY q.id;
+
+DROP TABLE btc_usdt, btc_quotes;
+
+CREATE INDEX IF NOT EXISTS pair_rates_time_idx ON pair_rates(pair_id, time DESC);
+CREATE INDEX IF NOT EXISTS pair_rates_source_time_idx ON pair_rates(pair_id, source, time DESC);
+CREATE INDEX IF NOT EXISTS quotes_time_idx ON quotes(pair_id, time DESC);
@@ -0,0 +1,32 @@
+-- курсы фиатных валют не только к рублю (ЦБ), но и к евро (ЕЦБ):
+-- у каждой базовой валюты свои номиналы и курсы на одну дату;
+-- курсы, записанные до миграции, - к рублю.
+-- rub и rub_corrections (ее создает 0001_rub_corrections) хранят
+-- курсы любой базы, поэтому становятся fiat_rates и fiat_corrections
+-- с теми же именами ограничений, что и в schema.sql
+ALTER TABLE rub RENAME TO fiat_rates;
+ALTER SEQUENCE rub_id_seq RENAME TO fiat_rates_id_seq;
+ALTER TABLE fiat_rates RENAME CONSTRAINT rub_pkey TO fiat_rates_pkey;
+ALTER TABLE fiat_rates RENAME CONSTRAINT rub_time_check TO fiat_rates_time_check;
+ALTER TABLE fiat_rates DROP CONSTRAINT IF EXISTS rub_char_code_fkey;
+ALTER TABLE fiat_rates DROP CONSTRAINT IF EXISTS rub_char_code_time_key;
+ALTER INDEX rub_time_idx RENAME TO fiat_rates_time_idx;
+DROP INDEX IF EXISTS rub_code_time_idx;
+
+ALTER TABLE rub_corrections RENAME TO fiat_corrections;
+ALTER SEQUENCE rub_corrections_id_seq RENAME TO fiat_corrections_id_seq;
+ALTER TABLE fiat_corrections RENAME CONSTRAINT rub_corrections_pkey TO fiat_corrections_pkey;
+ALTER TABLE fiat_corrections RENAME CONSTRAINT rub_corrections_time_check TO fiat_corrections_time_check;
+ALTER TABLE fiat_corrections DROP CONSTRAINT IF EXISTS rub_corrections_char_code_fkey;
+
+ALTER TABLE fiats ADD COLUMN IF NOT EXISTS base VARCHAR(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE fiats DROP CONSTRAINT fiats_pkey, ADD PRIMARY KEY(base, char_code);
+
+ALTER TABLE fiat_rates ADD COLUMN IF NOT EXISTS base VARCHAR(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE fiat_rates ADD UNIQUE(base, char_code, time),
+    ADD FOREIGN KEY (base, char_code) REFERENCES fiats(base, char_code);
+
+ALTER TABLE fiat_corrections ADD COLUMN IF NOT EXISTS base VARCHAR(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE fiat_corrections ADD FOREIGN KEY (base, char_code) REFERENCES fiats(base, char_code);
+
+CREATE INDEX IF NOT EXISTS fiat_rates_code_time_idx ON fiat_rates(base, char_code, time DESC);
@@ -3,16 +3,22 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"xtestserver/domain"
 	"xtestserver/pkg/storage"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 var ErrNoRows = pgx.ErrNoRows
 
+// uniqueViolation - код ошибки Postgres при нарушении уникальности.
+const uniqueViolation = "23505"
+
 // Postgres выполняет CRUD операции с БД
 type Postgres struct {
 	db *pgxpool.Pool
@@ -36,69 +42,272 @@ func (p *Postgres) Close() error {
 	return nil
 }
 
+// Ping проверяет соединение с БД
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
 type statement struct {
 	sql  string
 	args []any
 }
 
-// AddBtcRate добавляет в БД текущий курс BTC/USD.
-func (p *Postgres) AddBtcRate(ctx context.Context, rate domain.Rate) error {
-	sql := `INSERT INTO btc_usdt(time, value) VALUES ($1, $2);`
-	return p.exec(ctx, sql, rate.Time, rate.Value)
+// AddPairRate добавляет в БД текущий курс пары.
+func (p *Postgres) AddPairRate(ctx context.Context, policy storage.Conflict, pair domain.Pair, rate domain.Rate) (storage.Result, error) {
+	var res storage.Result
+	err := p.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		id, err := pairID(ctx, tx, pair)
+		if err != nil {
+			return err
+		}
+		res, err = upsert(ctx, tx, policy, upsertStmt{
+			insert: `INSERT INTO pair_rates(pair_id, time, value, source) VALUES ($1, $2, $3, $4)`,
+			update: `
+				UPDATE pair_rates SET value = $3, source = $4
+				WHERE pair_id = $1 AND time = $2 AND value <> $3::NUMERIC(28, 8)`,
+			args: []any{id, rate.Time, rate.Value, rate.Source},
+		})
+		return err
+	})
+	if err != nil {
+		return storage.Result{}, err
+	}
+	return res, nil
 }
 
-// AddFiats добавляет в БД текущий курс фиатных валют.
-func (p *Postgres) AddFiats(ctx context.Context, rates ...domain.Rate) error {
+// AddQuotes добавляет в БД котировки пары на отдельных биржах.
+func (p *Postgres) AddQuotes(ctx context.Context, policy storage.Conflict, pair domain.Pair, quotes ...domain.Rate) (storage.Result, error) {
+	var total storage.Result
+	err := p.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		id, err := pairID(ctx, tx, pair)
+		if err != nil {
+			return err
+		}
+		for i := range quotes {
+			res, err := upsert(ctx, tx, policy, upsertStmt{
+				insert: `INSERT INTO quotes(pair_id, source, time, value, volume) VALUES ($1, $2, $3, $4, $5)`,
+				update: `
+					UPDATE quotes SET value = $4, volume = $5
+					WHERE pair_id = $1 AND source = $2 AND time = $3
+						AND (value <> $4::NUMERIC(28, 8) OR volume <> $5::NUMERIC(28, 8))`,
+				args: []any{id, quotes[i].Source, quotes[i].Time, quotes[i].Value, quotes[i].Volume},
+			})
+			if err != nil {
+				return err
+			}
+			total.Add(res)
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.Result{}, err
+	}
+	return total, nil
+}
 
-	sql := `
-			INSERT INTO fiats(char_code, nominal) 
-			VALUES ($1, $2)
-			ON CONFLICT(char_code) DO NOTHING;`
+// pairID возвращает id пары, добавляя в таблицу pairs новую.
+func pairID(ctx context.Context, tx pgx.Tx, pair domain.Pair) (int, error) {
+	_, err := tx.Exec(ctx, `INSERT INTO pairs(base, quote) VALUES ($1, $2) ON CONFLICT DO NOTHING`, pair.Base, pair.Quote)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = tx.QueryRow(ctx, `SELECT id FROM pairs WHERE base = $1 AND quote = $2`, pair.Base, pair.Quote).Scan(&id)
+	return id, err
+}
 
-	stmts := make([]statement, 0, len(rates)*2)
+// Pairs возвращает пары, курсы которых есть в БД, в порядке добавления.
+func (p *Postgres) Pairs(ctx context.Context) ([]domain.Pair, error) {
+	rows, err := p.db.Query(ctx, `SELECT base, quote FROM pairs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []domain.Pair
+	for rows.Next() {
+		var pair domain.Pair
+		if err := rows.Scan(&pair.Base, &pair.Quote); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
 
-	for i := range rates {
-		stmts = append(stmts, statement{sql: sql,
-			args: []any{rates[i].CharCode, rates[i].Nominal}})
+// AddFiats добавляет в БД текущий курс фиатных валют
+// к их базовой валюте (без нее - к рублю).
+// При перезаписи изменившиеся значения сохраняются
+// в таблице исправлений fiat_corrections.
+func (p *Postgres) AddFiats(ctx context.Context, policy storage.Conflict, rates ...domain.Rate) (storage.Result, error) {
+
+	nominal := `
+			INSERT INTO fiats(base, char_code, nominal) 
+			VALUES ($1, $2, $3)
+			ON CONFLICT(base, char_code) DO NOTHING;`
+	if policy == storage.ConflictOverwrite {
+		nominal = `
+			INSERT INTO fiats(base, char_code, nominal) 
+			VALUES ($1, $2, $3)
+			ON CONFLICT(base, char_code) DO UPDATE SET nominal = EXCLUDED.nominal;`
 	}
+	// курсы ЦБ записывались без базовой валюты
+	base := func(r domain.Rate) string {
+		if r.Base == "" {
+			return domain.BaseRUB
+		}
+		return r.Base
+	}
+
+	var total storage.Result
+	err := p.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		for i := range rates {
+			_, err := tx.Exec(ctx, nominal, base(rates[i]), rates[i].CharCode, rates[i].Nominal)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := range rates {
+			res, err := upsert(ctx, tx, policy, upsertStmt{
+				insert: `INSERT INTO fiat_rates(base, char_code, time, value, source) VALUES ($1, $2, $3, $4, $5)`,
+				update: `
+					UPDATE fiat_rates as r SET value = $4, source = $5
+					FROM (SELECT value FROM fiat_rates WHERE base = $1 AND char_code = $2 AND time = $3 FOR UPDATE) as old
+					WHERE r.base = $1 AND r.char_code = $2 AND r.time = $3 AND r.value <> $4::NUMERIC(20, 4)
+					RETURNING old.value`,
+				correction: `
+					INSERT INTO fiat_corrections(base, char_code, time, new_value, old_value)
+					VALUES ($1, $2, $3, $4, $5);`,
+				args:     []any{base(rates[i]), rates[i].CharCode, rates[i].Time, rates[i].Value, rates[i].Source},
+				corrArgs: []any{base(rates[i]), rates[i].CharCode, rates[i].Time, rates[i].Value},
+			})
+			if err != nil {
+				return err
+			}
+			total.Add(res)
+		}
+		return nil
+	})
+	if err != nil {
+		return storage.Result{}, err
+	}
+	return total, nil
+}
 
-	sql = `INSERT INTO rub(char_code, time, value) VALUES ($1, $2, $3);`
-	for i := range rates {
-		stmts = append(stmts, statement{sql: sql,
-			args: []any{rates[i].CharCode, rates[i].Time, rates[i].Value}})
+// upsertStmt - запросы добавления одной записи.
+type upsertStmt struct {
+	insert     string // INSERT без ON CONFLICT
+	update     string // UPDATE изменившегося значения, аргументы те же
+	correction string // сохраняет исправление
+	args       []any
+	// аргументы correction, к ним последним
+	// добавляется старое значение
+	corrArgs []any
+}
+
+// upsert добавляет одну запись в транзакции
+// согласно политике обработки конфликтов.
+func upsert(ctx context.Context, tx pgx.Tx, policy storage.Conflict, stmt upsertStmt) (storage.Result, error) {
+	switch policy {
+	case storage.ConflictError:
+		_, err := tx.Exec(ctx, stmt.insert, stmt.args...)
+		if isUniqueViolation(err) {
+			return storage.Result{}, fmt.Errorf("%w: %v", storage.ErrConflict, stmt.args)
+		}
+		if err != nil {
+			return storage.Result{}, err
+		}
+		return storage.Result{Inserted: 1}, nil
+
+	case storage.ConflictOverwrite:
+		tag, err := tx.Exec(ctx, stmt.insert+" ON CONFLICT DO NOTHING", stmt.args...)
+		if err != nil {
+			return storage.Result{}, err
+		}
+		if tag.RowsAffected() == 1 {
+			return storage.Result{Inserted: 1}, nil
+		}
+		if stmt.correction == "" {
+			tag, err = tx.Exec(ctx, stmt.update, stmt.args...)
+			if err != nil {
+				return storage.Result{}, err
+			}
+			if tag.RowsAffected() == 0 {
+				return storage.Result{Skipped: 1}, nil // значение не изменилось
+			}
+			return storage.Result{Updated: 1}, nil
+		}
+		var old float64
+		err = tx.QueryRow(ctx, stmt.update, stmt.args...).Scan(&old)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.Result{Skipped: 1}, nil // значение не изменилось
+		}
+		if err != nil {
+			return storage.Result{}, err
+		}
+		_, err = tx.Exec(ctx, stmt.correction, append(stmt.corrArgs, old)...)
+		if err != nil {
+			return storage.Result{}, err
+		}
+		return storage.Result{Updated: 1}, nil
+
+	default: // storage.ConflictIgnore
+		tag, err := tx.Exec(ctx, stmt.insert+" ON CONFLICT DO NOTHING", stmt.args...)
+		if err != nil {
+			return storage.Result{}, err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.Result{Skipped: 1}, nil
+		}
+		return storage.Result{Inserted: 1}, nil
 	}
+}
 
-	return p.execBatch(ctx, stmts...)
+// isUniqueViolation - нарушено ли ограничение уникальности?
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
 }
 
-// BtcRate возвращает курс BTC/USDT. Накладывает на результаты
-// фильтры по дате и пагинации, если есть.
-func (p *Postgres) BtcRate(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
+// PairRate возвращает курс пары. Накладывает на результаты
+// фильтры по дате, источнику и пагинации, если есть.
+func (p *Postgres) PairRate(ctx context.Context, pair domain.Pair, filter storage.Filter) ([]domain.Rate, error) {
 	var stmt statement
+	// arg добавляет аргумент запроса и возвращает его номер
+	arg := func(v any) string {
+		stmt.args = append(stmt.args, v)
+		return fmt.Sprintf("$%d", len(stmt.args))
+	}
+
+	id := fmt.Sprintf("(SELECT id FROM pairs WHERE base = %s AND quote = %s)", arg(pair.Base), arg(pair.Quote))
 	var limit string
 	if filter.Limit > 0 {
-		limit = "LIMIT $1"
-		stmt.args = append(stmt.args, filter.Limit)
+		limit = "LIMIT " + arg(filter.Limit)
+	}
+	var source string
+	if filter.Source != "" {
+		source = "AND pr.source = " + arg(filter.Source)
 	}
 	if filter.Time > 0 {
+		time := arg(filter.Time)
 		stmt.sql = fmt.Sprintf(`
-		SELECT btc.id, btc.time, btc.value
-		FROM btc_usdt as btc 
-		JOIN (SELECT btc_usdt.id FROM btc_usdt WHERE btc_usdt.time %s $%d %s OFFSET $%d) 
-		as btc_offset ON btc.id = btc_offset.id ORDER BY btc.id DESC;`,
-			filter.Operator, len(stmt.args)+1, limit, len(stmt.args)+2)
-
-		stmt.args = append(stmt.args, filter.Time, filter.Offset)
+		SELECT r.id, r.time, r.value, r.source
+		FROM pair_rates as r
+		JOIN (SELECT pr.id FROM pair_rates as pr WHERE pr.pair_id = %s AND pr.time %s %s %s
+			ORDER BY pr.id DESC %s OFFSET %s)
+		as r_offset ON r.id = r_offset.id ORDER BY r.id DESC;`,
+			id, filter.Operator, time, source, limit, arg(filter.Offset))
 	} else {
 		stmt.sql = fmt.Sprintf(`
-		SELECT btc.id, btc.time, btc.value FROM btc_usdt as btc
-		ORDER BY btc.id DESC %s OFFSET $%d;`, limit, len(stmt.args)+1)
-		stmt.args = append(stmt.args, filter.Offset)
+		SELECT pr.id, pr.time, pr.value, pr.source FROM pair_rates as pr
+		WHERE pr.pair_id = %s %s
+		ORDER BY pr.id DESC %s OFFSET %s;`, id, source, limit, arg(filter.Offset))
 	}
-	return p.btcrate(ctx, stmt.sql, stmt.args...)
+	return p.pairrate(ctx, stmt.sql, stmt.args...)
 }
 
-func (p *Postgres) btcrate(ctx context.Context, sql string, args ...any) ([]domain.Rate, error) {
+func (p *Postgres) pairrate(ctx context.Context, sql string, args ...any) ([]domain.Rate, error) {
 
 	var rates []domain.Rate
 
@@ -112,7 +321,7 @@ func (p *Postgres) btcrate(ctx context.Context, sql string, args ...any) ([]doma
 
 		var rate domain.Rate
 
-		err := rows.Scan(&rate.Id, &rate.Time, &rate.Value)
+		err := rows.Scan(&rate.Id, &rate.Time, &rate.Value, &rate.Source)
 		if err != nil {
 			return nil, err
 		}
@@ -123,52 +332,57 @@ func (p *Postgres) btcrate(ctx context.Context, sql string, args ...any) ([]doma
 	return rates, rows.Err()
 }
 
-// FiatsCurrent возвращает текущий курс к рублю
-// всех фиатных валют.
-func (p *Postgres) FiatsCurrent(ctx context.Context) ([]domain.Rate, error) {
+// FiatsCurrent возвращает последний известный курс к базовой
+// валюте base каждой фиатной валюты; Time - дата, на которую он действует.
+func (p *Postgres) FiatsCurrent(ctx context.Context, base string) ([]domain.Rate, error) {
 	sql := `
-		SELECT rub.id, fiats.char_code, fiats.nominal, rub.time, rub.value	
-		FROM rub JOIN (SELECT rub.id FROM rub LIMIT (SELECT COUNT(fiats.char_code) FROM fiats)) 
-		as rub_offset ON rub.id = rub_offset.id
-		JOIN fiats ON rub.char_code = fiats.char_code;`
-	return p.fiats(ctx, sql)
+		SELECT r.id, fiats.char_code, fiats.nominal, r.time, r.value, r.source, r.base
+		FROM (SELECT DISTINCT ON (fr.char_code) fr.id, fr.base, fr.char_code, fr.time, fr.value, fr.source
+			FROM fiat_rates as fr WHERE fr.base = $1 ORDER BY fr.char_code, fr.time DESC) as r
+		JOIN fiats ON r.base = fiats.base AND r.char_code = fiats.char_code
+		ORDER BY r.id;`
+	return p.fiats(ctx, sql, base)
 }
 
-// Fiats возвращает отфильтрованное по дате, валюте
-// кол-во из таблицы курса фиатных валют.
+// Fiats возвращает отфильтрованное по дате, валюте, источнику,
+// базовой валюте кол-во из таблицы курса фиатных валют,
+// упорядоченное по времени и коду валюты.
 func (p *Postgres) Fiats(ctx context.Context, filter storage.Filter) ([]domain.Rate, error) {
 	var stmt statement
-	stmt.sql = `
-		SELECT rub.id, fiats.char_code, fiats.nominal, rub.time, rub.value	
-		FROM rub JOIN fiats ON rub.char_code = fiats.char_code
-	`
+	// arg добавляет аргумент запроса и возвращает его номер
+	arg := func(v any) string {
+		stmt.args = append(stmt.args, v)
+		return fmt.Sprintf("$%d", len(stmt.args))
+	}
 
+	var where []string
 	if filter.Time > 0 {
-		stmt.args = append(stmt.args, filter.Time)
-		stmt.sql = fmt.Sprintf(`
-		SELECT rub.id, fiats.char_code, fiats.nominal, rub.time, rub.value	
-		FROM rub JOIN (SELECT rub.id FROM rub WHERE rub.time %s $1) 
-		as rub_offset ON rub.id = rub_offset.id
-		JOIN fiats ON rub.char_code = fiats.char_code`, filter.Operator)
-		if filter.Currency != "" {
-			stmt.sql += " WHERE fiats.char_code = $2"
-			stmt.args = append(stmt.args, filter.Currency)
-		}
-	}
-	if filter.Currency != "" && filter.Time == 0 {
-		stmt.args = append(stmt.args, filter.Currency)
-		stmt.sql = `
-			SELECT rub.id, fiats.char_code, fiats.nominal, rub.time, rub.value	
-			FROM (SELECT fiats.char_code, fiats.nominal FROM fiats WHERE fiats.char_code = $1)
-			as fiats JOIN rub ON rub.char_code = fiats.char_code`
+		where = append(where, fmt.Sprintf("r.time %s %s", filter.Operator, arg(filter.Time)))
+	}
+	if filter.Currency != "" {
+		where = append(where, "r.char_code = "+arg(filter.Currency))
+	}
+	if filter.Source != "" {
+		where = append(where, "r.source = "+arg(filter.Source))
+	}
+	if filter.Base != "" {
+		where = append(where, "r.base = "+arg(filter.Base))
 	}
+
+	stmt.sql = `
+		SELECT r.id, fiats.char_code, fiats.nominal, r.time, r.value, r.source, r.base
+		FROM fiat_rates as r JOIN fiats ON r.base = fiats.base AND r.char_code = fiats.char_code`
+	if len(where) > 0 {
+		stmt.sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	// история группируется по времени, а LIMIT и OFFSET
+	// без порядка возвращают произвольные строки
+	stmt.sql += " ORDER BY r.time, r.char_code"
 	if filter.Limit > 0 {
-		stmt.sql = fmt.Sprintf("%s LIMIT $%d", stmt.sql, len(stmt.args)+1)
-		stmt.args = append(stmt.args, filter.Limit)
+		stmt.sql += " LIMIT " + arg(filter.Limit)
 	}
 	if filter.Offset > 0 {
-		stmt.sql = fmt.Sprintf("%s OFFSET $%d", stmt.sql, len(stmt.args)+1)
-		stmt.args = append(stmt.args, filter.Offset)
+		stmt.sql += " OFFSET " + arg(filter.Offset)
 	}
 
 	return p.fiats(ctx, stmt.sql, stmt.args...)
@@ -185,7 +399,7 @@ func (p *Postgres) fiats(ctx context.Context, sql string, args ...any) ([]domain
 	var rates []domain.Rate
 	for rows.Next() {
 		var rate domain.Rate
-		err := rows.Scan(&rate.Id, &rate.CharCode, &rate.Nominal, &rate.Time, &rate.Value)
+		err := rows.Scan(&rate.Id, &rate.CharCode, &rate.Nominal, &rate.Time, &rate.Value, &rate.Source, &rate.Base)
 		if err != nil {
 			return nil, err
 		}
@@ -195,16 +409,20 @@ func (p *Postgres) fiats(ctx context.Context, sql string, args ...any) ([]domain
 	return rates, rows.Err()
 }
 
-// RUBUSDRate возвращает текущий курск доллара
-func (p *Postgres) RUBUSDRate(ctx context.Context) (domain.Rate, error) {
+// USDRate возвращает текущий курс доллара в базовой валюте base.
+func (p *Postgres) USDRate(ctx context.Context, base string) (domain.Rate, error) {
 	sql := `
-		SELECT rub.id, fiats.char_code, fiats.nominal, rub.time, rub.value
-		FROM rub JOIN fiats ON rub.char_code = fiats.char_code 
-		WHERE rub.char_code = 'USD' ORDER BY rub.time DESC LIMIT 1;`
+		SELECT r.id, fiats.char_code, fiats.nominal, r.time, r.value, r.source, r.base
+		FROM fiat_rates as r JOIN fiats ON r.base = fiats.base AND r.char_code = fiats.char_code
+		WHERE r.base = $1 AND r.char_code = 'USD' ORDER BY r.time DESC LIMIT 1;`
 
 	var rate domain.Rate
-	return rate, p.db.QueryRow(ctx, sql).
-		Scan(&rate.Id, &rate.CharCode, &rate.Nominal, &rate.Time, &rate.Value)
+	err := p.db.QueryRow(ctx, sql, base).
+		Scan(&rate.Id, &rate.CharCode, &rate.Nominal, &rate.Time, &rate.Value, &rate.Source, &rate.Base)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rate, fmt.Errorf("USD/%s: %w", base, storage.ErrNotFound)
+	}
+	return rate, err
 }
 
 // exec вспомогательная функция, выполняет
@@ -225,19 +443,3 @@ func (p *Postgres) exec(ctx context.Context, sql string, args ...any) error {
 
 	return tx.Commit(ctx)
 }
-
-// execBatch вспомогательная функция, выполняет
-// множественные запросы *pgx.conn.Exec()
-// в одной транзакции. Эти запросы не возвращают
-// результатов (INSERT, UPDATE, DELETE).
-func (p *Postgres) execBatch(ctx context.Context, stmts ...statement) error {
-
-	b := new(pgx.Batch)
-	for i := range stmts {
-		b.Queue(stmts[i].sql, stmts[i].args...)
-	}
-
-	return p.db.BeginFunc(ctx, func(tx pgx.Tx) error {
-		return tx.SendBatch(ctx, b).Close()
-	})
-}
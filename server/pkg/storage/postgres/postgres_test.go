@@ -2,10 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"xtestserver/domain"
 	"xtestserver/pkg/storage"
@@ -61,59 +66,128 @@ func TestPostgres(t *testing.T) {
 		t.Skip("no connection to test database, skipped...")
 	}
 
-	t.Run("AddBtcRate", func(t *testing.T) {
+	t.Run("Migrate", func(t *testing.T) {
+		// testdata/t.sql - актуальная схема,
+		// все миграции в ней уже отмечены
+		got, err := tdb.Migrate(context.Background())
+		if err != nil || len(got) != 0 {
+			t.Errorf("Migrate() = %v, %v, want nothing", got, err)
+		}
+	})
+
+	t.Run("AddPairRate", func(t *testing.T) {
 		want := testBtcRate3
 
-		err := tdb.AddBtcRate(context.Background(), want)
+		_, err := tdb.AddPairRate(context.Background(), storage.ConflictError, domain.BTCUSDT, want)
 		if err != nil {
-			t.Fatalf("AddBtcRate() = error: %v", err)
+			t.Fatalf("AddPairRate() = error: %v", err)
 		}
 
-		got, err := tdb.BtcRate(context.Background(), storage.Filter{Limit: 1})
+		got, err := tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Limit: 1})
 		if err != nil {
-			t.Fatalf("BtcRate() = error: %v", err)
+			t.Fatalf("PairRate() = error: %v", err)
 		}
 
 		if len(got) != 1 {
-			t.Fatalf("BtcRate() rows = %d, want %d", len(got), 1)
+			t.Fatalf("PairRate() rows = %d, want %d", len(got), 1)
 		}
 
 		if got[0] != want {
-			t.Errorf("AddBtcRate() = %#v, want %#v", got, want)
+			t.Errorf("AddPairRate() = %#v, want %#v", got, want)
 		}
 
 	})
 
-	t.Run("BtcRate", func(t *testing.T) {
+	t.Run("PairRate", func(t *testing.T) {
 		want := testBtcRate1
 
-		got, err := tdb.BtcRate(context.Background(), storage.Filter{Limit: 1, Offset: 2})
+		got, err := tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Limit: 1, Offset: 2})
 		if err != nil {
-			t.Fatalf("BtcRate() = error: %v", err)
+			t.Fatalf("PairRate() = error: %v", err)
 		}
 
 		if len(got) != 1 {
-			t.Fatalf("BtcRate() rows = %d, want %d", len(got), 1)
+			t.Fatalf("PairRate() rows = %d, want %d", len(got), 1)
 		}
 
 		if got[0] != want {
-			t.Errorf("BtcRate() = %#v, want %#v", got, want)
+			t.Errorf("PairRate() = %#v, want %#v", got, want)
 		}
 	})
 
-	t.Run("BtcRate_time_filter", func(t *testing.T) {
+	t.Run("PairRate_time_filter", func(t *testing.T) {
 		want := testBtcRate1
-		got, err := tdb.BtcRate(context.Background(), storage.Filter{Limit: 1, Offset: 0, Time: 1658252361, Operator: "<="})
+		got, err := tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Limit: 1, Offset: 0, Time: 1658252361, Operator: "<="})
 		if err != nil {
-			t.Fatalf("BtcRate() = error: %v", err)
+			t.Fatalf("PairRate() = error: %v", err)
 		}
 
 		if len(got) != 1 {
-			t.Fatalf("BtcRate() rows = %d, want %d", len(got), 1)
+			t.Fatalf("PairRate() rows = %d, want %d", len(got), 1)
 		}
 
 		if got[0] != want {
-			t.Errorf("BtcRate() = %#v, want %#v", got, want)
+			t.Errorf("PairRate() = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("PairRate_source_filter", func(t *testing.T) {
+		got, err := tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Source: domain.SourceKucoin})
+		if err != nil {
+			t.Fatalf("PairRate() = error: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("PairRate() rows = %d, want %d", len(got), 2)
+		}
+
+		got, err = tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Source: domain.SourceAggregate, Time: 1658252361, Operator: ">="})
+		if err != nil {
+			t.Fatalf("PairRate() = error: %v", err)
+		}
+		if len(got) != 1 || got[0] != testBtcRate3 {
+			t.Errorf("PairRate() = %#v, want %#v", got, testBtcRate3)
+		}
+	})
+
+	t.Run("AddPairRate_new_pair", func(t *testing.T) {
+		eth := domain.Pair{Base: "ETH", Quote: "USDT"}
+		rate := domain.Rate{Time: 1658252363, Value: 1543.12345678, Source: domain.SourceAggregate}
+		res, err := tdb.AddPairRate(context.Background(), storage.ConflictIgnore, eth, rate)
+		if err != nil {
+			t.Fatalf("AddPairRate() = error: %v", err)
+		}
+		if want := (storage.Result{Inserted: 1}); res != want {
+			t.Errorf("AddPairRate() = %+v, want %+v", res, want)
+		}
+
+		got, err := tdb.PairRate(context.Background(), eth, storage.Filter{})
+		if err != nil {
+			t.Fatalf("PairRate() = error: %v", err)
+		}
+		if len(got) != 1 || got[0].Value != rate.Value || got[0].Time != rate.Time {
+			t.Errorf("PairRate() = %#v, want %#v", got, rate)
+		}
+
+		// курсы BTC/USDT не смешиваются с курсами новой пары
+		got, err = tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Time: rate.Time, Operator: "="})
+		if err != nil {
+			t.Fatalf("PairRate() = error: %v", err)
+		}
+		if len(got) != 1 || got[0] != testBtcRate3 {
+			t.Errorf("PairRate() = %#v, want %#v", got, testBtcRate3)
+		}
+
+		pairs, err := tdb.Pairs(context.Background())
+		if err != nil {
+			t.Fatalf("Pairs() = error: %v", err)
+		}
+		if want := []domain.Pair{domain.BTCUSDT, eth}; !reflect.DeepEqual(pairs, want) {
+			t.Errorf("Pairs() = %v, want %v", pairs, want)
+		}
+
+		got, err = tdb.PairRate(context.Background(), domain.Pair{Base: "SOL", Quote: "USDT"}, storage.Filter{Limit: 1})
+		if err != nil || len(got) != 0 {
+			t.Errorf("PairRate() unknown pair = %v, %v, want nothing", got, err)
 		}
 	})
 
@@ -121,12 +195,12 @@ func TestPostgres(t *testing.T) {
 		wantFiats := []domain.Rate{
 			testFiatRate1, testFiatRate2, testFiatRate3, testFiatRate4}
 
-		err := tdb.AddFiats(context.Background(), []domain.Rate{testFiatRate3, testFiatRate4}...)
+		_, err := tdb.AddFiats(context.Background(), storage.ConflictError, []domain.Rate{testFiatRate3, testFiatRate4}...)
 		if err != nil {
 			t.Fatalf("AddFiats() = error: %v", err)
 		}
 
-		gotFiats, err := tdb.FiatsCurrent(context.Background())
+		gotFiats, err := tdb.FiatsCurrent(context.Background(), domain.BaseRUB)
 		if err != nil {
 			t.Fatalf("FiatsCurrent() = error: %v", err)
 		}
@@ -162,8 +236,9 @@ func TestPostgres(t *testing.T) {
 	})
 
 	t.Run("Fiats_time_filter", func(t *testing.T) {
+		// курсы за одно время упорядочены по коду валюты
 		wantFiats := []domain.Rate{
-			testFiatRate1, testFiatRate2}
+			testFiatRate2, testFiatRate1}
 
 		gotFiats, err := tdb.Fiats(context.Background(), storage.Filter{Limit: 2, Time: 1658252361, Operator: "<="})
 		if err != nil {
@@ -182,6 +257,16 @@ func TestPostgres(t *testing.T) {
 
 	})
 
+	t.Run("Fiats_source_filter", func(t *testing.T) {
+		got, err := tdb.Fiats(context.Background(), storage.Filter{Source: domain.SourceCBR, Currency: "HUF"})
+		if err != nil {
+			t.Fatalf("Fiats() = error: %v", err)
+		}
+		if len(got) != 1 || got[0] != testFiatRate2 {
+			t.Errorf("Fiats() = %#v, want %#v", got, testFiatRate2)
+		}
+	})
+
 	t.Run("Fiats_time_currency_filter", func(t *testing.T) {
 		want := testFiatRate2
 
@@ -200,26 +285,222 @@ func TestPostgres(t *testing.T) {
 
 	})
 
-	t.Run("RUBUSDRate", func(t *testing.T) {
+	t.Run("USDRate", func(t *testing.T) {
 		want := testFiatRate1
 
-		got, err := tdb.RUBUSDRate(context.Background())
+		got, err := tdb.USDRate(context.Background(), domain.BaseRUB)
 		if err != nil {
-			t.Fatalf("RUBUSDRate() = error: %v", err)
+			t.Fatalf("USDRate() = error: %v", err)
 		}
 
 		if got != want {
-			t.Errorf("RUBUSDRate() = %#v, want %#v", got, want)
+			t.Errorf("USDRate() = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("AddPairRate_conflict", func(t *testing.T) {
+		res, err := tdb.AddPairRate(context.Background(), storage.ConflictIgnore, domain.BTCUSDT, testBtcRate3)
+		if err != nil {
+			t.Fatalf("AddPairRate() = error: %v", err)
+		}
+		if want := (storage.Result{Skipped: 1}); res != want {
+			t.Errorf("AddPairRate() = %+v, want %+v", res, want)
+		}
+
+		_, err = tdb.AddPairRate(context.Background(), storage.ConflictError, domain.BTCUSDT, testBtcRate3)
+		if !errors.Is(err, storage.ErrConflict) {
+			t.Errorf("AddPairRate() = %v, want %v", err, storage.ErrConflict)
+		}
+	})
+
+	t.Run("AddQuotes", func(t *testing.T) {
+		quotes := []domain.Rate{
+			{Time: testBtcRate3.Time, Value: 22278.80, Source: domain.SourceBinance, Volume: 181503.25836},
+			{Time: testBtcRate3.Time, Value: 22280.90, Source: domain.SourceKraken},
+		}
+		res, err := tdb.AddQuotes(context.Background(), storage.ConflictIgnore, domain.BTCUSDT, quotes...)
+		if err != nil {
+			t.Fatalf("AddQuotes() = error: %v", err)
+		}
+		if want := (storage.Result{Inserted: 2}); res != want {
+			t.Errorf("AddQuotes() = %+v, want %+v", res, want)
+		}
+
+		quotes[1].Value = 22281.00
+		res, err = tdb.AddQuotes(context.Background(), storage.ConflictOverwrite, domain.BTCUSDT, quotes...)
+		if err != nil {
+			t.Fatalf("AddQuotes() = error: %v", err)
+		}
+		if want := (storage.Result{Updated: 1, Skipped: 1}); res != want {
+			t.Errorf("AddQuotes() = %+v, want %+v", res, want)
+		}
+	})
+
+	t.Run("AddFiats_conflict", func(t *testing.T) {
+		revised := testFiatRate3
+		revised.Value = 42.4242
+		fresh := testFiatRate4
+		fresh.Time += 24 * 60 * 60
+
+		res, err := tdb.AddFiats(context.Background(), storage.ConflictIgnore, revised, fresh)
+		if err != nil {
+			t.Fatalf("AddFiats() = error: %v", err)
+		}
+		if want := (storage.Result{Inserted: 1, Skipped: 1}); res != want {
+			t.Errorf("AddFiats() = %+v, want %+v", res, want)
+		}
+
+		_, err = tdb.AddFiats(context.Background(), storage.ConflictError, revised)
+		if !errors.Is(err, storage.ErrConflict) {
+			t.Errorf("AddFiats() = %v, want %v", err, storage.ErrConflict)
+		}
+
+		res, err = tdb.AddFiats(context.Background(), storage.ConflictOverwrite, revised, fresh)
+		if err != nil {
+			t.Fatalf("AddFiats() = error: %v", err)
+		}
+		if want := (storage.Result{Updated: 1, Skipped: 1}); res != want {
+			t.Errorf("AddFiats() = %+v, want %+v", res, want)
+		}
+
+		var old, new float64
+		err = tdb.db.QueryRow(context.Background(), `
+			SELECT old_value, new_value FROM fiat_corrections
+			WHERE char_code = $1 AND time = $2;`, revised.CharCode, revised.Time).Scan(&old, &new)
+		if err != nil {
+			t.Fatalf("fiat_corrections = error: %v", err)
+		}
+		if old != testFiatRate3.Value || new != revised.Value {
+			t.Errorf("fiat_corrections = %v -> %v, want %v -> %v", old, new, testFiatRate3.Value, revised.Value)
+		}
+	})
+
+	t.Run("FiatsCurrent_latest", func(t *testing.T) {
+		// после AddFiats_conflict у GBP есть курс на следующий день
+		got, err := tdb.FiatsCurrent(context.Background(), domain.BaseRUB)
+		if err != nil {
+			t.Fatalf("FiatsCurrent() = error: %v", err)
+		}
+
+		if len(got) != 4 {
+			t.Fatalf("FiatsCurrent() rows = %d, want %d", len(got), 4)
+		}
+
+		for i := range got {
+			if got[i].CharCode == testFiatRate4.CharCode && got[i].Time != testFiatRate4.Time+24*60*60 {
+				t.Errorf("FiatsCurrent() %s time = %d, want %d",
+					got[i].CharCode, got[i].Time, testFiatRate4.Time+24*60*60)
+			}
+		}
+	})
+
+	t.Run("AddFiats_base", func(t *testing.T) {
+		// курс ЕЦБ на ту же дату не конфликтует с курсом ЦБ
+		ecb := domain.Rate{CharCode: "USD", Nominal: 10, Time: testFiatRate1.Time, Value: 9.8058,
+			Source: domain.SourceECB, Base: domain.BaseEUR}
+		res, err := tdb.AddFiats(context.Background(), storage.ConflictError, ecb)
+		if err != nil {
+			t.Fatalf("AddFiats() = error: %v", err)
+		}
+		if want := (storage.Result{Inserted: 1}); res != want {
+			t.Errorf("AddFiats() = %+v, want %+v", res, want)
+		}
+
+		got, err := tdb.FiatsCurrent(context.Background(), domain.BaseEUR)
+		if err != nil {
+			t.Fatalf("FiatsCurrent() = error: %v", err)
+		}
+		if len(got) != 1 || got[0].Nominal != ecb.Nominal || got[0].Value != ecb.Value || got[0].Base != domain.BaseEUR {
+			t.Errorf("FiatsCurrent() = %+v, want %+v", got, ecb)
+		}
+		usd, err := tdb.USDRate(context.Background(), domain.BaseRUB)
+		if err != nil {
+			t.Fatalf("USDRate() = error: %v", err)
+		}
+		if usd.Nominal != testFiatRate1.Nominal || usd.Base != domain.BaseRUB {
+			t.Errorf("USDRate() = %+v, want %+v", usd, testFiatRate1)
+		}
+		hist, err := tdb.Fiats(context.Background(), storage.Filter{Base: domain.BaseEUR})
+		if err != nil {
+			t.Fatalf("Fiats() = error: %v", err)
+		}
+		if len(hist) != 1 || hist[0].Source != domain.SourceECB {
+			t.Errorf("Fiats() = %+v, want %+v", hist, ecb)
 		}
 	})
 }
 
-var testBtcRate1 = domain.Rate{Id: 1, Time: 1658252361, Value: 22278.20}
-var testBtcRate3 = domain.Rate{Id: 3, Time: 1658252363, Value: 11111.10}
+var testBtcRate1 = domain.Rate{Id: 1, Time: 1658252361, Value: 22278.20, Source: domain.SourceKucoin}
+var testBtcRate3 = domain.Rate{Id: 3, Time: 1658252363, Value: 11111.10, Source: domain.SourceAggregate}
 
 // var testBtcRate2 = domain.Rate{Id: 2, Time: 1658252362, Value: 22378.20}
 
-var testFiatRate1 = domain.Rate{Id: 1, CharCode: "USD", Nominal: 1, Time: 1658252361, Value: 22278.20}
-var testFiatRate2 = domain.Rate{Id: 2, CharCode: "HUF", Nominal: 100, Time: 1658252361, Value: 32378.20}
-var testFiatRate3 = domain.Rate{Id: 3, CharCode: "AZN", Nominal: 1, Time: 1658314796, Value: 5555555555.2024}
-var testFiatRate4 = domain.Rate{Id: 4, CharCode: "GBP", Nominal: 1, Time: 1658314796, Value: 4444444444.3334}
+var testFiatRate1 = domain.Rate{Id: 1, CharCode: "USD", Nominal: 1, Time: 1658252361, Value: 22278.20, Source: domain.SourceCBR, Base: domain.BaseRUB}
+var testFiatRate2 = domain.Rate{Id: 2, CharCode: "HUF", Nominal: 100, Time: 1658252361, Value: 32378.20, Source: domain.SourceCBR, Base: domain.BaseRUB}
+var testFiatRate3 = domain.Rate{Id: 3, CharCode: "AZN", Nominal: 1, Time: 1658314796, Value: 5555555555.2024, Base: domain.BaseRUB}
+var testFiatRate4 = domain.Rate{Id: 4, CharCode: "GBP", Nominal: 1, Time: 1658314796, Value: 4444444444.3334, Base: domain.BaseRUB}
+
+func TestPostgres_MigrateBaseline(t *testing.T) {
+	if tdb == nil {
+		t.Skip("no connection to test database, skipped...")
+	}
+	// остальные тесты работают с актуальной схемой
+	t.Cleanup(func() {
+		if err := restoreDB(tdb); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// testdata/baseline.sql - схема до всех миграций
+	b, err := os.ReadFile(filepath.Join("testdata", "baseline.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tdb.exec(context.Background(), string(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range names {
+		want = append(want, strings.TrimSuffix(path.Base(name), ".sql"))
+	}
+
+	got, err := tdb.Migrate(context.Background())
+	if err != nil {
+		t.Fatalf("Migrate() = error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrate() = %v, want %v", got, want)
+	}
+
+	// таблицы и индексы из schema.sql появились и в обновленной БД
+	for _, rel := range []string{"fiat_corrections", "fiat_rates_code_time_idx", "pair_rates", "quotes"} {
+		var ok bool
+		err := tdb.db.QueryRow(context.Background(), `SELECT to_regclass($1) IS NOT NULL`, rel).Scan(&ok)
+		if err != nil || !ok {
+			t.Errorf("relation %q exists = %t, %v, want %t", rel, ok, err, true)
+		}
+	}
+
+	// курс BTC/USDT перенесен в таблицу пар
+	btc, err := tdb.PairRate(context.Background(), domain.BTCUSDT, storage.Filter{Limit: 1})
+	if err != nil {
+		t.Fatalf("PairRate() = error: %v", err)
+	}
+	if len(btc) != 1 || btc[0].Value != 22278.20 {
+		t.Errorf("PairRate() = %#v, want 22278.20", btc)
+	}
+
+	// записанные до миграций курсы - к рублю, источник неизвестен
+	rates, err := tdb.FiatsCurrent(context.Background(), domain.BaseRUB)
+	if err != nil {
+		t.Fatalf("FiatsCurrent() = error: %v", err)
+	}
+	if len(rates) != 1 || rates[0].CharCode != "USD" || rates[0].Value != 60.1234 || rates[0].Source != "" {
+		t.Errorf("FiatsCurrent() = %#v, want USD 60.1234", rates)
+	}
+}
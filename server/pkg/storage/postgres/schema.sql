@@ -1,26 +1,79 @@
-DROP TABLE IF EXISTS fiats, btc_usdt, rub;
+-- btc_usdt и btc_quotes - таблицы до миграции 0005_pairs
+DROP TABLE IF EXISTS fiats, pair_rates, quotes, pairs, btc_usdt, btc_quotes, rub, rub_corrections, fiat_rates, fiat_corrections, schema_migrations;
 
+-- номинал валюты в курсах к базовой валюте base (RUB - ЦБ, EUR - ЕЦБ)
 CREATE TABLE IF NOT EXISTS fiats (
+    base VARCHAR(3) NOT NULL DEFAULT 'RUB',
     char_code VARCHAR(3),
     nominal INT NOT NULL,
-    PRIMARY KEY(char_code)
+    PRIMARY KEY(base, char_code)
 );
 
-CREATE TABLE IF NOT EXISTS btc_usdt (
+-- пары криптовалют: курс base в единицах quote
+CREATE TABLE IF NOT EXISTS pairs (
+    id SERIAL PRIMARY KEY,
+    base VARCHAR(10) NOT NULL,
+    quote VARCHAR(10) NOT NULL,
+    UNIQUE(base, quote)
+);
+
+CREATE TABLE IF NOT EXISTS pair_rates (
     id BIGSERIAL PRIMARY KEY,
+    pair_id INT NOT NULL REFERENCES pairs(id),
     time BIGINT CHECK(time > 0) DEFAULT extract(epoch from now()),
-    value NUMERIC(20, 4) NOT NULL,
-    UNIQUE(time)
+    value NUMERIC(28, 8) NOT NULL,
+    source VARCHAR(32) NOT NULL DEFAULT '',
+    UNIQUE(pair_id, time)
+);
+
+-- котировки отдельных бирж, из которых
+-- рассчитывается опорный курс пары в pair_rates
+CREATE TABLE IF NOT EXISTS quotes (
+    id BIGSERIAL PRIMARY KEY,
+    pair_id INT NOT NULL REFERENCES pairs(id),
+    source VARCHAR(32) NOT NULL,
+    time BIGINT CHECK(time > 0),
+    value NUMERIC(28, 8) NOT NULL,
+    volume NUMERIC(28, 8) NOT NULL DEFAULT 0,
+    UNIQUE(pair_id, source, time)
 );
 
-CREATE TABLE IF NOT EXISTS rub (
+-- курсы фиатных валют к базовой валюте base
+CREATE TABLE IF NOT EXISTS fiat_rates (
     id BIGSERIAL PRIMARY KEY,
+    base VARCHAR(3) NOT NULL DEFAULT 'RUB',
     char_code VARCHAR(3),
     time BIGINT CHECK(time > 0),
     value NUMERIC(20, 4) NOT NULL,
-    UNIQUE(char_code, time),
-    FOREIGN KEY (char_code) REFERENCES fiats(char_code)
+    source VARCHAR(32) NOT NULL DEFAULT '',
+    UNIQUE(base, char_code, time),
+    FOREIGN KEY (base, char_code) REFERENCES fiats(base, char_code)
+);
+
+-- исправления курсов, которые ЦБ (ЕЦБ) внес задним числом
+CREATE TABLE IF NOT EXISTS fiat_corrections (
+    id BIGSERIAL PRIMARY KEY,
+    base VARCHAR(3) NOT NULL DEFAULT 'RUB',
+    char_code VARCHAR(3),
+    time BIGINT CHECK(time > 0),
+    old_value NUMERIC(20, 4) NOT NULL,
+    new_value NUMERIC(20, 4) NOT NULL,
+    corrected_at BIGINT DEFAULT extract(epoch from now()),
+    FOREIGN KEY (base, char_code) REFERENCES fiats(base, char_code)
+);
+
+CREATE INDEX IF NOT EXISTS pair_rates_time_idx ON pair_rates(pair_id, time DESC);
+CREATE INDEX IF NOT EXISTS pair_rates_source_time_idx ON pair_rates(pair_id, source, time DESC);
+CREATE INDEX IF NOT EXISTS quotes_time_idx ON quotes(pair_id, time DESC);
+CREATE INDEX IF NOT EXISTS fiat_rates_time_idx ON fiat_rates(time DESC);
+-- последний курс каждой валюты (FiatsCurrent)
+CREATE INDEX IF NOT EXISTS fiat_rates_code_time_idx ON fiat_rates(base, char_code, time DESC);
+
+-- схема уже включает все миграции
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version TEXT PRIMARY KEY,
+    applied_at BIGINT DEFAULT extract(epoch from now())
 );
+INSERT INTO schema_migrations(version) VALUES ('0001_rub_corrections'), ('0002_rub_code_time_idx'), ('0003_btc_quotes'), ('0004_source'), ('0005_pairs'), ('0006_fiat_base');
 
-CREATE INDEX IF NOT EXISTS btc_time_idx ON btc_usdt(time DESC);
-CREATE INDEX IF NOT EXISTS rub_time_idx ON rub(time DESC);
\ No newline at end of file
+INSERT INTO pairs(base, quote) VALUES ('BTC', 'USDT');
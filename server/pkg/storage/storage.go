@@ -2,28 +2,75 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"xtestserver/domain"
 )
 
+// ErrConflict - запись с таким ключом уже есть в БД.
+var ErrConflict = errors.New("storage: record already exists")
+
+// ErrNotFound - запрошенной записи нет в БД.
+var ErrNotFound = errors.New("storage: record not found")
+
 // Filter - фильтр для запросов БД.
 type Filter struct {
 	Operator string // ['<=' '>=' '=']
 	Currency string // ['USD' 'BLR' 'HUF'...]
+	Source   string // ['aggregate' 'cbr'...], см. domain.Source*
+	Base     string // ['RUB' 'EUR'] - базовая валюта курсов фиатных валют, см. domain.Base*
 	Limit    int
 	Offset   int
 	Time     int64 // UNIX timestamp
 }
 
+// Conflict - политика обработки записей,
+// которые уже есть в БД (тот же курс на то же время).
+type Conflict int
+
+const (
+	ConflictIgnore    Conflict = iota // пропускаем запись
+	ConflictOverwrite                 // перезаписываем значение, если оно изменилось
+	ConflictError                     // возвращаем ErrConflict
+)
+
+// Result - итог добавления записей в БД.
+type Result struct {
+	Inserted int // добавлено новых записей
+	Updated  int // перезаписано изменившихся значений
+	Skipped  int // пропущено уже существующих записей
+}
+
+// Add суммирует итоги.
+func (r *Result) Add(o Result) {
+	r.Inserted += o.Inserted
+	r.Updated += o.Updated
+	r.Skipped += o.Skipped
+}
+
 // Storage - контракт реализуемый базой данных.
 type Storage interface {
-	AddBtcRate(context.Context, domain.Rate) error   // Добавляет в БД текущий курс BTC/USD.
-	AddFiats(context.Context, ...domain.Rate) error  // Добавляет в БД текущий курс фиатных валют.
-	RUBUSDRate(context.Context) (domain.Rate, error) // Возвращает текущий курс доллара к рублю.
-	Close() error                                    // закрываем соединение с БД.
-	// Возвращает отфильтрованное кол-во из таблицы курса BTC/USD.
-	BtcRate(ctx context.Context, filter Filter) ([]domain.Rate, error)
+	// Добавляет в БД текущий курс пары, например BTC/USDT.
+	// Пара, которой еще нет в БД, добавляется.
+	AddPairRate(context.Context, Conflict, domain.Pair, domain.Rate) (Result, error)
+	// Добавляет в БД котировки пары на отдельных биржах,
+	// из которых рассчитывается ее курс.
+	AddQuotes(context.Context, Conflict, domain.Pair, ...domain.Rate) (Result, error)
+	// Добавляет в БД текущий курс фиатных валют к их Rate.Base
+	// (без нее - к рублю). При перезаписи изменившиеся значения
+	// сохраняются в истории исправлений.
+	AddFiats(context.Context, Conflict, ...domain.Rate) (Result, error)
+	// Возвращает текущий курс доллара в базовой валюте base,
+	// ErrNotFound - если курсов к base еще нет.
+	USDRate(ctx context.Context, base string) (domain.Rate, error)
+	Close() error               // закрываем соединение с БД.
+	Ping(context.Context) error // проверяет соединение с БД.
+	// Возвращает отфильтрованное кол-во курсов пары.
+	PairRate(ctx context.Context, pair domain.Pair, filter Filter) ([]domain.Rate, error)
+	// Возвращает известные БД пары.
+	Pairs(context.Context) ([]domain.Pair, error)
 	// Возвращает отфильтрованное кол-во из таблицы курса фиатных валют.
 	Fiats(ctx context.Context, filter Filter) ([]domain.Rate, error)
-	// Возвращает текущий курс к рублю из таблицы курсов фиатных валют.
-	FiatsCurrent(context.Context) ([]domain.Rate, error)
+	// Возвращает текущий курс к базовой валюте base
+	// из таблицы курсов фиатных валют.
+	FiatsCurrent(ctx context.Context, base string) ([]domain.Rate, error)
 }
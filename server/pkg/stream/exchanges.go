@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xtestserver/domain"
+)
+
+// Exchanges - потоки тикеров по бирже: url - адрес из настроек
+// источника, symbol - инструмент в обозначениях биржи.
+// Сообщения потоков разбирают domain.StreamDecoders.
+var Exchanges = map[string]func(url, symbol string) Exchange{
+	domain.SourceKucoin:  KuCoin,
+	domain.SourceBinance: Binance,
+}
+
+// Binance - поток 24-часового тикера Binance: url - адрес
+// потока (wss://stream.binance.com:9443/ws), symbol - BTCUSDT.
+// Binance сам пингует клиента, ответы на пинги отправляет Stream.
+func Binance(url, symbol string) Exchange {
+	sub, _ := json.Marshal(map[string]any{
+		"method": "SUBSCRIBE",
+		"params": []string{strings.ToLower(symbol) + "@ticker"},
+		"id":     1,
+	})
+	return func(context.Context) (Endpoint, error) {
+		return Endpoint{URL: url, Subscribe: [][]byte{sub}}, nil
+	}
+}
+
+// KuCoin - поток тикера KuCoin: url - адрес получения токена
+// (https://api.kucoin.com/api/v1/bullet-public), symbol - BTC-USDT.
+// Перед каждым подключением запрашивается новый токен, вместе
+// с ним KuCoin сообщает адрес потока и интервал пингов.
+func KuCoin(url, symbol string) Exchange {
+	var id atomic.Int64
+	next := func() string { return strconv.FormatInt(id.Add(1), 10) }
+
+	return func(ctx context.Context) (Endpoint, error) {
+		b, err := kucoinBullet(ctx, url)
+		if err != nil {
+			return Endpoint{}, err
+		}
+		sub, _ := json.Marshal(map[string]any{
+			"id":             next(),
+			"type":           "subscribe",
+			"topic":          "/market/ticker:" + symbol,
+			"privateChannel": false,
+			"response":       true,
+		})
+		return Endpoint{
+			URL:          b.endpoint + "?token=" + b.token + "&connectId=" + next(),
+			Subscribe:    [][]byte{sub},
+			PingInterval: b.pingInterval,
+			Ping: func() []byte {
+				ping, _ := json.Marshal(map[string]string{"id": next(), "type": "ping"})
+				return ping
+			},
+		}, nil
+	}
+}
+
+// bullet - адрес потока KuCoin.
+type bullet struct {
+	endpoint     string
+	token        string
+	pingInterval time.Duration
+}
+
+// kucoinBullet запрашивает токен и адрес потока KuCoin.
+func kucoinBullet(ctx context.Context, rawURL string) (bullet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, nil)
+	if err != nil {
+		return bullet{}, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return bullet{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var r struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			Token   string `json:"token"`
+			Servers []struct {
+				Endpoint     string `json:"endpoint"`
+				PingInterval int64  `json:"pingInterval"` // мс
+			} `json:"instanceServers"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return bullet{}, fmt.Errorf("kucoin bullet: %w", err)
+	}
+	if r.Code != "200000" || r.Data.Token == "" || len(r.Data.Servers) == 0 {
+		return bullet{}, fmt.Errorf("kucoin bullet: %s %s", r.Code, r.Msg)
+	}
+	s := r.Data.Servers[0]
+	if _, err := url.Parse(s.Endpoint); err != nil {
+		return bullet{}, fmt.Errorf("kucoin bullet: %w", err)
+	}
+	return bullet{
+		endpoint:     s.Endpoint,
+		token:        url.QueryEscape(r.Data.Token),
+		pingInterval: time.Duration(s.PingInterval) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,216 @@
+// Пакет stream предоставляет получение тикеров из потоков бирж
+// по WebSocket: в отличие от интервального опроса (пакет poller)
+// биржа сама присылает каждое изменение цены.
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"xtestserver/pkg/pipeline"
+
+	"github.com/gorilla/websocket"
+)
+
+// Endpoint - параметры одного подключения к потоку.
+type Endpoint struct {
+	URL          string        // адрес ws:// или wss://
+	Subscribe    [][]byte      // сообщения, отправляемые сразу после подключения
+	PingInterval time.Duration // интервал пингов, 0 - из настроек потока
+	Ping         func() []byte // пинг протокола биржи, nil - управляющий кадр ping
+}
+
+// Exchange возвращает параметры подключения к потоку биржи.
+// Вызывается перед каждым подключением: адрес может быть
+// одноразовым (у KuCoin в нем токен).
+type Exchange func(ctx context.Context) (Endpoint, error)
+
+// ErrIdle - поток долго молчит, соединение считается потерянным.
+var ErrIdle = errors.New("stream is idle")
+
+// options - настройки потока.
+type options struct {
+	dialTimeout  time.Duration // подключение вместе с получением адреса
+	pingInterval time.Duration // интервал пингов, если биржа не задала свой
+	idleTimeout  time.Duration // молчание дольше - переподключение
+	minBackoff   time.Duration // пауза перед первым переподключением
+	maxBackoff   time.Duration // предел паузы
+}
+
+// Option - функция, изменяющая настройки потока.
+type Option func(*options)
+
+// WithDialTimeout устанавливает таймаут подключения.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.dialTimeout = d
+		}
+	}
+}
+
+// WithPingInterval устанавливает интервал пингов,
+// которыми поддерживается соединение.
+func WithPingInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.pingInterval = d
+		}
+	}
+}
+
+// WithIdleTimeout устанавливает время, после которого
+// молчащее соединение (нет ни данных, ни ответов на пинги)
+// закрывается и устанавливается заново.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.idleTimeout = d
+		}
+	}
+}
+
+// WithBackoff устанавливает паузу перед первым переподключением
+// и ее предел; после каждого неудачного подключения пауза удваивается.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		if min > 0 && max >= min {
+			o.minBackoff, o.maxBackoff = min, max
+		}
+	}
+}
+
+// Stream подключается к потоку биржи ex и отдает сообщения
+// в канал, а ошибки подключения и чтения - в errs. Потерянное
+// соединение устанавливается заново с растущей паузой.
+// Канал закрывается после отмены контекста.
+func Stream(ctx context.Context, ex Exchange, errs pipeline.Sink, opts ...Option) <-chan []byte {
+	o := options{
+		dialTimeout:  10 * time.Second,
+		pingInterval: 20 * time.Second,
+		idleTimeout:  time.Minute,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if errs == nil {
+		errs = pipeline.Discard
+	}
+
+	return pipeline.Generate(ctx, func(ctx context.Context, emit func([]byte) bool) {
+		backoff := o.minBackoff
+		for {
+			start := time.Now()
+			err := session(ctx, ex, o, emit)
+			if ctx.Err() != nil {
+				return
+			}
+			errs(fmt.Errorf("stream: %w", err))
+			if time.Since(start) > o.maxBackoff {
+				backoff = o.minBackoff // соединение долго работало исправно
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > o.maxBackoff {
+				backoff = o.maxBackoff
+			}
+		}
+	}, errs)
+}
+
+// session выполняет одно подключение: подписывается на поток,
+// поддерживает соединение пингами и отдает сообщения через emit.
+// Возвращает ошибку, из-за которой соединение потеряно.
+func session(ctx context.Context, ex Exchange, o options, emit func([]byte) bool) error {
+	dctx, cancel := context.WithTimeout(ctx, o.dialTimeout)
+	defer cancel()
+	ep, err := ex(dctx)
+	if err != nil {
+		return fmt.Errorf("endpoint: %w", err)
+	}
+	// чтобы сервера не посылали нам ошибку 403
+	// ставим заголовок User-Agent
+	conn, _, err := websocket.DefaultDialer.DialContext(dctx, ep.URL, http.Header{"User-Agent": {"Mozilla/5.0"}})
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", ep.URL, err)
+	}
+	defer conn.Close()
+	// отмена контекста прерывает чтение
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for _, m := range ep.Subscribe {
+		_ = conn.SetWriteDeadline(time.Now().Add(o.dialTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, m); err != nil {
+			return fmt.Errorf("subscribe: %w", err)
+		}
+	}
+
+	// любое сообщение и ответ на пинг продлевают соединение
+	alive := func() error {
+		return conn.SetReadDeadline(time.Now().Add(o.idleTimeout))
+	}
+	_ = alive()
+	conn.SetPongHandler(func(string) error { return alive() })
+	conn.SetPingHandler(func(data string) error {
+		_ = alive()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(o.dialTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	interval := ep.PingInterval
+	if interval <= 0 {
+		interval = o.pingInterval
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			deadline := time.Now().Add(o.dialTimeout)
+			var err error
+			if ep.Ping != nil {
+				_ = conn.SetWriteDeadline(deadline)
+				err = conn.WriteMessage(websocket.TextMessage, ep.Ping())
+			} else {
+				err = conn.WriteControl(websocket.PingMessage, nil, deadline)
+			}
+			if err != nil {
+				_ = conn.Close() // чтение вернет ошибку
+				return
+			}
+		}
+	}()
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			var ne interface{ Timeout() bool }
+			if errors.As(err, &ne) && ne.Timeout() {
+				err = ErrIdle
+			}
+			return fmt.Errorf("read %s: %w", ep.URL, err)
+		}
+		_ = alive()
+		if !emit(b) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xtestserver/pkg/stream/streamtest"
+
+	"github.com/gorilla/websocket"
+)
+
+// errList - потокобезопасный сток ошибок.
+type errList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *errList) sink(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+func (l *errList) get() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+// nonBlocking возвращает сток, отправляющий ошибки в ch,
+// пока в нем есть место: поток повторяет попытки без остановки.
+func nonBlocking(ch chan error) func(error) {
+	return func(err error) {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+}
+
+// next возвращает следующее сообщение потока.
+func next(t *testing.T, out <-chan []byte) string {
+	t.Helper()
+	select {
+	case b, ok := <-out:
+		if !ok {
+			t.Fatal("Stream() closed")
+		}
+		return string(b)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream() sent nothing")
+	}
+	return ""
+}
+
+func TestStream_binance(t *testing.T) {
+	srv := streamtest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var errs errList
+	out := Stream(ctx, Binance(srv.BinanceURL(), "BTCUSDT"), errs.sink,
+		WithBackoff(10*time.Millisecond, 20*time.Millisecond))
+
+	// ответ на подписку - тоже сообщение потока
+	if got := next(t, out); got != `{"result":null,"id":1}` {
+		t.Errorf("Stream() = %s, want subscription result", got)
+	}
+	if got := srv.Received(); len(got) != 1 || !strings.Contains(got[0], `"params":["btcusdt@ticker"]`) {
+		t.Errorf("Stream() subscribe = %v, want btcusdt@ticker", got)
+	}
+	srv.Send([]byte(`{"e":"24hrTicker","c":"22278.8"}`))
+	if got := next(t, out); got != `{"e":"24hrTicker","c":"22278.8"}` {
+		t.Errorf("Stream() = %s, want ticker", got)
+	}
+
+	// после разрыва соединение восстанавливается с новой подпиской
+	srv.Drop()
+	next(t, out)
+	if n := srv.Accepted(); n != 2 {
+		t.Errorf("Stream() connections = %d, want %d", n, 2)
+	}
+	if got := srv.Received(); len(got) != 2 {
+		t.Errorf("Stream() subscribes = %v, want two", got)
+	}
+	if got := errs.get(); len(got) != 1 {
+		t.Errorf("Stream() errors = %v, want one read error", got)
+	}
+
+	cancel()
+	for range out {
+	}
+}
+
+func TestStream_kucoin(t *testing.T) {
+	srv := streamtest.NewServer()
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var errs errList
+	out := Stream(ctx, KuCoin(srv.BulletURL(), "BTC-USDT"), errs.sink)
+
+	if got := next(t, out); !strings.Contains(got, `"type":"welcome"`) {
+		t.Errorf("Stream() = %s, want welcome", got)
+	}
+	if got := next(t, out); !strings.Contains(got, `"type":"ack"`) {
+		t.Errorf("Stream() = %s, want subscription ack", got)
+	}
+	if got := srv.Received(); len(got) != 1 || !strings.Contains(got[0], `"topic":"/market/ticker:BTC-USDT"`) {
+		t.Errorf("Stream() subscribe = %v, want /market/ticker:BTC-USDT", got)
+	}
+	// интервал пингов (50ms) задает биржа при выдаче токена
+	if got := next(t, out); !strings.Contains(got, `"type":"pong"`) {
+		t.Errorf("Stream() = %s, want pong", got)
+	}
+	if srv.Pings() == 0 {
+		t.Error("Stream() sent no pings")
+	}
+
+	cancel()
+	for range out {
+	}
+	if got := errs.get(); len(got) != 0 {
+		t.Errorf("Stream() errors = %v, want none", got)
+	}
+}
+
+func TestStream_idle(t *testing.T) {
+	// биржа принимает соединение и молчит, не отвечая на пинги
+	var upgrader websocket.Upgrader
+	var mu sync.Mutex
+	var conns []*websocket.Conn
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		conns = append(conns, c)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan error, 10)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	out := Stream(ctx, func(context.Context) (Endpoint, error) { return Endpoint{URL: url}, nil },
+		nonBlocking(got),
+		WithIdleTimeout(50*time.Millisecond), WithPingInterval(time.Hour), WithBackoff(time.Millisecond, time.Millisecond))
+
+	select {
+	case err := <-got:
+		if !errors.Is(err, ErrIdle) {
+			t.Errorf("Stream() = error %v, want %v", err, ErrIdle)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream() did not detect idle connection")
+	}
+	cancel()
+	for range out {
+	}
+}
+
+func TestStream_endpointError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errBullet := errors.New("bullet failed")
+	got := make(chan error, 10)
+	out := Stream(ctx, func(context.Context) (Endpoint, error) {
+		return Endpoint{}, errBullet
+	}, nonBlocking(got), WithBackoff(time.Millisecond, 2*time.Millisecond))
+
+	// попытки повторяются
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-got:
+			if !errors.Is(err, errBullet) {
+				t.Errorf("Stream() = error %v, want %v", err, errBullet)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Stream() retry %d did not happen", i)
+		}
+	}
+	cancel()
+	for range out {
+	}
+}
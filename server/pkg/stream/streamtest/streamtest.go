@@ -0,0 +1,239 @@
+// Пакет streamtest предоставляет поддельную биржу с потоком
+// тикеров по WebSocket для тестов: поток в стиле Binance
+// (/ws) и в стиле KuCoin с получением токена (/api/v1/bullet-public).
+package streamtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// token - токен, который выдает и проверяет поддельный KuCoin.
+const token = "streamtest-token"
+
+// Server - поддельная биржа.
+type Server struct {
+	*httptest.Server
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]*sync.Mutex // открытые соединения и их блокировки записи
+	accepted int                             // всего принято соединений
+	received []string                        // сообщения клиентов, кроме пингов
+	pings    int                             // пинги протокола KuCoin
+	changed  chan struct{}                   // закрывается при каждом изменении
+}
+
+// NewServer запускает поддельную биржу.
+// Закрывать ее нужно методом Close.
+func NewServer() *Server {
+	s := &Server{
+		conns:   make(map[*websocket.Conn]*sync.Mutex),
+		changed: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.binance)
+	mux.HandleFunc("/api/v1/bullet-public", s.bullet)
+	mux.HandleFunc("/kucoin", s.kucoin)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BinanceURL возвращает адрес потока в стиле Binance.
+func (s *Server) BinanceURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+}
+
+// BulletURL возвращает адрес получения токена в стиле KuCoin.
+func (s *Server) BulletURL() string {
+	return s.URL + "/api/v1/bullet-public"
+}
+
+// Close разрывает соединения и останавливает биржу.
+func (s *Server) Close() {
+	s.Drop()
+	s.Server.Close()
+}
+
+// Send отправляет сообщение всем подключенным клиентам.
+func (s *Server) Send(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, wmu := range s.conns {
+		wmu.Lock()
+		_ = c.WriteMessage(websocket.TextMessage, b)
+		wmu.Unlock()
+	}
+}
+
+// Drop разрывает все соединения, как при сбое сети.
+func (s *Server) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// Accepted возвращает число принятых за все время соединений.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Received возвращает сообщения клиентов, кроме пингов.
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// Pings возвращает число пингов протокола KuCoin.
+func (s *Server) Pings() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pings
+}
+
+// Wait ждет, пока cond не станет истинным, или отмены контекста.
+// cond проверяется после каждого изменения состояния биржи.
+func (s *Server) Wait(ctx context.Context, cond func(s *Server) bool) error {
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		if cond(s) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// notify сообщает ожидающим об изменении. Вызывается под s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// accept принимает соединение и регистрирует его.
+func (s *Server) accept(w http.ResponseWriter, r *http.Request) (*websocket.Conn, *sync.Mutex, bool) {
+	c, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, nil, false
+	}
+	wmu := new(sync.Mutex)
+	s.mu.Lock()
+	s.conns[c] = wmu
+	s.accepted++
+	s.notify()
+	s.mu.Unlock()
+	return c, wmu, true
+}
+
+// release снимает соединение с учета.
+func (s *Server) release(c *websocket.Conn) {
+	_ = c.Close()
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.notify()
+	s.mu.Unlock()
+}
+
+// binance - поток в стиле Binance: отвечает на подписку,
+// остальное время только читает (и отвечает на пинги).
+func (s *Server) binance(w http.ResponseWriter, r *http.Request) {
+	c, wmu, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+	defer s.release(c)
+
+	for {
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID int `json:"id"`
+		}
+		_ = json.Unmarshal(b, &req)
+		s.record(b, false)
+		wmu.Lock()
+		_ = c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"result":null,"id":%d}`, req.ID)))
+		wmu.Unlock()
+	}
+}
+
+// bullet выдает токен и адрес потока в стиле KuCoin.
+func (s *Server) bullet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintf(w, `{"code":"200000","data":{"token":%q,"instanceServers":[{"endpoint":%q,`+
+		`"encrypt":false,"protocol":"websocket","pingInterval":50,"pingTimeout":1000}]}}`,
+		token, "ws"+strings.TrimPrefix(s.URL, "http")+"/kucoin")
+}
+
+// kucoin - поток в стиле KuCoin: проверяет токен, приветствует
+// клиента, отвечает на пинги и подтверждает подписку.
+func (s *Server) kucoin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != token {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return
+	}
+	c, wmu, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+	defer s.release(c)
+
+	write := func(format string, args ...any) {
+		wmu.Lock()
+		_ = c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(format, args...)))
+		wmu.Unlock()
+	}
+	write(`{"id":%q,"type":"welcome"}`, r.URL.Query().Get("connectId"))
+	for {
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal(b, &req)
+		s.record(b, req.Type == "ping")
+		switch req.Type {
+		case "ping":
+			write(`{"id":%q,"type":"pong"}`, req.ID)
+		case "subscribe":
+			write(`{"id":%q,"type":"ack"}`, req.ID)
+		}
+	}
+}
+
+// record запоминает сообщение клиента.
+func (s *Server) record(b []byte, ping bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ping {
+		s.pings++
+	} else {
+		s.received = append(s.received, string(b))
+	}
+	s.notify()
+}
@@ -0,0 +1,188 @@
+// Пакет supervisor следит за долгоживущими задачами
+// (конвейерами получения курсов): перехватывает их паники,
+// перезапускает с нарастающей паузой и сообщает их состояние
+// проверкам готовности.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xtestserver/pkg/recovery"
+)
+
+// Task - задача под наблюдением. Должна работать,
+// пока не отменен контекст. Возврат до отмены
+// контекста считается сбоем.
+type Task func(ctx context.Context) error
+
+// ErrExited - задача завершилась без ошибки до отмены контекста.
+var ErrExited = errors.New("task exited unexpectedly")
+
+// Status - состояние задачи.
+type Status string
+
+const (
+	StatusRunning    Status = "running"    // работает
+	StatusRestarting Status = "restarting" // упала и ждет перезапуска
+	StatusStopped    Status = "stopped"    // остановлена отменой контекста
+)
+
+// State - состояние задачи и история ее сбоев.
+type State struct {
+	Status   Status
+	Since    time.Time // когда задача перешла в это состояние
+	Restarts int       // число перезапусков
+	Err      error     // последняя ошибка
+}
+
+// Supervisor перезапускает упавшие задачи.
+type Supervisor struct {
+	logger *slog.Logger
+	opts   options
+	now    func() time.Time
+
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// options - настройки перезапуска.
+type options struct {
+	minBackoff time.Duration // пауза перед первым перезапуском
+	maxBackoff time.Duration // предел паузы
+}
+
+// Option - функция, изменяющая настройки перезапуска.
+type Option func(*options)
+
+// WithBackoff устанавливает паузу перед первым перезапуском
+// и ее предел. Пауза удваивается после каждого сбоя подряд
+// и сбрасывается, если задача проработала дольше предела.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		if min > 0 && max >= min {
+			o.minBackoff, o.maxBackoff = min, max
+		}
+	}
+}
+
+// New возвращает новый *Supervisor.
+func New(logger *slog.Logger, opts ...Option) *Supervisor {
+	s := Supervisor{
+		logger: logger,
+		opts: options{
+			minBackoff: time.Second,
+			maxBackoff: time.Minute,
+		},
+		now:    time.Now,
+		states: make(map[string]State),
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	return &s
+}
+
+// Run выполняет задачу name и перезапускает ее после паники,
+// ошибки или преждевременного завершения, пока не отменен ctx.
+// Возвращает управление после отмены ctx и завершения задачи.
+func (s *Supervisor) Run(ctx context.Context, name string, task Task) {
+	backoff := s.opts.minBackoff
+	for restarts := 0; ; restarts++ {
+		s.set(name, func(st *State) {
+			st.Status = StatusRunning
+			st.Restarts = restarts
+		})
+
+		start := s.now()
+		err := call(ctx, task)
+		if ctx.Err() != nil {
+			s.set(name, func(st *State) { st.Status = StatusStopped })
+			return
+		}
+		if err == nil {
+			err = ErrExited
+		}
+		if s.now().Sub(start) > s.opts.maxBackoff {
+			backoff = s.opts.minBackoff // задача долго работала исправно
+		}
+
+		s.set(name, func(st *State) {
+			st.Status = StatusRestarting
+			st.Err = err
+		})
+		attrs := []any{"task", name, "err", err, "restarts", restarts + 1, "backoff", backoff}
+		var perr *recovery.PanicError
+		if errors.As(err, &perr) {
+			attrs = append(attrs, "stack", string(perr.Stack))
+		}
+		s.logger.Error("task failed, restarting", attrs...)
+
+		select {
+		case <-ctx.Done():
+			s.set(name, func(st *State) { st.Status = StatusStopped })
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.opts.maxBackoff {
+			backoff = s.opts.maxBackoff
+		}
+	}
+}
+
+// call выполняет задачу, превращая панику в *recovery.PanicError.
+func call(ctx context.Context, task Task) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &recovery.PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return task(ctx)
+}
+
+// set изменяет состояние задачи.
+func (s *Supervisor) set(name string, f func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.states[name]
+	old := st.Status
+	f(&st)
+	if st.Status != old {
+		st.Since = s.now()
+	}
+	s.states[name] = st
+}
+
+// States возвращает состояния всех задач.
+func (s *Supervisor) States() map[string]State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]State, len(s.states))
+	for name, st := range s.states {
+		states[name] = st
+	}
+	return states
+}
+
+// Check - проверка готовности: возвращает ошибку,
+// если какая-то из задач упала и ждет перезапуска.
+func (s *Supervisor) Check(context.Context) error {
+	states := s.States()
+	var failed []string
+	for name, st := range states {
+		if st.Status == StatusRestarting {
+			failed = append(failed, fmt.Sprintf("%s %s (%d restarts): %v", name, st.Status, st.Restarts+1, st.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return errors.New(strings.Join(failed, "; "))
+}
@@ -0,0 +1,115 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventually ждет, пока проверка готовности не вернет ошибку.
+func eventually(t *testing.T, s *Supervisor, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if err := s.Check(context.Background()); err != nil {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Check() = %v, want error containing %q", err, want)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("Check() = nil, want error containing %q", want)
+}
+
+func TestSupervisor_Run(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		WithBackoff(50*time.Millisecond, 200*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan int, 10)
+	n := 0
+	task := func(ctx context.Context) error {
+		n++
+		runs <- n
+		switch n {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("stage closed")
+		case 3:
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, "kucoin", task)
+		close(done)
+	}()
+
+	// после каждого сбоя задача ждет перезапуска и не готова
+	for i, want := range []string{"panic: boom", "stage closed", ErrExited.Error(), ""} {
+		select {
+		case got := <-runs:
+			if got != i+1 {
+				t.Fatalf("Run() run = %d, want %d", got, i+1)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Run() did not restart task, runs = %d", i)
+		}
+		if want != "" {
+			eventually(t, s, want)
+		}
+	}
+
+	st := s.States()["kucoin"]
+	if st.Status != StatusRunning || st.Restarts != 3 {
+		t.Errorf("States() = %+v, want %s with 3 restarts", st, StatusRunning)
+	}
+	if err := s.Check(ctx); err != nil {
+		t.Errorf("Check() = %v, want nil", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return after cancel")
+	}
+	if st := s.States()["kucoin"]; st.Status != StatusStopped {
+		t.Errorf("States() = %+v, want %s", st, StatusStopped)
+	}
+}
+
+func TestSupervisor_backoff(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		WithBackoff(20*time.Millisecond, 40*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	var starts []time.Time
+	s.Run(ctx, "cbr", func(context.Context) error {
+		starts = append(starts, time.Now())
+		return errors.New("fail")
+	})
+
+	// паузы: 20ms, 40ms, 40ms... - не больше 5 запусков за 150ms
+	if len(starts) < 2 || len(starts) > 5 {
+		t.Fatalf("Run() started task %d times, want 2..5", len(starts))
+	}
+	for i := 1; i < len(starts); i++ {
+		if d := starts[i].Sub(starts[i-1]); d < 20*time.Millisecond {
+			t.Errorf("Run() restart %d after %v, want at least backoff", i, d)
+		}
+	}
+}
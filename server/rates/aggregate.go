@@ -0,0 +1,177 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/pipeline"
+	"xtestserver/pkg/storage"
+)
+
+// Method - способ расчета опорного курса по котировкам бирж.
+type Method string
+
+const (
+	MethodMedian Method = "median" // медиана цен
+	MethodVWAP   Method = "vwap"   // средняя цена, взвешенная по объему торгов
+)
+
+// Aggregator хранит последнюю котировку каждой биржи
+// и рассчитывает по ним опорный курс пары (BTC/USDT, ETH/USDT...).
+// Безопасен для использования из нескольких горутин.
+type Aggregator struct {
+	method       Method
+	maxAge       time.Duration
+	maxDeviation float64
+	now          func() time.Time
+
+	mu     sync.Mutex
+	quotes map[string]rate // последняя котировка по бирже
+}
+
+// AggregatorOption - функция, изменяющая настройки агрегатора.
+type AggregatorOption func(*Aggregator)
+
+// WithMethod устанавливает способ расчета курса.
+func WithMethod(m Method) AggregatorOption {
+	return func(a *Aggregator) {
+		a.method = m
+	}
+}
+
+// WithMaxAge устанавливает возраст, после которого
+// котировка биржи не участвует в расчете.
+func WithMaxAge(d time.Duration) AggregatorOption {
+	return func(a *Aggregator) {
+		if d > 0 {
+			a.maxAge = d
+		}
+	}
+}
+
+// WithMaxDeviation устанавливает допустимое отклонение котировки
+// от медианы в долях (0.02 - 2%). Котировки, отклонившиеся сильнее,
+// не участвуют в расчете. 0 отключает проверку.
+func WithMaxDeviation(f float64) AggregatorOption {
+	return func(a *Aggregator) {
+		if f >= 0 {
+			a.maxDeviation = f
+		}
+	}
+}
+
+// NewAggregator возвращает агрегатор: по умолчанию медиана котировок
+// не старше минуты, отклонившихся от медианы не более чем на 2%.
+func NewAggregator(opts ...AggregatorOption) *Aggregator {
+	a := &Aggregator{
+		method:       MethodMedian,
+		maxAge:       time.Minute,
+		maxDeviation: 0.02,
+		now:          time.Now,
+		quotes:       make(map[string]rate),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Add запоминает котировку биржи q.Source,
+// если она не старше уже известной.
+func (a *Aggregator) Add(q rate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if old, ok := a.quotes[q.Source]; ok && old.Time > q.Time {
+		return
+	}
+	a.quotes[q.Source] = q
+}
+
+// Rate рассчитывает опорный курс по свежим котировкам, отбросив
+// отклонившиеся от медианы. Время курса - время самой свежей из
+// учтенных котировок, объем - их суммарный объем. Выбросы ищутся
+// только среди трех и более котировок: по двум не понять, какая
+// из бирж ошибается. Если отклонились все котировки, курс
+// считается по всем. Возвращает false, если свежих котировок нет.
+func (a *Aggregator) Rate() (rate, bool) {
+	a.mu.Lock()
+	fresh := make([]rate, 0, len(a.quotes))
+	oldest := a.now().Add(-a.maxAge).Unix()
+	for _, q := range a.quotes {
+		if q.Time >= oldest {
+			fresh = append(fresh, q)
+		}
+	}
+	a.mu.Unlock()
+	if len(fresh) == 0 {
+		return rate{}, false
+	}
+
+	kept := fresh
+	if a.maxDeviation > 0 && len(fresh) >= 3 {
+		med := median(fresh)
+		kept = make([]rate, 0, len(fresh))
+		for _, q := range fresh {
+			if math.Abs(q.Value-med)/med <= a.maxDeviation {
+				kept = append(kept, q)
+			}
+		}
+		if len(kept) == 0 {
+			kept = fresh
+		}
+	}
+
+	r := rate{Source: domain.SourceAggregate}
+	for _, q := range kept {
+		r.Volume += q.Volume
+		if q.Time > r.Time {
+			r.Time = q.Time
+		}
+	}
+	r.Value = median(kept)
+	if a.method == MethodVWAP && r.Volume > 0 {
+		var sum float64
+		for _, q := range kept {
+			sum += q.Value * q.Volume
+		}
+		r.Value = sum / r.Volume
+	}
+	return r, true
+}
+
+// median возвращает медиану цен котировок. Сортирует rs.
+func median(rs []rate) float64 {
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Value < rs[j].Value })
+	n := len(rs)
+	if n%2 == 1 {
+		return rs[n/2].Value
+	}
+	return (rs[n/2-1].Value + rs[n/2].Value) / 2
+}
+
+// AggregateStream сохраняет котировки пары на биржах из канала in,
+// добавляет их в агрегатор и после каждой котировки отправляет
+// дальше опорный курс, если его удалось рассчитать.
+// Ошибки сохранения уходят в errs и не мешают расчету.
+func AggregateStream(ctx context.Context, db stor, pair domain.Pair, in <-chan []rate, agg *Aggregator, errs pipeline.Sink) <-chan []rate {
+	return pipeline.New(func(ctx context.Context, quotes []rate, emit func([]rate) bool) error {
+		if len(quotes) == 0 {
+			return nil
+		}
+		_, err := db.AddQuotes(ctx, storage.ConflictIgnore, pair, quotes...)
+		if err != nil {
+			err = fmt.Errorf("aggregate %s quotes: %w", pair, err)
+		}
+		for _, q := range quotes {
+			agg.Add(q)
+		}
+		if r, ok := agg.Rate(); ok {
+			emit([]rate{r})
+		}
+		return err
+	}, errs).Run(ctx, in)
+}
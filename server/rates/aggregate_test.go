@@ -0,0 +1,178 @@
+package rates
+
+import (
+	"context"
+	"testing"
+	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage/memdb"
+)
+
+func TestAggregator_Rate(t *testing.T) {
+	now := time.Unix(1658237004, 0)
+	q := func(source string, age time.Duration, value, volume float64) rate {
+		return rate{Source: source, Time: now.Add(-age).Unix(), Value: value, Volume: volume}
+	}
+
+	tests := []struct {
+		name   string
+		opts   []AggregatorOption
+		quotes []rate
+		want   rate
+		ok     bool
+	}{
+		{
+			name: "median odd",
+			quotes: []rate{
+				q("kucoin", 0, 22_280, 1),
+				q("binance", 2*time.Second, 22_270, 1),
+				q("kraken", time.Second, 22_290, 1),
+			},
+			want: rate{Time: now.Unix(), Value: 22_280, Volume: 3},
+			ok:   true,
+		},
+		{
+			name: "median even",
+			quotes: []rate{
+				q("kucoin", 0, 22_280, 0),
+				q("binance", 0, 22_270, 0),
+			},
+			want: rate{Time: now.Unix(), Value: 22_275},
+			ok:   true,
+		},
+		{
+			name: "outlier",
+			quotes: []rate{
+				q("kucoin", 0, 22_280, 1),
+				q("binance", 0, 22_270, 1),
+				q("kraken", 0, 22_290, 1),
+				q("coinbase", 0, 25_000, 1), // +12%
+			},
+			want: rate{Time: now.Unix(), Value: 22_280, Volume: 3},
+			ok:   true,
+		},
+		{
+			name: "no deviation check",
+			opts: []AggregatorOption{WithMaxDeviation(0)},
+			quotes: []rate{
+				q("kucoin", 0, 20_000, 0),
+				q("binance", 0, 30_000, 0),
+			},
+			want: rate{Time: now.Unix(), Value: 25_000},
+			ok:   true,
+		},
+		{
+			name: "stale",
+			quotes: []rate{
+				q("kucoin", 2*time.Minute, 30_000, 1),
+				q("binance", 10*time.Second, 22_270, 1),
+			},
+			want: rate{Time: now.Add(-10 * time.Second).Unix(), Value: 22_270, Volume: 1},
+			ok:   true,
+		},
+		{
+			name: "vwap",
+			opts: []AggregatorOption{WithMethod(MethodVWAP)},
+			quotes: []rate{
+				q("kucoin", 0, 22_200, 1),
+				q("binance", 0, 22_300, 3),
+			},
+			want: rate{Time: now.Unix(), Value: 22_275, Volume: 4},
+			ok:   true,
+		},
+		{
+			name: "vwap without volume",
+			opts: []AggregatorOption{WithMethod(MethodVWAP)},
+			quotes: []rate{
+				q("kucoin", 0, 22_200, 0),
+				q("binance", 0, 22_300, 0),
+			},
+			want: rate{Time: now.Unix(), Value: 22_250},
+			ok:   true,
+		},
+		{
+			name: "latest per source",
+			quotes: []rate{
+				q("kucoin", time.Second, 22_200, 0),
+				q("kucoin", 0, 22_300, 0),
+				q("kucoin", 2*time.Second, 22_100, 0), // запоздавшая
+			},
+			want: rate{Time: now.Unix(), Value: 22_300},
+			ok:   true,
+		},
+		{
+			name: "two diverging sources",
+			quotes: []rate{
+				q("kucoin", 0, 20_000, 1),
+				q("binance", 0, 30_000, 1),
+			},
+			want: rate{Time: now.Unix(), Value: 25_000, Volume: 2},
+			ok:   true,
+		},
+		{
+			name: "all disagree",
+			quotes: []rate{
+				q("kucoin", 0, 20_000, 0),
+				q("kraken", 0, 20_100, 0),
+				q("binance", 0, 30_000, 0),
+				q("coinbase", 0, 30_100, 0),
+			},
+			want: rate{Time: now.Unix(), Value: 25_050},
+			ok:   true,
+		},
+		{
+			name:   "all stale",
+			quotes: []rate{q("kucoin", time.Hour, 22_200, 0)},
+		},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAggregator(tt.opts...)
+			a.now = func() time.Time { return now }
+			for _, q := range tt.quotes {
+				a.Add(q)
+			}
+
+			got, ok := a.Rate()
+			if ok != tt.ok {
+				t.Fatalf("Rate() ok = %v, want %v (rate %#v)", ok, tt.ok, got)
+			}
+			if !ok {
+				return
+			}
+			tt.want.Source = domain.SourceAggregate
+			if got.Time != tt.want.Time || got.Source != tt.want.Source ||
+				!floatEqual(got.Value, tt.want.Value) || !floatEqual(got.Volume, tt.want.Volume) {
+				t.Errorf("Rate() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateStream(t *testing.T) {
+	now := time.Now().Unix()
+	in := make(chan []rate)
+	out := AggregateStream(context.Background(), memdb.New(), domain.BTCUSDT, in, NewAggregator(), func(err error) {
+		t.Errorf("AggregateStream() = err: %v", err)
+	})
+
+	go func() {
+		in <- []rate{{Source: "kucoin", Time: now, Value: 22_200}}
+		in <- []rate{{Source: "binance", Time: now, Value: 22_300}}
+		close(in)
+	}()
+
+	var got []float64
+	for rs := range out {
+		for _, r := range rs {
+			if r.Source != domain.SourceAggregate {
+				t.Errorf("AggregateStream() source = %q, want %q", r.Source, domain.SourceAggregate)
+			}
+			got = append(got, r.Value)
+		}
+	}
+	if len(got) != 2 || !floatEqual(got[0], 22_200) || !floatEqual(got[1], 22_250) {
+		t.Errorf("AggregateStream() = %v, want [22200 22250]", got)
+	}
+}
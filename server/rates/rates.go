@@ -4,9 +4,11 @@ package rates
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"xtestserver/domain"
+	"xtestserver/pkg/pipeline"
 	"xtestserver/pkg/storage"
 )
 
@@ -15,51 +17,51 @@ type rate = domain.Rate
 
 // ProcessStream получает канал входящих данных
 // и функцию для обработки этих данных. Пропускает данные
-// через функцию и возвращает канал выходных данных и канал ошибок.
-func ProcessStream(ctx context.Context, db stor, in <-chan []rate, procFunc ProcessFunc) (<-chan []byte, <-chan error) {
-
-	out := make(chan []byte)
-	errs := make(chan error, 2)
-
-	go func() {
-
-		defer func() {
-			close(out)
-			close(errs)
-		}()
-
-		f := procFunc(db, out, errs)
-
-		for v := range in {
-			f(ctx, v)
-		}
-	}()
-
-	return out, errs
+// через функцию и возвращает канал выходных данных,
+// ошибки обработки уходят в errs.
+// Паника в обработчике останавливает стадию.
+func ProcessStream(ctx context.Context, db stor, in <-chan []rate, procFunc ProcessFunc, errs pipeline.Sink) <-chan []byte {
+	return pipeline.New(procFunc(db), errs).Run(ctx, in)
 }
 
 // ProcessFunc возвращает функцию, которая способна
 // обработать срез входящих данных и отправить обработанный результат
 // в канал выходящих данных.
-type ProcessFunc func(db stor, out chan<- []byte, errs chan<- error) processor
-type processor func(ctx context.Context, r []rate)
+type ProcessFunc func(db stor) processor
+type processor = pipeline.Func[[]rate, []byte]
 
 // FiatProcessFunc это ProcessFunc, которая
 // возвращает обработчик курсов фиатных валют.
-func FiatProcessFunc(db stor, _ chan<- []byte, errs chan<- error) processor {
-	return func(ctx context.Context, r []rate) {
-		if err := db.AddFiats(ctx, r...); err != nil {
-			errs <- fmt.Errorf("process fiats update stream: %w", err)
+func FiatProcessFunc(db stor) processor {
+	return func(ctx context.Context, r []rate, _ func([]byte) bool) error {
+		// ЦБ может исправить курс за тот же день,
+		// поэтому храним последнее значение
+		if _, err := db.AddFiats(ctx, storage.ConflictOverwrite, r...); err != nil {
+			return fmt.Errorf("process fiats update stream: %w", err)
 		}
+		return nil
 	}
 }
 
 // BtcProcessFunc это ProcessFunc, которая
 // возвращает обработчик курса BTC/USD.
-func BtcProcessFunc(db stor, out chan<- []byte, errs chan<- error) processor {
+func BtcProcessFunc(db stor) processor {
+	return PairProcessFunc(domain.BTCUSDT)(db)
+}
+
+// PairProcessFunc возвращает ProcessFunc, которая сохраняет
+// курс пары и публикует его в канал pair.String(), а для пар
+// к USDT - еще и курсы фиатных валют в канал pair.Crosses().
+func PairProcessFunc(pair domain.Pair) ProcessFunc {
+	return func(db stor) processor {
+		return pairProcessor(db, pair)
+	}
+}
+
+func pairProcessor(db stor, pair domain.Pair) processor {
 	// ship упаковывает данные, сериализует и отправляет
-	// в канал, если ошибка сериализации, то ошибка в канал ошибок
-	ship := func(label string, m map[string]any) {
+	// дальше, если ошибка сериализации, то возвращает ее
+	ship := func(emit func([]byte) bool, label string, m map[string]any) error {
 		box := struct {
 			Label string         `json:"label"`
 			Data  map[string]any `json:"data"`
@@ -68,73 +70,92 @@ func BtcProcessFunc(db stor, out chan<- []byte, errs chan<- error) processor {
 			Data:  m,
 		}
 		b, err := json.Marshal(box)
-		if err == nil {
-			out <- b
-		} else {
-			errs <- fmt.Errorf("process btc update stream: %w", err)
-		}
-	}
-
-	// upd отправляет обновления в таблицу BTC/USD
-	upd := func(ctx context.Context, r rate) {
-		if err := db.AddBtcRate(ctx, r); err != nil {
-			errs <- err
+		if err != nil {
+			return fmt.Errorf("process %s update stream: %w", pair, err)
 		}
+		emit(b)
+		return nil
 	}
 
-	// isNew сравнивает текущее значение BTC/USD с предыдущим
-	isNew := func(ctx context.Context, new float64) bool {
-		old, err := db.BtcRate(ctx, storage.Filter{Limit: 1})
+	// isNew сравнивает текущее значение пары с предыдущим
+	isNew := func(ctx context.Context, new float64) (bool, error) {
+		old, err := db.PairRate(ctx, pair, storage.Filter{Limit: 1})
 		if err != nil {
-			errs <- err
-			return true
+			return true, err
 		}
 		if len(old) == 0 {
-			return true
+			return true, nil
 		}
-		return !floatEqual(old[0].Value, new) // если не равны
+		return !floatEqual(old[0].Value, new), nil // если не равны
 	}
 
-	calc := func(ctx context.Context, r rate) map[string]any {
-		rates, err := CalcRates(ctx, db, r.Value)
-		if err != nil {
-			errs <- fmt.Errorf("process btc update stream: %w", err)
-		}
-		return rates
-	}
-
-	return func(ctx context.Context, r []rate) {
+	return func(ctx context.Context, r []rate, emit func([]byte) bool) error {
 		// ожидаем только один элемент
 		if len(r) == 0 {
-			return
+			return nil
 		}
 
-		if isNew(ctx, r[0].Value) {
-			go upd(ctx, r[0])
-			ship("BTC/USDT", domain.RateMapTimestamp(r))
-			ship("BTC/*", calc(ctx, r[0]))
+		// ошибки не прерывают обработку,
+		// возвращаем их все вместе
+		var errs []error
+		check := func(err error) {
+			if err != nil {
+				errs = append(errs, err)
+			}
 		}
+
+		ok, err := isNew(ctx, r[0].Value)
+		check(err)
+		if ok {
+			// сохраняем до публикации, чтобы обработчик
+			// не завершился посреди записи при остановке.
+			// Сводные курсы за одну секунду делят метку времени,
+			// поэтому храним последнее значение - то, что публикуем
+			_, err := db.AddPairRate(ctx, storage.ConflictOverwrite, pair, r[0])
+			check(err)
+			m := domain.RateMapTimestamp(r)
+			if r[0].Source != "" {
+				m["source"] = r[0].Source
+			}
+			check(ship(emit, pair.String(), m))
+			if crosses := pair.Crosses(); crosses != "" {
+				// в шину - кросс-курсы через рубль (ЦБ)
+				rates, err := CalcRates(ctx, db, domain.BaseRUB, r[0].Value)
+				if err != nil {
+					err = fmt.Errorf("process %s update stream: %w", pair, err)
+				}
+				check(err)
+				check(ship(emit, crosses, rates))
+			}
+		}
+		return errors.Join(errs...)
 	}
 }
 
-// CalcRates рассчитывает курс фиатных валют по отношению к BTC
-func CalcRates(ctx context.Context, db stor, btsusdt float64) (map[string]any, error) {
-	rub, err := db.RUBUSDRate(ctx) // получаем курс рубля к доллару
+// CalcRates рассчитывает курс фиатных валют по отношению
+// к криптовалюте, курс которой к доллару - btsusdt, через
+// курсы к базовой валюте base (RUB - ЦБ, EUR - ЕЦБ).
+func CalcRates(ctx context.Context, db stor, base string, btsusdt float64) (map[string]any, error) {
+	usd, err := db.USDRate(ctx, base) // получаем курс доллара в базовой валюте
 	if err != nil {
 		return nil, err
 	}
+	if usd.Nominal == 0 {
+		return nil, fmt.Errorf("calc rates: USD/%s nominal is zero", base)
+	}
 
-	rates, err := db.FiatsCurrent(ctx) // получаем курсы валют к рублю
+	rates, err := db.FiatsCurrent(ctx, base) // получаем курсы валют к базовой
 	if err != nil {
 		return nil, err
 	}
 
-	rcc := btsusdt * rub.Value // кросс-курс BTC/RUB
+	// кросс-курс BTC/RUB (ETH/EUR...)
+	rcc := btsusdt * usd.Value / float64(usd.Nominal)
 	m := make(map[string]any, len(rates)+1)
-	m["RUB"] = rcc
+	m[base] = rcc
 	for i := range rates {
 		if rates[i].Nominal != 0 {
-			// считаем кросс-курс валют к биткоину через рубль :)
+			// считаем кросс-курс валют к биткоину через базовую валюту :)
 			m[rates[i].CharCode] = rcc * float64(rates[i].Nominal) / rates[i].Value
 		}
 	}
@@ -5,8 +5,11 @@ import (
 	"context"
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+	"xtestserver/domain"
+	"xtestserver/pkg/storage"
 	"xtestserver/pkg/storage/memdb"
 )
 
@@ -33,21 +36,15 @@ var testBox2 = struct {
 
 func TestProcessStream(t *testing.T) {
 	out := make(chan []rate)
-	vals, errs := ProcessStream(context.Background(), memdb.New(), out, BtcProcessFunc)
+	vals := ProcessStream(context.Background(), memdb.New(), out, BtcProcessFunc, func(err error) {
+		t.Errorf("ProcessStream() = err: %v", err)
+	})
 
 	go func() {
 		out <- []rate{btcusd}
 		close(out)
 	}()
 
-	done := make(chan struct{})
-	go func() {
-		for err := range errs {
-			t.Errorf("ProcessStream() = err: %v", err)
-		}
-		close(done)
-	}()
-
 	select {
 	case v1 := <-vals:
 		// tm := domain.RateMapTimestamp([]rate{btcusd})
@@ -87,5 +84,201 @@ func TestProcessStream(t *testing.T) {
 		t.Fatal("ProcessStream() = err: didn't receive value from channel")
 	}
 
-	<-done
+	if _, ok := <-vals; ok {
+		t.Error("ProcessStream() sent extra value")
+	}
+}
+
+func TestProcessStream_panic(t *testing.T) {
+	in := make(chan []rate, 1)
+	in <- []rate{btcusd}
+	close(in)
+	panicky := func(stor) processor {
+		return func(context.Context, []rate, func([]byte) bool) error { panic("bad processor") }
+	}
+	var errs []error
+	vals := ProcessStream(context.Background(), memdb.New(), in, panicky, func(err error) { errs = append(errs, err) })
+
+	if _, ok := <-vals; ok {
+		t.Error("ProcessStream() sent value after panic")
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "panic: bad processor") {
+		t.Errorf("ProcessStream() = errors %v, want one panic error", errs)
+	}
+}
+
+func TestBtcProcessFunc_source(t *testing.T) {
+	var got []map[string]any
+	err := BtcProcessFunc(memdb.New())(context.Background(), []rate{{Time: 1658423781, Value: 22_918.90, Source: "aggregate"}},
+		func(b []byte) bool {
+			var box struct {
+				Label string         `json:"label"`
+				Data  map[string]any `json:"data"`
+			}
+			if err := json.Unmarshal(b, &box); err != nil {
+				t.Fatalf("BtcProcessFunc() = err: %v", err)
+			}
+			if box.Label == "BTC/USDT" {
+				got = append(got, box.Data)
+			}
+			return true
+		})
+	if err != nil {
+		t.Fatalf("BtcProcessFunc() = err: %v", err)
+	}
+	if len(got) != 1 || got[0]["source"] != "aggregate" {
+		t.Errorf("BtcProcessFunc() BTC/USDT = %v, want source aggregate", got)
+	}
+}
+
+func TestPairProcessFunc_labels(t *testing.T) {
+	tests := []struct {
+		pair domain.Pair
+		want []string
+	}{
+		{pair: domain.Pair{Base: "ETH", Quote: "USDT"}, want: []string{"ETH/USDT", "ETH/*"}},
+		// кросс-курсы считаются только через доллар
+		{pair: domain.Pair{Base: "ETH", Quote: "BTC"}, want: []string{"ETH/BTC"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := PairProcessFunc(tt.pair)(memdb.New())(context.Background(), []rate{{Time: 1658423781, Value: 1_543.12}},
+			func(b []byte) bool {
+				var box struct {
+					Label string `json:"label"`
+				}
+				if err := json.Unmarshal(b, &box); err != nil {
+					t.Fatalf("PairProcessFunc() = err: %v", err)
+				}
+				got = append(got, box.Label)
+				return true
+			})
+		if err != nil {
+			t.Fatalf("PairProcessFunc() = err: %v", err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PairProcessFunc(%v) labels = %v, want %v", tt.pair, got, tt.want)
+		}
+	}
+}
+
+// pairDB хранит курсы пары по метке времени
+// поверх остальных данных из memdb.
+type pairDB struct {
+	*memdb.MemDB
+	rates map[int64]rate
+}
+
+func (db *pairDB) AddPairRate(_ context.Context, policy storage.Conflict, _ domain.Pair, r rate) (storage.Result, error) {
+	if _, ok := db.rates[r.Time]; ok && policy != storage.ConflictOverwrite {
+		return storage.Result{Skipped: 1}, nil
+	}
+	db.rates[r.Time] = r
+	return storage.Result{Inserted: 1}, nil
+}
+
+func (db *pairDB) PairRate(_ context.Context, _ domain.Pair, _ storage.Filter) ([]rate, error) {
+	var last []rate
+	for _, r := range db.rates {
+		if len(last) == 0 || r.Time > last[0].Time {
+			last = []rate{r}
+		}
+	}
+	return last, nil
+}
+
+func TestPairProcessFunc_sameTime(t *testing.T) {
+	db := &pairDB{MemDB: memdb.New(), rates: make(map[int64]rate)}
+	process := BtcProcessFunc(db)
+
+	// два сводных курса за одну секунду
+	var published []float64
+	for _, v := range []float64{22_918.90, 22_920.15} {
+		r := rate{Time: 1658423781, Value: v, Source: domain.SourceAggregate}
+		err := process(context.Background(), []rate{r}, func(b []byte) bool {
+			var box struct {
+				Label string `json:"label"`
+				Data  struct {
+					Value float64 `json:"value"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(b, &box); err != nil {
+				t.Fatalf("BtcProcessFunc() = err: %v", err)
+			}
+			if box.Label == "BTC/USDT" {
+				published = append(published, box.Data.Value)
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("BtcProcessFunc() = err: %v", err)
+		}
+	}
+
+	if len(published) != 2 {
+		t.Fatalf("BtcProcessFunc() published = %v, want two rates", published)
+	}
+	// в БД - последний опубликованный курс
+	stored := db.rates[1658423781]
+	if last := published[len(published)-1]; stored.Value != last {
+		t.Errorf("BtcProcessFunc() stored = %v, want last published %v", stored.Value, last)
+	}
+}
+
+// ecbDB - курсы ЕЦБ к евро поверх курсов ЦБ из memdb.
+type ecbDB struct {
+	*memdb.MemDB
+}
+
+var ecbUSD = rate{CharCode: "USD", Nominal: 10, Time: 1658448000, Value: 9.8058, Source: domain.SourceECB, Base: domain.BaseEUR}
+var ecbHUF = rate{CharCode: "HUF", Nominal: 1000, Time: 1658448000, Value: 2.4803, Source: domain.SourceECB, Base: domain.BaseEUR}
+
+func (db ecbDB) USDRate(ctx context.Context, base string) (rate, error) {
+	if base == domain.BaseEUR {
+		return ecbUSD, nil
+	}
+	return db.MemDB.USDRate(ctx, base)
+}
+
+func (db ecbDB) FiatsCurrent(ctx context.Context, base string) ([]rate, error) {
+	if base == domain.BaseEUR {
+		return []rate{ecbUSD, ecbHUF}, nil
+	}
+	return db.MemDB.FiatsCurrent(ctx, base)
+}
+
+func TestCalcRates(t *testing.T) {
+	db := ecbDB{memdb.New()}
+	tests := []struct {
+		base string
+		want map[string]float64
+	}{
+		{base: domain.BaseRUB, want: map[string]float64{
+			"RUB": 100 * 56.4783,
+			"USD": 100 * 56.4783 / 56.4783,
+			"HUF": 100 * 56.4783 * 100 / 14.3324,
+			"GBP": 100 * 56.4783 / 67.7627,
+		}},
+		{base: domain.BaseEUR, want: map[string]float64{
+			"EUR": 100 * 0.98058,
+			"USD": 100 * 0.98058 * 10 / 9.8058,
+			"HUF": 100 * 0.98058 * 1000 / 2.4803,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			got, err := CalcRates(context.Background(), db, tt.base, 100)
+			if err != nil {
+				t.Fatalf("CalcRates() = err: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("CalcRates() = %v, want %v", got, tt.want)
+			}
+			for code, want := range tt.want {
+				if v, ok := got[code].(float64); !ok || !floatEqual(v, want) {
+					t.Errorf("CalcRates()[%s] = %v, want %v", code, got[code], want)
+				}
+			}
+		})
+	}
 }